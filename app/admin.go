@@ -0,0 +1,65 @@
+package main
+
+import (
+	"go/djan/app/apierror"
+	"go/djan/app/ent/user"
+	"net/http"
+)
+
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+func (req *RoleRequest) validate(v *validator) {
+	if v.required("role", req.Role != "") {
+		v.oneOf("role", user.RoleValidator(user.Role(req.Role)),
+			user.RoleAdmin.String(), user.RoleEditor.String(), user.RoleReader.String())
+	}
+}
+
+// grantRole sets the role of a user. Roles are checked against the stored
+// user, so the change takes effect on its next request.
+func grantRole(w http.ResponseWriter, r *http.Request) {
+	role_json, err := decodeJSON[RoleRequest](w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	setUserRole(w, r, user.Role(role_json.Role))
+}
+
+// revokeRole resets the role of a user back to the default reader role
+func revokeRole(w http.ResponseWriter, r *http.Request) {
+	setUserRole(w, r, user.DefaultRole)
+}
+
+func setUserRole(w http.ResponseWriter, r *http.Request, role user.Role) {
+	client := GetClient()
+
+	id, err := pathID(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Admins can't lock themselves out of the admin endpoints
+	actor := GetUserFromContext(r.Context())
+	if actor != nil && actor.ID == id && role != user.RoleAdmin {
+		writeError(w, r, apierror.BadRequest("Admins can't revoke their own role"))
+		return
+	}
+
+	update := client.User.UpdateOneID(id).SetRole(role)
+	// Superusers are always admins, so demoting one ends its superuser status
+	if role != user.RoleAdmin {
+		update.SetIsSuperuser(false)
+	}
+	updatedUser, err := update.Save(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, updatedUser)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"go/djan/app/ent/user"
+)
+
+func TestRoles(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	alice := client.User.Create().SetName("alice").SetPassword("hash").SetRole(user.RoleAdmin).SaveX(ctx)
+	// createsuperuser makes superusers, which are admins whatever their role
+	bob := client.User.Create().SetName("bob").SetPassword("hash").SetIsSuperuser(true).SaveX(ctx)
+	carol := client.User.Create().SetName("carol").SetPassword("hash").SaveX(ctx)
+	aliceToken, bobToken, carolToken := accessToken(t, alice), accessToken(t, bob), accessToken(t, carol)
+
+	h := newRouter()
+	path := func(id int) string { return "/api/admin/user/" + strconv.Itoa(id) + "/role" }
+
+	if code, out := doJSON(t, h, "PUT", path(carol.ID), carolToken, M{"role": "editor"}); code != http.StatusForbidden {
+		t.Errorf("reader granting a role: got %d %v", code, out)
+	}
+	if code, out := doJSON(t, h, "PUT", path(carol.ID), bobToken, M{"role": "editor"}); code != http.StatusOK || out["role"] != "editor" {
+		t.Errorf("superuser granting a role: got %d %v", code, out)
+	}
+	if code, out := doJSON(t, h, "DELETE", path(alice.ID), aliceToken, nil); code != http.StatusBadRequest {
+		t.Errorf("admin revoking their own role: got %d %v", code, out)
+	}
+
+	// The revoked superuser loses admin access with the token it holds
+	if code, out := doJSON(t, h, "DELETE", path(bob.ID), aliceToken, nil); code != http.StatusOK || out["role"] != "reader" {
+		t.Fatalf("revoking the role of a superuser: got %d %v", code, out)
+	}
+	if client.User.GetX(ctx, bob.ID).IsSuperuser {
+		t.Error("bob is still a superuser")
+	}
+	if code, out := doJSON(t, h, "PUT", path(carol.ID), bobToken, M{"role": "admin"}); code != http.StatusForbidden {
+		t.Errorf("demoted superuser granting a role: got %d %v", code, out)
+	}
+	if code, out := doJSON(t, h, "PUT", path(bob.ID), accessToken(t, client.User.GetX(ctx, bob.ID)), M{"role": "admin"}); code != http.StatusForbidden {
+		t.Errorf("demoted superuser with a new token: got %d %v", code, out)
+	}
+}
@@ -0,0 +1,229 @@
+// Package apierror defines the errors of the API. They are written as RFC 9457
+// problem details, carrying a stable code clients can rely on and, for
+// invalid input, the fields at fault.
+package apierror
+
+import (
+	"errors"
+	"go/djan/app/ent"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// ContentType is the media type of problem details
+const ContentType = "application/problem+json"
+
+// Codes identify the kind of an error. They are part of the API, existing
+// codes must not change.
+const (
+	CodeBadRequest           = "bad_request"
+	CodeMalformedJSON        = "malformed_json"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeInvalidParameter     = "invalid_parameter"
+	CodeValidationFailed     = "validation_failed"
+	CodeUnauthorized         = "unauthorized"
+	CodeInvalidCredentials   = "invalid_credentials"
+	CodeInvalidMFACode       = "invalid_mfa_code"
+	CodeInvalidToken         = "invalid_token"
+	CodeTokenExpired         = "token_expired"
+	CodeTokenRevoked         = "token_revoked"
+	CodeTokenReused          = "token_reused"
+	CodeForbidden            = "forbidden"
+	CodeOriginNotAllowed     = "origin_not_allowed"
+	CodeEmailNotVerified     = "email_not_verified"
+	CodeInsufficientScope    = "insufficient_scope"
+	CodeNotFound             = "not_found"
+	CodeConflict             = "conflict"
+	CodeRateLimited          = "rate_limited"
+	CodeAccountLocked        = "account_locked"
+	CodeInternal             = "internal_error"
+)
+
+// Codes of the field errors
+const (
+	FieldInvalid       = "invalid"
+	FieldRequired      = "required"
+	FieldTooShort      = "too_short"
+	FieldTooLong       = "too_long"
+	FieldInvalidFormat = "invalid_format"
+	FieldOutOfRange    = "out_of_range"
+	FieldInvalidChoice = "invalid_choice"
+	FieldTooCommon     = "too_common"
+)
+
+// FieldError describes why the value of a field was rejected. Field is the
+// name of the JSON field or query parameter.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is an error answered to the client with the given status
+type Error struct {
+	Status int
+	Code   string
+	Detail string
+	Fields []FieldError
+	// Err is the underlying error. It is logged, never sent to clients.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Detail + ": " + e.Err.Error()
+	}
+	return e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// WithFields returns a copy of the error with the fields at fault added, so
+// that package level errors are never modified by the requests using them
+func (e *Error) WithFields(fields ...FieldError) *Error {
+	c := *e
+	c.Fields = append(slices.Clip(e.Fields), fields...)
+	return &c
+}
+
+func New(status int, code, detail string) *Error {
+	return &Error{Status: status, Code: code, Detail: detail}
+}
+
+func BadRequest(detail string) *Error {
+	return New(http.StatusBadRequest, CodeBadRequest, detail)
+}
+
+// InvalidParameter rejects the value of a query or path parameter
+func InvalidParameter(name, message string) *Error {
+	return New(http.StatusBadRequest, CodeInvalidParameter, "Invalid "+name+" parameter").
+		WithFields(FieldError{Field: name, Code: FieldInvalid, Message: message})
+}
+
+// Validation rejects a request body whose fields are invalid
+func Validation(fields ...FieldError) *Error {
+	return New(http.StatusUnprocessableEntity, CodeValidationFailed, "The request contains invalid fields").
+		WithFields(fields...)
+}
+
+func Unauthorized(code, detail string) *Error {
+	return New(http.StatusUnauthorized, code, detail)
+}
+
+func Forbidden(detail string) *Error {
+	return New(http.StatusForbidden, CodeForbidden, detail)
+}
+
+func NotFound(detail string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, detail)
+}
+
+func Conflict(detail string) *Error {
+	return New(http.StatusConflict, CodeConflict, detail)
+}
+
+// TooManyRequests refuses a request until the client waits
+func TooManyRequests(code, detail string) *Error {
+	return New(http.StatusTooManyRequests, code, detail)
+}
+
+// Internal hides err from the client behind a generic 500
+func Internal(err error) *Error {
+	return &Error{
+		Status: http.StatusInternalServerError,
+		Code:   CodeInternal,
+		Detail: "The server failed to process the request",
+		Err:    err,
+	}
+}
+
+// From returns the API error of err:
+//   - API errors, and errors with an APIError method, as they are
+//   - ent not found errors as 404
+//   - ent constraint errors, such as unique violations, as 409
+//   - ent validation errors as 422, with the field at fault
+//   - any other error as an internal error
+func From(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	var converter interface{ APIError() *Error }
+	if errors.As(err, &converter) {
+		return converter.APIError()
+	}
+
+	var notFound *ent.NotFoundError
+	if errors.As(err, &notFound) {
+		e := NotFound(capitalize(strings.TrimPrefix(notFound.Error(), "ent: ")))
+		e.Err = err
+		return e
+	}
+	var constraint *ent.ConstraintError
+	if errors.As(err, &constraint) {
+		e := Conflict("The request conflicts with the current state of the resource")
+		e.Err = err
+		return e
+	}
+	var validation *ent.ValidationError
+	if errors.As(err, &validation) {
+		e := Validation(FieldError{
+			Field:   validation.Name,
+			Code:    FieldInvalid,
+			Message: validationMessage(validation),
+		})
+		e.Err = err
+		return e
+	}
+	return Internal(err)
+}
+
+// validationMessage returns the message of the validator which failed, or
+// the message of ent without its prefix for missing fields
+func validationMessage(err *ent.ValidationError) string {
+	inner := errors.Unwrap(err)
+	if inner == nil {
+		return "is invalid"
+	}
+	if cause := errors.Unwrap(inner); cause != nil {
+		return cause.Error()
+	}
+	return strings.TrimPrefix(inner.Error(), "ent: ")
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// Problem is the RFC 9457 representation of an error
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// Problem returns the problem details of the error, for the request of the
+// given path. Errors are told apart by their code, so the type is the
+// default about:blank and the title the HTTP status text.
+func (e *Error) Problem(instance string) Problem {
+	return Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(e.Status),
+		Status:   e.Status,
+		Detail:   e.Detail,
+		Instance: instance,
+		Code:     e.Code,
+		Errors:   e.Fields,
+	}
+}
@@ -0,0 +1,23 @@
+package apierror
+
+import "testing"
+
+func TestWithFieldsCopies(t *testing.T) {
+	sentinel := NotFound("Not found")
+	a := sentinel.WithFields(FieldError{Field: "a"})
+	b := sentinel.WithFields(FieldError{Field: "b"})
+	if len(sentinel.Fields) != 0 {
+		t.Fatalf("sentinel has fields %v", sentinel.Fields)
+	}
+	if len(a.Fields) != 1 || a.Fields[0].Field != "a" || len(b.Fields) != 1 || b.Fields[0].Field != "b" {
+		t.Fatalf("got %v and %v", a.Fields, b.Fields)
+	}
+
+	// Fields appended to a copy never overwrite those of another copy
+	base := Validation(FieldError{Field: "x"})
+	c := base.WithFields(FieldError{Field: "c"})
+	d := base.WithFields(FieldError{Field: "d"})
+	if c.Fields[1].Field != "c" || d.Fields[1].Field != "d" || len(base.Fields) != 1 {
+		t.Fatalf("got %v, %v and %v", base.Fields, c.Fields, d.Fields)
+	}
+}
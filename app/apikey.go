@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/djan/app/apierror"
+	"go/djan/app/ent"
+	"go/djan/app/ent/apikey"
+	"go/djan/app/ent/schema"
+	"go/djan/app/ent/user"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/o1egl/paseto"
+)
+
+const (
+	apiKeyContextKey = contextKey("api_key")
+	// apiKeyPrefix starts every key, so that leaked keys are easy to scan for
+	apiKeyPrefix = "djan_"
+	// apiKeyShownLen characters of a key are kept to recognize it
+	apiKeyShownLen = len(apiKeyPrefix) + 6
+	// lastUsedPrecision limits the writes of busy keys
+	lastUsedPrecision = time.Minute
+)
+
+var errAPIKeyNotAllowed = apierror.Forbidden("API keys can not be used for this request, log in instead")
+
+// apiKeyFromRequest extracts the key of the "X-API-Key: <key>" or
+// "Authorization: ApiKey <key>" header
+func apiKeyFromRequest(r *http.Request) (string, bool) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key, true
+	}
+	scheme, key, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if found && strings.EqualFold(scheme, "ApiKey") && key != "" {
+		return key, true
+	}
+	return "", false
+}
+
+// authenticateAPIKey returns the user of a key along with the claims it
+// grants, which are those of an access token of the user
+func authenticateAPIKey(ctx context.Context, client *ent.Client, key string) (*ent.APIKey, *paseto.JSONToken, error) {
+	invalidKey := apierror.Unauthorized(apierror.CodeInvalidToken, "Invalid API key")
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, nil, invalidKey
+	}
+	stored, err := client.APIKey.
+		Query().
+		Where(apikey.KeyHash(hashToken(key))).
+		WithUser().
+		Only(ctx)
+	if ent.IsNotFound(err) {
+		return nil, nil, invalidKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	if stored.ExpiresAt != nil && stored.ExpiresAt.Before(now) {
+		return nil, nil, apierror.Unauthorized(apierror.CodeTokenExpired, "API key has expired")
+	}
+
+	if stored.LastUsedAt == nil || now.Sub(*stored.LastUsedAt) >= lastUsedPrecision {
+		if err := stored.Update().SetLastUsedAt(now).Exec(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	jsonToken := &paseto.JSONToken{
+		Subject:  strconv.Itoa(stored.Edges.User.ID),
+		IssuedAt: stored.CreatedAt,
+	}
+	if stored.ExpiresAt != nil {
+		jsonToken.Expiration = *stored.ExpiresAt
+	}
+	jsonToken.Set(roleClaim, effectiveRole(stored.Edges.User).String())
+	// Keys never grant more than the user may, such as user:admin once
+	// the user is no longer an admin
+	granted := apiKeyScopes(stored)
+	if len(granted) == 0 {
+		return nil, nil, apierror.New(http.StatusForbidden, apierror.CodeInsufficientScope, "The API key grants no scope the user has")
+	}
+	jsonToken.Set(scopeClaim, strings.Join(granted, " "))
+	return stored, jsonToken, nil
+}
+
+// apiKeyScopes returns the scopes a key grants, keys created before scopes
+// existed grant those of a login
+func apiKeyScopes(k *ent.APIKey) []string {
+	allowed := scopesOf(k.Edges.User)
+	if len(k.Scopes) == 0 {
+		return allowed
+	}
+	var granted []string
+	for _, scope := range k.Scopes {
+		if slices.Contains(allowed, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	return granted
+}
+
+// GetAPIKeyFromContext retrieves the API key the request was authenticated
+// with, nil for tokens
+func GetAPIKeyFromContext(ctx context.Context) *ent.APIKey {
+	if k, ok := ctx.Value(apiKeyContextKey).(*ent.APIKey); ok {
+		return k
+	}
+	return nil
+}
+
+// rejectAPIKeys refuses the requests authenticated with an API key, for the
+// credentials of the user, so that a leaked key can't take over the account.
+// It must be wrapped by authenticateUser.
+func rejectAPIKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if GetAPIKeyFromContext(r.Context()) != nil {
+			writeError(w, r, errAPIKeyNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type APIKeyCreateRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (req *APIKeyCreateRequest) validate(v *validator) {
+	if v.required("name", req.Name != "") {
+		v.length("name", req.Name, 1, schema.APIKeyNameMaxLen)
+	}
+	for i, scope := range req.Scopes {
+		if !slices.Contains(apiScopes, scope) {
+			v.add(fmt.Sprintf("scopes[%d]", i), apierror.FieldInvalidChoice, "must be one of "+strings.Join(apiScopes, ", "))
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		v.add("expires_at", apierror.FieldOutOfRange, "must be in the future")
+	}
+}
+
+// createAPIKey creates a key of the authenticated user. The key is only
+// returned by this request, the hash is stored.
+func createAPIKey(w http.ResponseWriter, r *http.Request) {
+	client := GetClient()
+	key_json, err := decodeJSON[APIKeyCreateRequest](w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Keys get the scopes of a login unless narrowed, and can't be granted
+	// scopes the user lacks
+	user := GetUserFromContext(r.Context())
+	scopes := key_json.Scopes
+	if len(scopes) == 0 {
+		scopes = scopesOf(user)
+	}
+	for i, scope := range scopes {
+		if !slices.Contains(scopesOf(user), scope) {
+			writeError(w, r, apierror.Validation(apierror.FieldError{
+				Field:   fmt.Sprintf("scopes[%d]", i),
+				Code:    apierror.FieldInvalidChoice,
+				Message: "is not granted to the user",
+			}))
+			return
+		}
+	}
+
+	secret, err := newOpaqueToken()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	key := apiKeyPrefix + secret
+
+	stored, err := client.APIKey.
+		Create().
+		SetName(key_json.Name).
+		SetPrefix(key[:apiKeyShownLen]).
+		SetKeyHash(hashToken(key)).
+		SetScopes(slices.Compact(slices.Sorted(slices.Values(scopes)))).
+		SetNillableExpiresAt(key_json.ExpiresAt).
+		SetUser(user).
+		Save(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, M{
+		"api_key": stored,
+		"key":     key,
+	})
+}
+
+// listAPIKeys lists the keys of the authenticated user, newest first
+func listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	client := GetClient()
+	keys, err := client.APIKey.
+		Query().
+		Where(apikey.HasUserWith(user.ID(GetUserFromContext(r.Context()).ID))).
+		Order(ent.Desc(apikey.FieldCreatedAt), ent.Desc(apikey.FieldID)).
+		All(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, M{"data": keys})
+}
+
+// revokeAPIKey deletes a key of the authenticated user, which is refused from
+// then on
+func revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	client := GetClient()
+	id, err := pathID(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	n, err := client.APIKey.
+		Delete().
+		Where(apikey.ID(id), apikey.HasUserWith(user.ID(GetUserFromContext(r.Context()).ID))).
+		Exec(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if n == 0 {
+		writeError(w, r, apierror.NotFound("API key not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, M{"message": "API key revoked"})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"go/djan/app/apierror"
+	"go/djan/app/ent"
+)
+
+// doAPIKey sends a request authenticated with the given header
+func doAPIKey(t *testing.T, h http.Handler, method, path, header, value string) (int, M) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Origin", "http://localhost")
+	req.Header.Set(header, value)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	out := M{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return rec.Code, out
+}
+
+// newAPIKey creates a key of u through the API
+func newAPIKey(t *testing.T, h http.Handler, u *ent.User, body M) (int, string) {
+	t.Helper()
+	code, out := doJSON(t, h, "POST", "/api/user/me/api-keys", accessToken(t, u), body)
+	if code != http.StatusCreated {
+		t.Fatalf("creating a key: got %d %v", code, out)
+	}
+	id := int(out["api_key"].(map[string]any)["id"].(float64))
+	return id, out["key"].(string)
+}
+
+func TestAPIKeys(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	u := client.User.Create().SetName("alice").SetPassword("hash").SaveX(ctx)
+	h := newRouter(CORSMiddleware)
+	id, key := newAPIKey(t, h, u, M{"name": "export"})
+
+	for _, auth := range [][2]string{{"X-API-Key", key}, {"Authorization", "ApiKey " + key}} {
+		if code, out := doAPIKey(t, h, "GET", "/api/blog/", auth[0], auth[1]); code != http.StatusOK {
+			t.Errorf("%s header: got %d %v", auth[0], code, out)
+		}
+	}
+	if code, out := doAPIKey(t, h, "GET", "/api/blog/", "X-API-Key", key+"x"); code != http.StatusUnauthorized || out["code"] != apierror.CodeInvalidToken {
+		t.Errorf("wrong key: got %d %v", code, out)
+	}
+	if k := client.APIKey.GetX(ctx, id); k.LastUsedAt == nil || time.Since(*k.LastUsedAt) > time.Minute {
+		t.Errorf("last used at %v", k.LastUsedAt)
+	}
+
+	// Keys can't revoke keys, which would let a leaked key lock the user out
+	path := "/api/user/me/api-keys/" + strconv.Itoa(id)
+	if code, out := doAPIKey(t, h, "DELETE", path, "X-API-Key", key); code != http.StatusForbidden || out["code"] != apierror.CodeForbidden {
+		t.Errorf("revoking with a key: got %d %v", code, out)
+	}
+	if code, out := doJSON(t, h, "DELETE", path, accessToken(t, u), nil); code != http.StatusOK {
+		t.Fatalf("revoking: got %d %v", code, out)
+	}
+	if code, out := doAPIKey(t, h, "GET", "/api/blog/", "X-API-Key", key); code != http.StatusUnauthorized || out["code"] != apierror.CodeInvalidToken {
+		t.Errorf("revoked key: got %d %v", code, out)
+	}
+}
+
+func TestAPIKeyScopesAndExpiry(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	u := client.User.Create().SetName("alice").SetPassword("hash").SaveX(ctx)
+	h := newRouter(CORSMiddleware)
+
+	_, key := newAPIKey(t, h, u, M{"name": "export", "scopes": []string{ScopeBlogRead}})
+	if code, out := doAPIKey(t, h, "GET", "/api/blog/", "X-API-Key", key); code != http.StatusOK {
+		t.Errorf("granted scope: got %d %v", code, out)
+	}
+	if code, out := doAPIKey(t, h, "GET", "/api/user/", "X-API-Key", key); code != http.StatusForbidden || out["code"] != apierror.CodeInsufficientScope {
+		t.Errorf("scope not granted: got %d %v", code, out)
+	}
+	if code, out := doJSON(t, h, "POST", "/api/user/me/api-keys", accessToken(t, u), M{"name": "admin", "scopes": []string{ScopeUserAdmin}}); code != http.StatusUnprocessableEntity {
+		t.Errorf("scope the user lacks: got %d %v", code, out)
+	}
+
+	id, key := newAPIKey(t, h, u, M{"name": "nightly", "expires_at": time.Now().Add(time.Hour)})
+	client.APIKey.UpdateOneID(id).SetExpiresAt(time.Now().Add(-time.Second)).ExecX(ctx)
+	if code, out := doAPIKey(t, h, "GET", "/api/blog/", "X-API-Key", key); code != http.StatusUnauthorized || out["code"] != apierror.CodeTokenExpired {
+		t.Errorf("expired key: got %d %v", code, out)
+	}
+}
@@ -2,26 +2,180 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"go/djan/app/apierror"
 	"go/djan/app/ent"
-	"log"
+	"go/djan/app/ent/user"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/spf13/viper"
+	"github.com/o1egl/paseto"
 )
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey  = contextKey("user")
+	tokenContextKey = contextKey("token")
+)
+
+const (
+	// sessionClaim carries the refresh token family the access token was issued for
+	sessionClaim = "sid"
+	// roleClaim carries the role of the user at the time the token was issued
+	roleClaim = "role"
+	// purposeClaim restricts a token to a single use, such as verifying an
+	// email address. Access tokens have none.
+	purposeClaim = "purpose"
+)
+
+var (
+	errMissingToken = apierror.Unauthorized(apierror.CodeUnauthorized, "Authorization header missing")
+	errInvalidToken = apierror.Unauthorized(apierror.CodeInvalidToken, "Invalid token")
+)
+
+// newTokenID returns a random identifier used as the jti claim of a token
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// issueAccessToken mints a PASETO access token for the given user and session,
+// granting the scopes of the user within the given ones, all of them if nil
+func issueAccessToken(user *ent.User, session string, scopes []string) (string, error) {
+	granted := narrowScopes(scopesOf(user), scopes)
+	// Tokens without scopes would grant those of the role
+	if len(granted) == 0 {
+		return "", errNoGrantedScope
+	}
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	// Access tokens are short-lived, sessions are kept alive through refresh tokens
+	jsonToken := paseto.JSONToken{
+		Jti:        jti,
+		Subject:    strconv.Itoa(user.ID),
+		IssuedAt:   now,
+		Expiration: now.Add(config.Auth.AccessTokenLifetime),
+	}
+	jsonToken.Set(sessionClaim, session)
+	jsonToken.Set(roleClaim, effectiveRole(user).String())
+	jsonToken.Set(scopeClaim, strings.Join(granted, " "))
+	return sealAccessToken(jsonToken)
+}
+
+// sealAccessToken seals an access token as PASETO_MODE requires: encrypted
+// like every other token, or signed with the active public key so that other
+// services verify it, its claims then being readable by anyone holding it
+func sealAccessToken(jsonToken paseto.JSONToken) (string, error) {
+	if config.Auth.PasetoMode != pasetoPublic {
+		return encryptToken(jsonToken)
+	}
+	kr := getKeyring()
+	return paseto.NewV2().Sign(kr.SigningKeys[kr.ActivePublic], jsonToken, tokenFooter{KeyID: kr.ActivePublic})
+}
+
+// encryptToken seals the claims of a token with the active local key, whose
+// ID is put in the footer. The tokens carrying secrets, such as the PKCE
+// verifier of the OpenID Connect session, are encrypted whatever PASETO_MODE.
+func encryptToken(jsonToken paseto.JSONToken) (string, error) {
+	kr := getKeyring()
+	var footer any
+	if kr.Active != legacyKeyID {
+		footer = tokenFooter{KeyID: kr.Active}
+	}
+	return paseto.NewV2().Encrypt(kr.Keys[kr.Active], jsonToken, footer)
+}
+
+// effectiveRole returns the role granted to the user, superusers are always admins
+func effectiveRole(u *ent.User) user.Role {
+	if u.IsSuperuser {
+		return user.RoleAdmin
+	}
+	return u.Role
+}
+
+// bearerToken extracts the token from the "Authorization: Bearer <token>" header
+func bearerToken(r *http.Request) (string, error) {
+	tokenString := r.Header.Get("Authorization")
+	if tokenString == "" {
+		return "", errMissingToken
+	}
 
-var pasetoKey []byte
+	bearerPrefix := "Bearer "
+	if len(tokenString) > len(bearerPrefix) && strings.HasPrefix(tokenString, bearerPrefix) {
+		return tokenString[len(bearerPrefix):], nil
+	}
+	return "", errInvalidToken
+}
 
-func getPasetoKey() []byte {
-	pasetoKeyHex := viper.GetString("PASETO_KEY")
-	pasetoKey, err := hex.DecodeString(pasetoKeyHex)
+// decryptToken decrypts and parses a local token with the key named by its
+// footer, without checking its expiration
+func decryptToken(tokenString string) (*paseto.JSONToken, error) {
+	return openToken(tokenString, paseto.LOCAL)
+}
+
+// parseAccessToken opens an access token of the purpose of PASETO_MODE,
+// without checking its expiration. Until PASETO_MODE_TRANSITION_UNTIL, tokens
+// of the other purpose are accepted as well, so that switching modes logs out
+// no one.
+func parseAccessToken(tokenString string) (*paseto.JSONToken, error) {
+	if time.Now().Before(config.Auth.PasetoTransitionUntil) {
+		return openToken(tokenString, paseto.LOCAL, paseto.PUBLIC)
+	}
+	if config.Auth.PasetoMode == pasetoPublic {
+		return openToken(tokenString, paseto.PUBLIC)
+	}
+	return openToken(tokenString, paseto.LOCAL)
+}
+
+// openToken decrypts, or verifies, and parses a token of one of the given
+// purposes with the key named by its footer
+func openToken(tokenString string, purposes ...paseto.Purpose) (*paseto.JSONToken, error) {
+	// Tokens without a key ID were encrypted with PASETO_KEY
+	var rawFooter []byte
+	if err := paseto.ParseFooter(tokenString, &rawFooter); err != nil {
+		return nil, errInvalidToken
+	}
+	var footer tokenFooter
+	if len(rawFooter) > 0 && json.Unmarshal(rawFooter, &footer) != nil {
+		return nil, errInvalidToken
+	}
+	version, purpose, err := paseto.GetTokenInfo(tokenString)
+	if err != nil || version != paseto.Version2 || !slices.Contains(purposes, purpose) {
+		return nil, errInvalidToken
+	}
+
+	var jsonToken paseto.JSONToken
+	kr := getKeyring()
+	switch purpose {
+	case paseto.LOCAL:
+		key, ok := kr.Keys[footer.KeyID]
+		if !ok {
+			return nil, errInvalidToken
+		}
+		err = paseto.NewV2().Decrypt(tokenString, key, &jsonToken, nil)
+	case paseto.PUBLIC:
+		signingKey, ok := kr.SigningKeys[footer.KeyID]
+		if !ok {
+			return nil, errInvalidToken
+		}
+		err = paseto.NewV2().Verify(tokenString, signingKey.Public(), &jsonToken, nil)
+	}
 	if err != nil {
-		log.Panic("failed to generate PASETO key: ", err)
+		return nil, errInvalidToken
 	}
-	return pasetoKey
+	return &jsonToken, nil
 }
 
 // GetUserFromContext retrieves the User from the request context.
@@ -31,3 +185,11 @@ func GetUserFromContext(ctx context.Context) *ent.User {
 	}
 	return nil
 }
+
+// GetTokenFromContext retrieves the authenticated token from the request context.
+func GetTokenFromContext(ctx context.Context) *paseto.JSONToken {
+	if t, ok := ctx.Value(tokenContextKey).(*paseto.JSONToken); ok {
+		return t
+	}
+	return nil
+}
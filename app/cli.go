@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"go/djan/app/ent"
+	"go/djan/app/ent/schema"
+	"go/djan/app/ent/user"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// command is a subcommand of the binary, like the ones of Django's manage.py
+type command struct {
+	name  string
+	args  string
+	short string
+	run   func(ctx context.Context, args []string) error
+}
+
+func commands() []command {
+	return []command{
+		{"serve", "[-auto-migrate=false]", "run the API server (the default)", serve},
+		{"migrate", "<up|down|status|diff|new|hash|baseline>", "manage the database migrations", migrateCommand},
+		{"createuser", "[-name name] [-role role]", "create a user", createUserCommand(false)},
+		{"createsuperuser", "[-name name]", "create a superuser", createUserCommand(true)},
+		{"changepassword", "<name>", "change the password of a user", changePasswordCommand},
+		{"disablemfa", "<name>", "turn off the two-factor authentication of a user", disableMFACommand},
+		{"dumpdata", "[-o file]", "write the users, tags and blogs as JSON", dumpDataCommand},
+		{"loaddata", "<file>", "load the users, tags and blogs of a dumpdata file", loadDataCommand},
+		{"keys", "<list|generate|promote|retire>", "manage the PASETO keys of the keyring", keysCommand},
+		{"routes", "", "list the endpoints of the API", routesCommand},
+		{"shell", "[psql arguments]", "open psql on the database", shellCommand},
+	}
+}
+
+// stdin is shared by the prompts, so that input buffered by one is not lost
+var stdin = bufio.NewReader(os.Stdin)
+
+func usage() {
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "usage: %s <command> [arguments]\n\ncommands:\n", os.Args[0])
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %s %s\t%s\n", c.name, c.args, c.short)
+	}
+	w.Flush()
+}
+
+// runCommand runs the subcommand named by the first argument. Without one,
+// or with flags only, the server is started.
+func runCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "-help" {
+		return serve(ctx, args)
+	}
+	for _, c := range commands() {
+		if c.name == args[0] {
+			return c.run(ctx, args[1:])
+		}
+	}
+	usage()
+	if args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+		return nil
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// readPassword prompts for a password, twice when confirm is set. The
+// password is read from stdin when it is not a terminal, for scripts.
+func readPassword(confirm bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := stdin.ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("error reading password: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Password (again): ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		if string(again) != string(password) {
+			return "", errors.New("passwords do not match")
+		}
+	}
+	return string(password), nil
+}
+
+func prompt(label string) (string, error) {
+	fmt.Fprintf(os.Stderr, "%s: ", label)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+func createUserCommand(superuser bool) func(ctx context.Context, args []string) error {
+	return func(ctx context.Context, args []string) error {
+		fset := flag.NewFlagSet("createuser", flag.ContinueOnError)
+		if superuser {
+			fset = flag.NewFlagSet("createsuperuser", flag.ContinueOnError)
+		}
+		var name string
+		fset.StringVar(&name, "name", "", "name of the user, prompted for when missing")
+		var email string
+		fset.StringVar(&email, "email", "", "email address of the user, trusted as verified")
+		role := user.DefaultRole
+		if superuser {
+			role = user.RoleAdmin
+		} else {
+			fset.Func("role", "role of the user: admin, editor or reader", func(s string) error {
+				role = user.Role(s)
+				return user.RoleValidator(role)
+			})
+		}
+		if err := fset.Parse(args); err != nil {
+			return err
+		}
+		if email != "" && !schema.UserEmailPattern.MatchString(email) {
+			return fmt.Errorf("%s is not an email address", email)
+		}
+
+		if name == "" {
+			var err error
+			if name, err = prompt("Name"); err != nil {
+				return err
+			}
+		}
+		client := GetClient()
+		exists, err := client.User.Query().Where(user.Name(name)).Exist(ctx)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("user %s already exists", name)
+		}
+
+		password, err := readPassword(true)
+		if err != nil {
+			return err
+		}
+		if err := checkPassword(password); err != nil {
+			return err
+		}
+		hashed, err := hashPassword(password)
+		if err != nil {
+			return err
+		}
+		// Users created by the operator need no email verification
+		create := client.User.
+			Create().
+			SetName(name).
+			SetPassword(hashed).
+			SetRole(role).
+			SetIsSuperuser(superuser).
+			SetEmailVerifiedAt(time.Now())
+		if email != "" {
+			create.SetEmail(email)
+		}
+		u, err := create.Save(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created user %s with id %d\n", u.Name, u.ID)
+		return nil
+	}
+}
+
+func changePasswordCommand(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("changepassword requires the name of the user")
+	}
+	client := GetClient()
+	u, err := client.User.Query().Where(user.Name(args[0])).Only(ctx)
+	if ent.IsNotFound(err) {
+		return fmt.Errorf("user %s does not exist", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	password, err := readPassword(true)
+	if err != nil {
+		return err
+	}
+	if err := checkPassword(password); err != nil {
+		return err
+	}
+	// Sessions opened with the old password can not be refreshed anymore
+	if err := setPassword(ctx, client, u, password); err != nil {
+		return err
+	}
+	fmt.Printf("Changed the password of user %s\n", u.Name)
+	return nil
+}
+
+// disableMFACommand helps users who lost their authenticator and recovery codes
+func disableMFACommand(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("disablemfa requires the name of the user")
+	}
+	client := GetClient()
+	u, err := client.User.Query().Where(user.Name(args[0])).Only(ctx)
+	if ent.IsNotFound(err) {
+		return fmt.Errorf("user %s does not exist", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := clearTOTP(ctx, client, u); err != nil {
+		return err
+	}
+	fmt.Printf("Disabled the two-factor authentication of user %s\n", u.Name)
+	return nil
+}
+
+func routesCommand(ctx context.Context, args []string) error {
+	routes := newRouter().Routes()
+	sort.SliceStable(routes, func(i, j int) bool { return routes[i].Path < routes[j].Path })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tPATH\tHANDLER\tSCOPES")
+	for _, r := range routes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Method, r.Path, r.Handler, strings.Join(r.Scopes, " "))
+	}
+	return w.Flush()
+}
+
+// shellCommand runs psql with the connection settings of the configuration
+func shellCommand(ctx context.Context, args []string) error {
+	db := config.Database
+	cmd := exec.CommandContext(ctx, "psql", args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(),
+		"PGHOST="+db.Host,
+		fmt.Sprintf("PGPORT=%d", db.Port),
+		"PGUSER="+db.User,
+		"PGDATABASE="+db.Name,
+		"PGPASSWORD="+db.Password,
+		"PGSSLMODE="+db.SSLMode,
+		fmt.Sprintf("PGCONNECT_TIMEOUT=%d", int(db.ConnectTimeout.Seconds())),
+	)
+	return cmd.Run()
+}
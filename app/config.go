@@ -0,0 +1,476 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Config holds the settings of the application. It is loaded from, in order
+// of precedence, the environment, the .env file, an optional YAML file named
+// by CONFIG_FILE (config.yaml by default) and the defaults below. YAML keys
+// are the environment variable names, case insensitive.
+type Config struct {
+	Server    ServerConfig
+	Database  DatabaseConfig
+	Auth      AuthConfig
+	Password  PasswordConfig
+	Mail      MailConfig
+	OIDC      OIDCConfig
+	CORS      CORSConfig
+	RateLimit RateLimitConfig
+	Log       LogConfig
+	Tracing   TracingConfig
+}
+
+type ServerConfig struct {
+	Addr            string
+	TLSCertFile     string
+	TLSKeyFile      string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+	ShutdownDelay   time.Duration
+}
+
+type DatabaseConfig struct {
+	Host            string
+	Port            int
+	User            string
+	Name            string
+	Password        string
+	SSLMode         string
+	ConnectTimeout  time.Duration
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	MigrationsDir   string
+	AutoMigrate     bool
+	MigrateDevURL   string
+}
+
+type AuthConfig struct {
+	// PasetoKey is the key of the tokens without a key ID, nil when only the
+	// keyring is used
+	PasetoKey   []byte
+	KeyringFile string
+	// PasetoMode is the purpose of the access tokens issued, local or public
+	PasetoMode string
+	// Access tokens of the other purpose are accepted until
+	// PasetoTransitionUntil, zero when not switching modes
+	PasetoTransitionUntil time.Time
+
+	AccessTokenLifetime     time.Duration
+	RefreshTokenLifetime    time.Duration
+	RevocationSweepInterval time.Duration
+	LockoutThreshold        int
+	LockoutDuration         time.Duration
+	LockoutMaxDuration      time.Duration
+	// The verification link is EmailVerificationURL?token=<token>
+	EmailVerificationLifetime time.Duration
+	EmailVerificationURL      string
+	// TOTPKey encrypts the TOTP secrets of the users, none disables enrollment
+	TOTPKey          []byte
+	TOTPIssuer       string
+	MFATokenLifetime time.Duration
+}
+
+type CORSConfig struct {
+	AllowedOrigins []string
+}
+
+type PasswordConfig struct {
+	MinLength          int
+	CheckCommon        bool
+	CommonListFile     string
+	ResetTokenLifetime time.Duration
+	ResetURL           string
+}
+
+type MailConfig struct {
+	Mailer string
+	Dir    string
+	From   string
+}
+
+// OIDCConfig is the OpenID Connect provider users may log in with, disabled
+// without an issuer
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the page of the front end receiving the code and state
+	RedirectURL   string
+	Scopes        []string
+	StateLifetime time.Duration
+	// LinkVerifiedEmails links new identities to the user with the same
+	// verified address, trusting the provider to have verified it as well
+	LinkVerifiedEmails bool
+}
+
+// RateLimitConfig holds the requests allowed per minute, 0 for no limit
+type RateLimitConfig struct {
+	AuthPerIP   int
+	AuthPerUser int
+}
+
+type LogConfig struct {
+	Level slog.Level
+}
+
+type TracingConfig struct {
+	Exporter    string
+	ServiceName string
+	SampleRatio float64
+}
+
+// config is the configuration the application was started with
+var config *Config
+
+var configDefaults = map[string]any{
+	"CONFIG_FILE": "config.yaml",
+
+	"SERVER_ADDR":             ":8080",
+	"TLS_CERT_FILE":           "server.crt",
+	"TLS_KEY_FILE":            "server.key",
+	"SERVER_READ_TIMEOUT":     "15s",
+	"SERVER_WRITE_TIMEOUT":    "15s",
+	"SERVER_IDLE_TIMEOUT":     "60s",
+	"SERVER_SHUTDOWN_TIMEOUT": "5s",
+	"SERVER_SHUTDOWN_DELAY":   "5s",
+
+	"POSTGRES_HOST":               "localhost",
+	"POSTGRES_PORT":               5432,
+	"POSTGRES_USER":               "",
+	"POSTGRES_DBNAME":             "",
+	"POSTGRES_PASSWORD":           "",
+	"POSTGRES_SSLMODE":            "require",
+	"POSTGRES_CONNECT_TIMEOUT":    "5s",
+	"POSTGRES_MAX_OPEN_CONNS":     25,
+	"POSTGRES_MAX_IDLE_CONNS":     25,
+	"POSTGRES_CONN_MAX_LIFETIME":  "30m",
+	"POSTGRES_CONN_MAX_IDLE_TIME": "5m",
+	"MIGRATIONS_DIR":              "migrations",
+	"AUTO_MIGRATE":                true,
+	"MIGRATE_DEV_URL":             "",
+
+	"PASETO_KEY":                   "",
+	"PASETO_KEYRING_FILE":          "",
+	"PASETO_MODE":                  "local",
+	"PASETO_MODE_TRANSITION_UNTIL": "",
+	"ACCESS_TOKEN_LIFETIME":        "15m",
+	"REFRESH_TOKEN_LIFETIME":       "720h",
+	"REVOCATION_SWEEP_INTERVAL":    "1h",
+	"LOGIN_LOCKOUT_THRESHOLD":      5,
+	"LOGIN_LOCKOUT_DURATION":       "1m",
+	"LOGIN_LOCKOUT_MAX_DURATION":   "1h",
+
+	"EMAIL_VERIFICATION_TOKEN_LIFETIME": "24h",
+	"EMAIL_VERIFICATION_URL":            "https://localhost/verify-email",
+
+	"TOTP_ENCRYPTION_KEY": "",
+	"TOTP_ISSUER":         "Go-Djan",
+	"MFA_TOKEN_LIFETIME":  "5m",
+
+	"PASSWORD_MIN_LENGTH":           8,
+	"PASSWORD_CHECK_COMMON":         true,
+	"PASSWORD_COMMON_LIST_FILE":     "",
+	"PASSWORD_RESET_TOKEN_LIFETIME": "1h",
+	"PASSWORD_RESET_URL":            "https://localhost/reset-password",
+
+	"MAILER":     "log",
+	"MAILER_DIR": "mail",
+	"MAIL_FROM":  "Go-Djan <no-reply@localhost>",
+
+	"OIDC_ISSUER":               "",
+	"OIDC_CLIENT_ID":            "",
+	"OIDC_CLIENT_SECRET":        "",
+	"OIDC_REDIRECT_URL":         "https://localhost/oidc-callback",
+	"OIDC_SCOPES":               "openid,email,profile",
+	"OIDC_STATE_LIFETIME":       "10m",
+	"OIDC_LINK_VERIFIED_EMAILS": false,
+
+	"AUTH_RATE_LIMIT_PER_IP":   20,
+	"AUTH_RATE_LIMIT_PER_USER": 10,
+
+	"CORS_ALLOWED_ORIGINS": "http://localhost,http://127.0.0.1,https://localhost,https://127.0.0.1",
+
+	"LOG_LEVEL": "info",
+
+	"TRACING_EXPORTER":     "none",
+	"TRACING_SAMPLE_RATIO": 1.0,
+	"OTEL_SERVICE_NAME":    "go-djan",
+}
+
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// LoadConfig reads and validates the configuration
+func LoadConfig() (*Config, error) {
+	v := viper.New()
+	for key, value := range configDefaults {
+		v.SetDefault(key, value)
+	}
+	v.AutomaticEnv()
+
+	// The YAML file is optional, unless it was named explicitly
+	configFile := v.GetString("CONFIG_FILE")
+	if _, err := os.Stat(configFile); err == nil || os.Getenv("CONFIG_FILE") != "" {
+		v.SetConfigFile(configFile)
+		v.SetConfigType("yaml")
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("error reading config file %s: %w", configFile, err)
+		}
+	}
+
+	// Values of the .env file override the YAML file
+	if _, err := os.Stat(".env"); err == nil {
+		env := viper.New()
+		env.SetConfigFile(".env")
+		if err := env.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("error reading .env file: %w", err)
+		}
+		if err := v.MergeConfigMap(env.AllSettings()); err != nil {
+			return nil, fmt.Errorf("error reading .env file: %w", err)
+		}
+	}
+
+	cfg := &Config{
+		Server: ServerConfig{
+			Addr:            v.GetString("SERVER_ADDR"),
+			TLSCertFile:     v.GetString("TLS_CERT_FILE"),
+			TLSKeyFile:      v.GetString("TLS_KEY_FILE"),
+			ReadTimeout:     v.GetDuration("SERVER_READ_TIMEOUT"),
+			WriteTimeout:    v.GetDuration("SERVER_WRITE_TIMEOUT"),
+			IdleTimeout:     v.GetDuration("SERVER_IDLE_TIMEOUT"),
+			ShutdownTimeout: v.GetDuration("SERVER_SHUTDOWN_TIMEOUT"),
+			ShutdownDelay:   v.GetDuration("SERVER_SHUTDOWN_DELAY"),
+		},
+		Database: DatabaseConfig{
+			Host:            v.GetString("POSTGRES_HOST"),
+			Port:            v.GetInt("POSTGRES_PORT"),
+			User:            v.GetString("POSTGRES_USER"),
+			Name:            v.GetString("POSTGRES_DBNAME"),
+			Password:        v.GetString("POSTGRES_PASSWORD"),
+			SSLMode:         v.GetString("POSTGRES_SSLMODE"),
+			ConnectTimeout:  v.GetDuration("POSTGRES_CONNECT_TIMEOUT"),
+			MaxOpenConns:    v.GetInt("POSTGRES_MAX_OPEN_CONNS"),
+			MaxIdleConns:    v.GetInt("POSTGRES_MAX_IDLE_CONNS"),
+			ConnMaxLifetime: v.GetDuration("POSTGRES_CONN_MAX_LIFETIME"),
+			ConnMaxIdleTime: v.GetDuration("POSTGRES_CONN_MAX_IDLE_TIME"),
+			MigrationsDir:   v.GetString("MIGRATIONS_DIR"),
+			AutoMigrate:     v.GetBool("AUTO_MIGRATE"),
+			MigrateDevURL:   v.GetString("MIGRATE_DEV_URL"),
+		},
+		Auth: AuthConfig{
+			KeyringFile:             v.GetString("PASETO_KEYRING_FILE"),
+			PasetoMode:              v.GetString("PASETO_MODE"),
+			AccessTokenLifetime:     v.GetDuration("ACCESS_TOKEN_LIFETIME"),
+			RefreshTokenLifetime:    v.GetDuration("REFRESH_TOKEN_LIFETIME"),
+			RevocationSweepInterval: v.GetDuration("REVOCATION_SWEEP_INTERVAL"),
+			LockoutThreshold:        v.GetInt("LOGIN_LOCKOUT_THRESHOLD"),
+			LockoutDuration:         v.GetDuration("LOGIN_LOCKOUT_DURATION"),
+			LockoutMaxDuration:      v.GetDuration("LOGIN_LOCKOUT_MAX_DURATION"),
+
+			EmailVerificationLifetime: v.GetDuration("EMAIL_VERIFICATION_TOKEN_LIFETIME"),
+			EmailVerificationURL:      v.GetString("EMAIL_VERIFICATION_URL"),
+			TOTPIssuer:                v.GetString("TOTP_ISSUER"),
+			MFATokenLifetime:          v.GetDuration("MFA_TOKEN_LIFETIME"),
+		},
+		Password: PasswordConfig{
+			MinLength:          v.GetInt("PASSWORD_MIN_LENGTH"),
+			CheckCommon:        v.GetBool("PASSWORD_CHECK_COMMON"),
+			CommonListFile:     v.GetString("PASSWORD_COMMON_LIST_FILE"),
+			ResetTokenLifetime: v.GetDuration("PASSWORD_RESET_TOKEN_LIFETIME"),
+			ResetURL:           v.GetString("PASSWORD_RESET_URL"),
+		},
+		Mail: MailConfig{
+			Mailer: v.GetString("MAILER"),
+			Dir:    v.GetString("MAILER_DIR"),
+			From:   v.GetString("MAIL_FROM"),
+		},
+		OIDC: OIDCConfig{
+			Issuer:             v.GetString("OIDC_ISSUER"),
+			ClientID:           v.GetString("OIDC_CLIENT_ID"),
+			ClientSecret:       v.GetString("OIDC_CLIENT_SECRET"),
+			RedirectURL:        v.GetString("OIDC_REDIRECT_URL"),
+			Scopes:             splitList(v.GetStringSlice("OIDC_SCOPES")),
+			StateLifetime:      v.GetDuration("OIDC_STATE_LIFETIME"),
+			LinkVerifiedEmails: v.GetBool("OIDC_LINK_VERIFIED_EMAILS"),
+		},
+		RateLimit: RateLimitConfig{
+			AuthPerIP:   v.GetInt("AUTH_RATE_LIMIT_PER_IP"),
+			AuthPerUser: v.GetInt("AUTH_RATE_LIMIT_PER_USER"),
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: splitList(v.GetStringSlice("CORS_ALLOWED_ORIGINS")),
+		},
+		Tracing: TracingConfig{
+			Exporter:    v.GetString("TRACING_EXPORTER"),
+			ServiceName: v.GetString("OTEL_SERVICE_NAME"),
+			SampleRatio: v.GetFloat64("TRACING_SAMPLE_RATIO"),
+		},
+	}
+
+	var errs []error
+	// PASETO_KEY is optional once the keys are rotated with the keyring
+	if pasetoKey := v.GetString("PASETO_KEY"); pasetoKey != "" || cfg.Auth.KeyringFile == "" {
+		key, err := hex.DecodeString(pasetoKey)
+		if err != nil || len(key) != 32 {
+			errs = append(errs, errors.New("PASETO_KEY must be 32 bytes, hex encoded"))
+		}
+		cfg.Auth.PasetoKey = key
+	}
+	// Access tokens of both purposes are only accepted until an explicit time
+	if until := v.GetString("PASETO_MODE_TRANSITION_UNTIL"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			errs = append(errs, errors.New("PASETO_MODE_TRANSITION_UNTIL must be an RFC 3339 time"))
+		}
+		cfg.Auth.PasetoTransitionUntil = t
+	}
+	// Users can't enroll in two-factor authentication without TOTP_ENCRYPTION_KEY
+	if totpKey := v.GetString("TOTP_ENCRYPTION_KEY"); totpKey != "" {
+		key, err := hex.DecodeString(totpKey)
+		if err != nil || len(key) != 32 {
+			errs = append(errs, errors.New("TOTP_ENCRYPTION_KEY must be 32 bytes, hex encoded"))
+		}
+		cfg.Auth.TOTPKey = key
+	}
+
+	if err := cfg.Log.Level.UnmarshalText([]byte(v.GetString("LOG_LEVEL"))); err != nil {
+		errs = append(errs, errors.New("LOG_LEVEL must be one of debug, info, warn or error"))
+	}
+
+	if err := cfg.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "SERVER_ADDR is required")
+	check(c.Server.TLSCertFile != "" && c.Server.TLSKeyFile != "", "TLS_CERT_FILE and TLS_KEY_FILE are required")
+	check(c.Server.ReadTimeout > 0, "SERVER_READ_TIMEOUT must be positive")
+	check(c.Server.WriteTimeout > 0, "SERVER_WRITE_TIMEOUT must be positive")
+	check(c.Server.IdleTimeout > 0, "SERVER_IDLE_TIMEOUT must be positive")
+	check(c.Server.ShutdownTimeout > 0, "SERVER_SHUTDOWN_TIMEOUT must be positive")
+	check(c.Server.ShutdownDelay >= 0, "SERVER_SHUTDOWN_DELAY must not be negative")
+
+	check(c.Database.Host != "", "POSTGRES_HOST is required")
+	check(c.Database.Port > 0 && c.Database.Port < 65536, "POSTGRES_PORT must be a valid port")
+	check(c.Database.User != "", "POSTGRES_USER is required")
+	check(c.Database.Name != "", "POSTGRES_DBNAME is required")
+	check(slices.Contains(sslModes, c.Database.SSLMode), "POSTGRES_SSLMODE must be one of %s", strings.Join(sslModes, ", "))
+	check(c.Database.ConnectTimeout >= time.Second, "POSTGRES_CONNECT_TIMEOUT must be at least 1s")
+	check(c.Database.MaxOpenConns >= 0, "POSTGRES_MAX_OPEN_CONNS must not be negative")
+	check(c.Database.MaxIdleConns >= 0, "POSTGRES_MAX_IDLE_CONNS must not be negative")
+	check(c.Database.ConnMaxLifetime >= 0, "POSTGRES_CONN_MAX_LIFETIME must not be negative")
+	check(c.Database.ConnMaxIdleTime >= 0, "POSTGRES_CONN_MAX_IDLE_TIME must not be negative")
+	check(c.Database.MigrationsDir != "", "MIGRATIONS_DIR is required")
+
+	check(slices.Contains(pasetoModes, c.Auth.PasetoMode), "PASETO_MODE must be one of %s", strings.Join(pasetoModes, ", "))
+	check(c.Auth.PasetoMode != pasetoPublic || c.Auth.KeyringFile != "", "PASETO_MODE public requires PASETO_KEYRING_FILE")
+	check(c.Auth.AccessTokenLifetime > 0, "ACCESS_TOKEN_LIFETIME must be positive")
+	check(c.Auth.RefreshTokenLifetime > c.Auth.AccessTokenLifetime, "REFRESH_TOKEN_LIFETIME must be longer than ACCESS_TOKEN_LIFETIME")
+	check(c.Auth.RevocationSweepInterval > 0, "REVOCATION_SWEEP_INTERVAL must be positive")
+	check(c.Auth.LockoutThreshold >= 0, "LOGIN_LOCKOUT_THRESHOLD must not be negative")
+	check(c.Auth.LockoutDuration > 0, "LOGIN_LOCKOUT_DURATION must be positive")
+	check(c.Auth.LockoutMaxDuration >= c.Auth.LockoutDuration, "LOGIN_LOCKOUT_MAX_DURATION must not be shorter than LOGIN_LOCKOUT_DURATION")
+
+	check(c.Auth.EmailVerificationLifetime > 0, "EMAIL_VERIFICATION_TOKEN_LIFETIME must be positive")
+	verificationURL, err := url.Parse(c.Auth.EmailVerificationURL)
+	check(err == nil && verificationURL.IsAbs(), "EMAIL_VERIFICATION_URL must be an absolute URL")
+	check(c.Auth.TOTPIssuer != "" && !strings.Contains(c.Auth.TOTPIssuer, ":"), "TOTP_ISSUER must be set and must not contain a colon")
+	check(c.Auth.MFATokenLifetime > 0, "MFA_TOKEN_LIFETIME must be positive")
+
+	check(c.Password.MinLength >= 1 && c.Password.MinLength <= passwordMaxLen, "PASSWORD_MIN_LENGTH must be between 1 and %d", passwordMaxLen)
+	check(c.Password.ResetTokenLifetime > 0, "PASSWORD_RESET_TOKEN_LIFETIME must be positive")
+	resetURL, err := url.Parse(c.Password.ResetURL)
+	check(err == nil && resetURL.IsAbs(), "PASSWORD_RESET_URL must be an absolute URL")
+
+	names := mailerNames()
+	check(slices.Contains(names, c.Mail.Mailer), "MAILER must be one of %s", strings.Join(names, ", "))
+	check(c.Mail.Mailer != "file" || c.Mail.Dir != "", "MAILER_DIR is required by the file mailer")
+	check(c.Mail.From != "", "MAIL_FROM is required")
+
+	if c.OIDC.Issuer != "" {
+		issuerURL, err := url.Parse(c.OIDC.Issuer)
+		check(err == nil && issuerURL.IsAbs(), "OIDC_ISSUER must be an absolute URL")
+		check(c.OIDC.ClientID != "", "OIDC_CLIENT_ID is required by OIDC_ISSUER")
+		redirectURL, err := url.Parse(c.OIDC.RedirectURL)
+		check(err == nil && redirectURL.IsAbs(), "OIDC_REDIRECT_URL must be an absolute URL")
+		check(slices.Contains(c.OIDC.Scopes, "openid"), "OIDC_SCOPES must include openid")
+		check(c.OIDC.StateLifetime > 0, "OIDC_STATE_LIFETIME must be positive")
+	}
+
+	check(c.RateLimit.AuthPerIP >= 0, "AUTH_RATE_LIMIT_PER_IP must not be negative")
+	check(c.RateLimit.AuthPerUser >= 0, "AUTH_RATE_LIMIT_PER_USER must not be negative")
+
+	check(len(c.CORS.AllowedOrigins) > 0, "CORS_ALLOWED_ORIGINS is required")
+
+	exporters := tracingExporters()
+	check(slices.Contains(exporters, c.Tracing.Exporter), "TRACING_EXPORTER must be one of %s", strings.Join(exporters, ", "))
+	check(c.Tracing.ServiceName != "", "OTEL_SERVICE_NAME is required")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "TRACING_SAMPLE_RATIO must be between 0 and 1")
+	return errors.Join(errs...)
+}
+
+// DSN returns the lib/pq connection string of the database
+func (c DatabaseConfig) DSN() string {
+	params := []struct{ key, value string }{
+		{"host", c.Host},
+		{"port", fmt.Sprint(c.Port)},
+		{"user", c.User},
+		{"dbname", c.Name},
+		{"password", c.Password},
+		{"sslmode", c.SSLMode},
+		{"connect_timeout", fmt.Sprint(int(c.ConnectTimeout.Seconds()))},
+	}
+	var b strings.Builder
+	for _, p := range params {
+		if p.value == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		// Values are quoted so that they may contain spaces and quotes
+		value := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(p.value)
+		fmt.Fprintf(&b, "%s='%s'", p.key, value)
+	}
+	return b.String()
+}
+
+// splitList splits the comma separated items of environment variables,
+// which viper returns as a single item
+func splitList(items []string) []string {
+	var list []string
+	for _, item := range items {
+		for _, s := range strings.Split(item, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
@@ -0,0 +1,514 @@
+// Code generated by ent, DO NOT EDIT.
+
+package ent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/djan/app/ent/apikey"
+	"go/djan/app/ent/predicate"
+	"go/djan/app/ent/user"
+	"time"
+
+	"entgo.io/ent/dialect/sql"
+	"entgo.io/ent/dialect/sql/sqlgraph"
+	"entgo.io/ent/dialect/sql/sqljson"
+	"entgo.io/ent/schema/field"
+)
+
+// APIKeyUpdate is the builder for updating APIKey entities.
+type APIKeyUpdate struct {
+	config
+	hooks     []Hook
+	mutation  *APIKeyMutation
+	modifiers []func(*sql.UpdateBuilder)
+}
+
+// Where appends a list predicates to the APIKeyUpdate builder.
+func (aku *APIKeyUpdate) Where(ps ...predicate.APIKey) *APIKeyUpdate {
+	aku.mutation.Where(ps...)
+	return aku
+}
+
+// SetName sets the "name" field.
+func (aku *APIKeyUpdate) SetName(s string) *APIKeyUpdate {
+	aku.mutation.SetName(s)
+	return aku
+}
+
+// SetNillableName sets the "name" field if the given value is not nil.
+func (aku *APIKeyUpdate) SetNillableName(s *string) *APIKeyUpdate {
+	if s != nil {
+		aku.SetName(*s)
+	}
+	return aku
+}
+
+// SetScopes sets the "scopes" field.
+func (aku *APIKeyUpdate) SetScopes(s []string) *APIKeyUpdate {
+	aku.mutation.SetScopes(s)
+	return aku
+}
+
+// AppendScopes appends s to the "scopes" field.
+func (aku *APIKeyUpdate) AppendScopes(s []string) *APIKeyUpdate {
+	aku.mutation.AppendScopes(s)
+	return aku
+}
+
+// ClearScopes clears the value of the "scopes" field.
+func (aku *APIKeyUpdate) ClearScopes() *APIKeyUpdate {
+	aku.mutation.ClearScopes()
+	return aku
+}
+
+// SetExpiresAt sets the "expires_at" field.
+func (aku *APIKeyUpdate) SetExpiresAt(t time.Time) *APIKeyUpdate {
+	aku.mutation.SetExpiresAt(t)
+	return aku
+}
+
+// SetNillableExpiresAt sets the "expires_at" field if the given value is not nil.
+func (aku *APIKeyUpdate) SetNillableExpiresAt(t *time.Time) *APIKeyUpdate {
+	if t != nil {
+		aku.SetExpiresAt(*t)
+	}
+	return aku
+}
+
+// ClearExpiresAt clears the value of the "expires_at" field.
+func (aku *APIKeyUpdate) ClearExpiresAt() *APIKeyUpdate {
+	aku.mutation.ClearExpiresAt()
+	return aku
+}
+
+// SetLastUsedAt sets the "last_used_at" field.
+func (aku *APIKeyUpdate) SetLastUsedAt(t time.Time) *APIKeyUpdate {
+	aku.mutation.SetLastUsedAt(t)
+	return aku
+}
+
+// SetNillableLastUsedAt sets the "last_used_at" field if the given value is not nil.
+func (aku *APIKeyUpdate) SetNillableLastUsedAt(t *time.Time) *APIKeyUpdate {
+	if t != nil {
+		aku.SetLastUsedAt(*t)
+	}
+	return aku
+}
+
+// ClearLastUsedAt clears the value of the "last_used_at" field.
+func (aku *APIKeyUpdate) ClearLastUsedAt() *APIKeyUpdate {
+	aku.mutation.ClearLastUsedAt()
+	return aku
+}
+
+// SetUserID sets the "user" edge to the User entity by ID.
+func (aku *APIKeyUpdate) SetUserID(id int) *APIKeyUpdate {
+	aku.mutation.SetUserID(id)
+	return aku
+}
+
+// SetUser sets the "user" edge to the User entity.
+func (aku *APIKeyUpdate) SetUser(u *User) *APIKeyUpdate {
+	return aku.SetUserID(u.ID)
+}
+
+// Mutation returns the APIKeyMutation object of the builder.
+func (aku *APIKeyUpdate) Mutation() *APIKeyMutation {
+	return aku.mutation
+}
+
+// ClearUser clears the "user" edge to the User entity.
+func (aku *APIKeyUpdate) ClearUser() *APIKeyUpdate {
+	aku.mutation.ClearUser()
+	return aku
+}
+
+// Save executes the query and returns the number of nodes affected by the update operation.
+func (aku *APIKeyUpdate) Save(ctx context.Context) (int, error) {
+	return withHooks(ctx, aku.sqlSave, aku.mutation, aku.hooks)
+}
+
+// SaveX is like Save, but panics if an error occurs.
+func (aku *APIKeyUpdate) SaveX(ctx context.Context) int {
+	affected, err := aku.Save(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return affected
+}
+
+// Exec executes the query.
+func (aku *APIKeyUpdate) Exec(ctx context.Context) error {
+	_, err := aku.Save(ctx)
+	return err
+}
+
+// ExecX is like Exec, but panics if an error occurs.
+func (aku *APIKeyUpdate) ExecX(ctx context.Context) {
+	if err := aku.Exec(ctx); err != nil {
+		panic(err)
+	}
+}
+
+// check runs all checks and user-defined validators on the builder.
+func (aku *APIKeyUpdate) check() error {
+	if v, ok := aku.mutation.Name(); ok {
+		if err := apikey.NameValidator(v); err != nil {
+			return &ValidationError{Name: "name", err: fmt.Errorf(`ent: validator failed for field "APIKey.name": %w`, err)}
+		}
+	}
+	if aku.mutation.UserCleared() && len(aku.mutation.UserIDs()) > 0 {
+		return errors.New(`ent: clearing a required unique edge "APIKey.user"`)
+	}
+	return nil
+}
+
+// Modify adds a statement modifier for attaching custom logic to the UPDATE statement.
+func (aku *APIKeyUpdate) Modify(modifiers ...func(u *sql.UpdateBuilder)) *APIKeyUpdate {
+	aku.modifiers = append(aku.modifiers, modifiers...)
+	return aku
+}
+
+func (aku *APIKeyUpdate) sqlSave(ctx context.Context) (n int, err error) {
+	if err := aku.check(); err != nil {
+		return n, err
+	}
+	_spec := sqlgraph.NewUpdateSpec(apikey.Table, apikey.Columns, sqlgraph.NewFieldSpec(apikey.FieldID, field.TypeInt))
+	if ps := aku.mutation.predicates; len(ps) > 0 {
+		_spec.Predicate = func(selector *sql.Selector) {
+			for i := range ps {
+				ps[i](selector)
+			}
+		}
+	}
+	if value, ok := aku.mutation.Name(); ok {
+		_spec.SetField(apikey.FieldName, field.TypeString, value)
+	}
+	if value, ok := aku.mutation.Scopes(); ok {
+		_spec.SetField(apikey.FieldScopes, field.TypeJSON, value)
+	}
+	if value, ok := aku.mutation.AppendedScopes(); ok {
+		_spec.AddModifier(func(u *sql.UpdateBuilder) {
+			sqljson.Append(u, apikey.FieldScopes, value)
+		})
+	}
+	if aku.mutation.ScopesCleared() {
+		_spec.ClearField(apikey.FieldScopes, field.TypeJSON)
+	}
+	if value, ok := aku.mutation.ExpiresAt(); ok {
+		_spec.SetField(apikey.FieldExpiresAt, field.TypeTime, value)
+	}
+	if aku.mutation.ExpiresAtCleared() {
+		_spec.ClearField(apikey.FieldExpiresAt, field.TypeTime)
+	}
+	if value, ok := aku.mutation.LastUsedAt(); ok {
+		_spec.SetField(apikey.FieldLastUsedAt, field.TypeTime, value)
+	}
+	if aku.mutation.LastUsedAtCleared() {
+		_spec.ClearField(apikey.FieldLastUsedAt, field.TypeTime)
+	}
+	if aku.mutation.UserCleared() {
+		edge := &sqlgraph.EdgeSpec{
+			Rel:     sqlgraph.M2O,
+			Inverse: true,
+			Table:   apikey.UserTable,
+			Columns: []string{apikey.UserColumn},
+			Bidi:    false,
+			Target: &sqlgraph.EdgeTarget{
+				IDSpec: sqlgraph.NewFieldSpec(user.FieldID, field.TypeInt),
+			},
+		}
+		_spec.Edges.Clear = append(_spec.Edges.Clear, edge)
+	}
+	if nodes := aku.mutation.UserIDs(); len(nodes) > 0 {
+		edge := &sqlgraph.EdgeSpec{
+			Rel:     sqlgraph.M2O,
+			Inverse: true,
+			Table:   apikey.UserTable,
+			Columns: []string{apikey.UserColumn},
+			Bidi:    false,
+			Target: &sqlgraph.EdgeTarget{
+				IDSpec: sqlgraph.NewFieldSpec(user.FieldID, field.TypeInt),
+			},
+		}
+		for _, k := range nodes {
+			edge.Target.Nodes = append(edge.Target.Nodes, k)
+		}
+		_spec.Edges.Add = append(_spec.Edges.Add, edge)
+	}
+	_spec.AddModifiers(aku.modifiers...)
+	if n, err = sqlgraph.UpdateNodes(ctx, aku.driver, _spec); err != nil {
+		if _, ok := err.(*sqlgraph.NotFoundError); ok {
+			err = &NotFoundError{apikey.Label}
+		} else if sqlgraph.IsConstraintError(err) {
+			err = &ConstraintError{msg: err.Error(), wrap: err}
+		}
+		return 0, err
+	}
+	aku.mutation.done = true
+	return n, nil
+}
+
+// APIKeyUpdateOne is the builder for updating a single APIKey entity.
+type APIKeyUpdateOne struct {
+	config
+	fields    []string
+	hooks     []Hook
+	mutation  *APIKeyMutation
+	modifiers []func(*sql.UpdateBuilder)
+}
+
+// SetName sets the "name" field.
+func (akuo *APIKeyUpdateOne) SetName(s string) *APIKeyUpdateOne {
+	akuo.mutation.SetName(s)
+	return akuo
+}
+
+// SetNillableName sets the "name" field if the given value is not nil.
+func (akuo *APIKeyUpdateOne) SetNillableName(s *string) *APIKeyUpdateOne {
+	if s != nil {
+		akuo.SetName(*s)
+	}
+	return akuo
+}
+
+// SetScopes sets the "scopes" field.
+func (akuo *APIKeyUpdateOne) SetScopes(s []string) *APIKeyUpdateOne {
+	akuo.mutation.SetScopes(s)
+	return akuo
+}
+
+// AppendScopes appends s to the "scopes" field.
+func (akuo *APIKeyUpdateOne) AppendScopes(s []string) *APIKeyUpdateOne {
+	akuo.mutation.AppendScopes(s)
+	return akuo
+}
+
+// ClearScopes clears the value of the "scopes" field.
+func (akuo *APIKeyUpdateOne) ClearScopes() *APIKeyUpdateOne {
+	akuo.mutation.ClearScopes()
+	return akuo
+}
+
+// SetExpiresAt sets the "expires_at" field.
+func (akuo *APIKeyUpdateOne) SetExpiresAt(t time.Time) *APIKeyUpdateOne {
+	akuo.mutation.SetExpiresAt(t)
+	return akuo
+}
+
+// SetNillableExpiresAt sets the "expires_at" field if the given value is not nil.
+func (akuo *APIKeyUpdateOne) SetNillableExpiresAt(t *time.Time) *APIKeyUpdateOne {
+	if t != nil {
+		akuo.SetExpiresAt(*t)
+	}
+	return akuo
+}
+
+// ClearExpiresAt clears the value of the "expires_at" field.
+func (akuo *APIKeyUpdateOne) ClearExpiresAt() *APIKeyUpdateOne {
+	akuo.mutation.ClearExpiresAt()
+	return akuo
+}
+
+// SetLastUsedAt sets the "last_used_at" field.
+func (akuo *APIKeyUpdateOne) SetLastUsedAt(t time.Time) *APIKeyUpdateOne {
+	akuo.mutation.SetLastUsedAt(t)
+	return akuo
+}
+
+// SetNillableLastUsedAt sets the "last_used_at" field if the given value is not nil.
+func (akuo *APIKeyUpdateOne) SetNillableLastUsedAt(t *time.Time) *APIKeyUpdateOne {
+	if t != nil {
+		akuo.SetLastUsedAt(*t)
+	}
+	return akuo
+}
+
+// ClearLastUsedAt clears the value of the "last_used_at" field.
+func (akuo *APIKeyUpdateOne) ClearLastUsedAt() *APIKeyUpdateOne {
+	akuo.mutation.ClearLastUsedAt()
+	return akuo
+}
+
+// SetUserID sets the "user" edge to the User entity by ID.
+func (akuo *APIKeyUpdateOne) SetUserID(id int) *APIKeyUpdateOne {
+	akuo.mutation.SetUserID(id)
+	return akuo
+}
+
+// SetUser sets the "user" edge to the User entity.
+func (akuo *APIKeyUpdateOne) SetUser(u *User) *APIKeyUpdateOne {
+	return akuo.SetUserID(u.ID)
+}
+
+// Mutation returns the APIKeyMutation object of the builder.
+func (akuo *APIKeyUpdateOne) Mutation() *APIKeyMutation {
+	return akuo.mutation
+}
+
+// ClearUser clears the "user" edge to the User entity.
+func (akuo *APIKeyUpdateOne) ClearUser() *APIKeyUpdateOne {
+	akuo.mutation.ClearUser()
+	return akuo
+}
+
+// Where appends a list predicates to the APIKeyUpdate builder.
+func (akuo *APIKeyUpdateOne) Where(ps ...predicate.APIKey) *APIKeyUpdateOne {
+	akuo.mutation.Where(ps...)
+	return akuo
+}
+
+// Select allows selecting one or more fields (columns) of the returned entity.
+// The default is selecting all fields defined in the entity schema.
+func (akuo *APIKeyUpdateOne) Select(field string, fields ...string) *APIKeyUpdateOne {
+	akuo.fields = append([]string{field}, fields...)
+	return akuo
+}
+
+// Save executes the query and returns the updated APIKey entity.
+func (akuo *APIKeyUpdateOne) Save(ctx context.Context) (*APIKey, error) {
+	return withHooks(ctx, akuo.sqlSave, akuo.mutation, akuo.hooks)
+}
+
+// SaveX is like Save, but panics if an error occurs.
+func (akuo *APIKeyUpdateOne) SaveX(ctx context.Context) *APIKey {
+	node, err := akuo.Save(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return node
+}
+
+// Exec executes the query on the entity.
+func (akuo *APIKeyUpdateOne) Exec(ctx context.Context) error {
+	_, err := akuo.Save(ctx)
+	return err
+}
+
+// ExecX is like Exec, but panics if an error occurs.
+func (akuo *APIKeyUpdateOne) ExecX(ctx context.Context) {
+	if err := akuo.Exec(ctx); err != nil {
+		panic(err)
+	}
+}
+
+// check runs all checks and user-defined validators on the builder.
+func (akuo *APIKeyUpdateOne) check() error {
+	if v, ok := akuo.mutation.Name(); ok {
+		if err := apikey.NameValidator(v); err != nil {
+			return &ValidationError{Name: "name", err: fmt.Errorf(`ent: validator failed for field "APIKey.name": %w`, err)}
+		}
+	}
+	if akuo.mutation.UserCleared() && len(akuo.mutation.UserIDs()) > 0 {
+		return errors.New(`ent: clearing a required unique edge "APIKey.user"`)
+	}
+	return nil
+}
+
+// Modify adds a statement modifier for attaching custom logic to the UPDATE statement.
+func (akuo *APIKeyUpdateOne) Modify(modifiers ...func(u *sql.UpdateBuilder)) *APIKeyUpdateOne {
+	akuo.modifiers = append(akuo.modifiers, modifiers...)
+	return akuo
+}
+
+func (akuo *APIKeyUpdateOne) sqlSave(ctx context.Context) (_node *APIKey, err error) {
+	if err := akuo.check(); err != nil {
+		return _node, err
+	}
+	_spec := sqlgraph.NewUpdateSpec(apikey.Table, apikey.Columns, sqlgraph.NewFieldSpec(apikey.FieldID, field.TypeInt))
+	id, ok := akuo.mutation.ID()
+	if !ok {
+		return nil, &ValidationError{Name: "id", err: errors.New(`ent: missing "APIKey.id" for update`)}
+	}
+	_spec.Node.ID.Value = id
+	if fields := akuo.fields; len(fields) > 0 {
+		_spec.Node.Columns = make([]string, 0, len(fields))
+		_spec.Node.Columns = append(_spec.Node.Columns, apikey.FieldID)
+		for _, f := range fields {
+			if !apikey.ValidColumn(f) {
+				return nil, &ValidationError{Name: f, err: fmt.Errorf("ent: invalid field %q for query", f)}
+			}
+			if f != apikey.FieldID {
+				_spec.Node.Columns = append(_spec.Node.Columns, f)
+			}
+		}
+	}
+	if ps := akuo.mutation.predicates; len(ps) > 0 {
+		_spec.Predicate = func(selector *sql.Selector) {
+			for i := range ps {
+				ps[i](selector)
+			}
+		}
+	}
+	if value, ok := akuo.mutation.Name(); ok {
+		_spec.SetField(apikey.FieldName, field.TypeString, value)
+	}
+	if value, ok := akuo.mutation.Scopes(); ok {
+		_spec.SetField(apikey.FieldScopes, field.TypeJSON, value)
+	}
+	if value, ok := akuo.mutation.AppendedScopes(); ok {
+		_spec.AddModifier(func(u *sql.UpdateBuilder) {
+			sqljson.Append(u, apikey.FieldScopes, value)
+		})
+	}
+	if akuo.mutation.ScopesCleared() {
+		_spec.ClearField(apikey.FieldScopes, field.TypeJSON)
+	}
+	if value, ok := akuo.mutation.ExpiresAt(); ok {
+		_spec.SetField(apikey.FieldExpiresAt, field.TypeTime, value)
+	}
+	if akuo.mutation.ExpiresAtCleared() {
+		_spec.ClearField(apikey.FieldExpiresAt, field.TypeTime)
+	}
+	if value, ok := akuo.mutation.LastUsedAt(); ok {
+		_spec.SetField(apikey.FieldLastUsedAt, field.TypeTime, value)
+	}
+	if akuo.mutation.LastUsedAtCleared() {
+		_spec.ClearField(apikey.FieldLastUsedAt, field.TypeTime)
+	}
+	if akuo.mutation.UserCleared() {
+		edge := &sqlgraph.EdgeSpec{
+			Rel:     sqlgraph.M2O,
+			Inverse: true,
+			Table:   apikey.UserTable,
+			Columns: []string{apikey.UserColumn},
+			Bidi:    false,
+			Target: &sqlgraph.EdgeTarget{
+				IDSpec: sqlgraph.NewFieldSpec(user.FieldID, field.TypeInt),
+			},
+		}
+		_spec.Edges.Clear = append(_spec.Edges.Clear, edge)
+	}
+	if nodes := akuo.mutation.UserIDs(); len(nodes) > 0 {
+		edge := &sqlgraph.EdgeSpec{
+			Rel:     sqlgraph.M2O,
+			Inverse: true,
+			Table:   apikey.UserTable,
+			Columns: []string{apikey.UserColumn},
+			Bidi:    false,
+			Target: &sqlgraph.EdgeTarget{
+				IDSpec: sqlgraph.NewFieldSpec(user.FieldID, field.TypeInt),
+			},
+		}
+		for _, k := range nodes {
+			edge.Target.Nodes = append(edge.Target.Nodes, k)
+		}
+		_spec.Edges.Add = append(_spec.Edges.Add, edge)
+	}
+	_spec.AddModifiers(akuo.modifiers...)
+	_node = &APIKey{config: akuo.config}
+	_spec.Assign = _node.assignValues
+	_spec.ScanValues = _node.scanValues
+	if err = sqlgraph.UpdateNode(ctx, akuo.driver, _spec); err != nil {
+		if _, ok := err.(*sqlgraph.NotFoundError); ok {
+			err = &NotFoundError{apikey.Label}
+		} else if sqlgraph.IsConstraintError(err) {
+			err = &ConstraintError{msg: err.Error(), wrap: err}
+		}
+		return nil, err
+	}
+	akuo.mutation.done = true
+	return _node, nil
+}
@@ -3,9 +3,9 @@
 package ent
 
 import (
+	"fmt"
 	"go/djan/app/ent/blog"
 	"go/djan/app/ent/user"
-	"fmt"
 	"strings"
 	"time"
 
@@ -26,6 +26,8 @@ type Blog struct {
 	Episode int `json:"episode,omitempty"`
 	// Time when the Blog was created
 	CreatedAt time.Time `json:"created_at,omitempty"`
+	// Full-text search document of the Blog
+	SearchVector string `json:"-"`
 	// Edges holds the relations/edges for other nodes in the graph.
 	// The values are being populated by the BlogQuery when eager-loading is set.
 	Edges        BlogEdges `json:"edges"`
@@ -71,7 +73,7 @@ func (*Blog) scanValues(columns []string) ([]any, error) {
 		switch columns[i] {
 		case blog.FieldID, blog.FieldEpisode:
 			values[i] = new(sql.NullInt64)
-		case blog.FieldTitle, blog.FieldDescription:
+		case blog.FieldTitle, blog.FieldDescription, blog.FieldSearchVector:
 			values[i] = new(sql.NullString)
 		case blog.FieldCreatedAt:
 			values[i] = new(sql.NullTime)
@@ -122,6 +124,12 @@ func (b *Blog) assignValues(columns []string, values []any) error {
 			} else if value.Valid {
 				b.CreatedAt = value.Time
 			}
+		case blog.FieldSearchVector:
+			if value, ok := values[i].(*sql.NullString); !ok {
+				return fmt.Errorf("unexpected type %T for field search_vector", values[i])
+			} else if value.Valid {
+				b.SearchVector = value.String
+			}
 		case blog.ForeignKeys[0]:
 			if value, ok := values[i].(*sql.NullInt64); !ok {
 				return fmt.Errorf("unexpected type %T for edge-field user_blogs", value)
@@ -186,6 +194,9 @@ func (b *Blog) String() string {
 	builder.WriteString(", ")
 	builder.WriteString("created_at=")
 	builder.WriteString(b.CreatedAt.Format(time.ANSIC))
+	builder.WriteString(", ")
+	builder.WriteString("search_vector=")
+	builder.WriteString(b.SearchVector)
 	builder.WriteByte(')')
 	return builder.String()
 }
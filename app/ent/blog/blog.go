@@ -22,6 +22,8 @@ const (
 	FieldEpisode = "episode"
 	// FieldCreatedAt holds the string denoting the created_at field in the database.
 	FieldCreatedAt = "created_at"
+	// FieldSearchVector holds the string denoting the search_vector field in the database.
+	FieldSearchVector = "search_vector"
 	// EdgeUser holds the string denoting the user edge name in mutations.
 	EdgeUser = "user"
 	// EdgeTags holds the string denoting the tags edge name in mutations.
@@ -49,6 +51,7 @@ var Columns = []string{
 	FieldDescription,
 	FieldEpisode,
 	FieldCreatedAt,
+	FieldSearchVector,
 }
 
 // ForeignKeys holds the SQL foreign-keys that are owned by the "blogs"
@@ -117,6 +120,11 @@ func ByCreatedAt(opts ...sql.OrderTermOption) OrderOption {
 	return sql.OrderByField(FieldCreatedAt, opts...).ToFunc()
 }
 
+// BySearchVector orders the results by the search_vector field.
+func BySearchVector(opts ...sql.OrderTermOption) OrderOption {
+	return sql.OrderByField(FieldSearchVector, opts...).ToFunc()
+}
+
 // ByUserField orders the results by user field.
 func ByUserField(field string, opts ...sql.OrderTermOption) OrderOption {
 	return func(s *sql.Selector) {
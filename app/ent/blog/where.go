@@ -75,6 +75,11 @@ func CreatedAt(v time.Time) predicate.Blog {
 	return predicate.Blog(sql.FieldEQ(FieldCreatedAt, v))
 }
 
+// SearchVector applies equality check predicate on the "search_vector" field. It's identical to SearchVectorEQ.
+func SearchVector(v string) predicate.Blog {
+	return predicate.Blog(sql.FieldEQ(FieldSearchVector, v))
+}
+
 // TitleEQ applies the EQ predicate on the "title" field.
 func TitleEQ(v string) predicate.Blog {
 	return predicate.Blog(sql.FieldEQ(FieldTitle, v))
@@ -295,6 +300,81 @@ func CreatedAtLTE(v time.Time) predicate.Blog {
 	return predicate.Blog(sql.FieldLTE(FieldCreatedAt, v))
 }
 
+// SearchVectorEQ applies the EQ predicate on the "search_vector" field.
+func SearchVectorEQ(v string) predicate.Blog {
+	return predicate.Blog(sql.FieldEQ(FieldSearchVector, v))
+}
+
+// SearchVectorNEQ applies the NEQ predicate on the "search_vector" field.
+func SearchVectorNEQ(v string) predicate.Blog {
+	return predicate.Blog(sql.FieldNEQ(FieldSearchVector, v))
+}
+
+// SearchVectorIn applies the In predicate on the "search_vector" field.
+func SearchVectorIn(vs ...string) predicate.Blog {
+	return predicate.Blog(sql.FieldIn(FieldSearchVector, vs...))
+}
+
+// SearchVectorNotIn applies the NotIn predicate on the "search_vector" field.
+func SearchVectorNotIn(vs ...string) predicate.Blog {
+	return predicate.Blog(sql.FieldNotIn(FieldSearchVector, vs...))
+}
+
+// SearchVectorGT applies the GT predicate on the "search_vector" field.
+func SearchVectorGT(v string) predicate.Blog {
+	return predicate.Blog(sql.FieldGT(FieldSearchVector, v))
+}
+
+// SearchVectorGTE applies the GTE predicate on the "search_vector" field.
+func SearchVectorGTE(v string) predicate.Blog {
+	return predicate.Blog(sql.FieldGTE(FieldSearchVector, v))
+}
+
+// SearchVectorLT applies the LT predicate on the "search_vector" field.
+func SearchVectorLT(v string) predicate.Blog {
+	return predicate.Blog(sql.FieldLT(FieldSearchVector, v))
+}
+
+// SearchVectorLTE applies the LTE predicate on the "search_vector" field.
+func SearchVectorLTE(v string) predicate.Blog {
+	return predicate.Blog(sql.FieldLTE(FieldSearchVector, v))
+}
+
+// SearchVectorContains applies the Contains predicate on the "search_vector" field.
+func SearchVectorContains(v string) predicate.Blog {
+	return predicate.Blog(sql.FieldContains(FieldSearchVector, v))
+}
+
+// SearchVectorHasPrefix applies the HasPrefix predicate on the "search_vector" field.
+func SearchVectorHasPrefix(v string) predicate.Blog {
+	return predicate.Blog(sql.FieldHasPrefix(FieldSearchVector, v))
+}
+
+// SearchVectorHasSuffix applies the HasSuffix predicate on the "search_vector" field.
+func SearchVectorHasSuffix(v string) predicate.Blog {
+	return predicate.Blog(sql.FieldHasSuffix(FieldSearchVector, v))
+}
+
+// SearchVectorIsNil applies the IsNil predicate on the "search_vector" field.
+func SearchVectorIsNil() predicate.Blog {
+	return predicate.Blog(sql.FieldIsNull(FieldSearchVector))
+}
+
+// SearchVectorNotNil applies the NotNil predicate on the "search_vector" field.
+func SearchVectorNotNil() predicate.Blog {
+	return predicate.Blog(sql.FieldNotNull(FieldSearchVector))
+}
+
+// SearchVectorEqualFold applies the EqualFold predicate on the "search_vector" field.
+func SearchVectorEqualFold(v string) predicate.Blog {
+	return predicate.Blog(sql.FieldEqualFold(FieldSearchVector, v))
+}
+
+// SearchVectorContainsFold applies the ContainsFold predicate on the "search_vector" field.
+func SearchVectorContainsFold(v string) predicate.Blog {
+	return predicate.Blog(sql.FieldContainsFold(FieldSearchVector, v))
+}
+
 // HasUser applies the HasEdge predicate on the "user" edge.
 func HasUser() predicate.Blog {
 	return predicate.Blog(func(s *sql.Selector) {
@@ -5,10 +5,10 @@ package ent
 import (
 	"context"
 	"errors"
+	"fmt"
 	"go/djan/app/ent/blog"
 	"go/djan/app/ent/tag"
 	"go/djan/app/ent/user"
-	"fmt"
 	"time"
 
 	"entgo.io/ent/dialect/sql/sqlgraph"
@@ -62,6 +62,20 @@ func (bc *BlogCreate) SetNillableCreatedAt(t *time.Time) *BlogCreate {
 	return bc
 }
 
+// SetSearchVector sets the "search_vector" field.
+func (bc *BlogCreate) SetSearchVector(s string) *BlogCreate {
+	bc.mutation.SetSearchVector(s)
+	return bc
+}
+
+// SetNillableSearchVector sets the "search_vector" field if the given value is not nil.
+func (bc *BlogCreate) SetNillableSearchVector(s *string) *BlogCreate {
+	if s != nil {
+		bc.SetSearchVector(*s)
+	}
+	return bc
+}
+
 // SetUserID sets the "user" edge to the User entity by ID.
 func (bc *BlogCreate) SetUserID(id int) *BlogCreate {
 	bc.mutation.SetUserID(id)
@@ -205,6 +219,10 @@ func (bc *BlogCreate) createSpec() (*Blog, *sqlgraph.CreateSpec) {
 		_spec.SetField(blog.FieldCreatedAt, field.TypeTime, value)
 		_node.CreatedAt = value
 	}
+	if value, ok := bc.mutation.SearchVector(); ok {
+		_spec.SetField(blog.FieldSearchVector, field.TypeString, value)
+		_node.SearchVector = value
+	}
 	if nodes := bc.mutation.UserIDs(); len(nodes) > 0 {
 		edge := &sqlgraph.EdgeSpec{
 			Rel:     sqlgraph.M2O,
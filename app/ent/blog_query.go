@@ -5,11 +5,11 @@ package ent
 import (
 	"context"
 	"database/sql/driver"
+	"fmt"
 	"go/djan/app/ent/blog"
 	"go/djan/app/ent/predicate"
 	"go/djan/app/ent/tag"
 	"go/djan/app/ent/user"
-	"fmt"
 	"math"
 
 	"entgo.io/ent"
@@ -28,6 +28,7 @@ type BlogQuery struct {
 	withUser   *UserQuery
 	withTags   *TagQuery
 	withFKs    bool
+	modifiers  []func(*sql.Selector)
 	// intermediate query (i.e. traversal path).
 	sql  *sql.Selector
 	path func(context.Context) (*sql.Selector, error)
@@ -429,6 +430,9 @@ func (bq *BlogQuery) sqlAll(ctx context.Context, hooks ...queryHook) ([]*Blog, e
 		node.Edges.loadedTypes = loadedTypes
 		return node.assignValues(columns, values)
 	}
+	if len(bq.modifiers) > 0 {
+		_spec.Modifiers = bq.modifiers
+	}
 	for i := range hooks {
 		hooks[i](ctx, _spec)
 	}
@@ -550,6 +554,9 @@ func (bq *BlogQuery) loadTags(ctx context.Context, query *TagQuery, nodes []*Blo
 
 func (bq *BlogQuery) sqlCount(ctx context.Context) (int, error) {
 	_spec := bq.querySpec()
+	if len(bq.modifiers) > 0 {
+		_spec.Modifiers = bq.modifiers
+	}
 	_spec.Node.Columns = bq.ctx.Fields
 	if len(bq.ctx.Fields) > 0 {
 		_spec.Unique = bq.ctx.Unique != nil && *bq.ctx.Unique
@@ -612,6 +619,9 @@ func (bq *BlogQuery) sqlQuery(ctx context.Context) *sql.Selector {
 	if bq.ctx.Unique != nil && *bq.ctx.Unique {
 		selector.Distinct()
 	}
+	for _, m := range bq.modifiers {
+		m(selector)
+	}
 	for _, p := range bq.predicates {
 		p(selector)
 	}
@@ -629,6 +639,12 @@ func (bq *BlogQuery) sqlQuery(ctx context.Context) *sql.Selector {
 	return selector
 }
 
+// Modify adds a query modifier for attaching custom logic to queries.
+func (bq *BlogQuery) Modify(modifiers ...func(s *sql.Selector)) *BlogSelect {
+	bq.modifiers = append(bq.modifiers, modifiers...)
+	return bq.Select()
+}
+
 // BlogGroupBy is the group-by builder for Blog entities.
 type BlogGroupBy struct {
 	selector
@@ -718,3 +734,9 @@ func (bs *BlogSelect) sqlScan(ctx context.Context, root *BlogQuery, v any) error
 	defer rows.Close()
 	return sql.ScanSlice(rows, v)
 }
+
+// Modify adds a query modifier for attaching custom logic to queries.
+func (bs *BlogSelect) Modify(modifiers ...func(s *sql.Selector)) *BlogSelect {
+	bs.modifiers = append(bs.modifiers, modifiers...)
+	return bs
+}
@@ -5,11 +5,11 @@ package ent
 import (
 	"context"
 	"errors"
+	"fmt"
 	"go/djan/app/ent/blog"
 	"go/djan/app/ent/predicate"
 	"go/djan/app/ent/tag"
 	"go/djan/app/ent/user"
-	"fmt"
 
 	"entgo.io/ent/dialect/sql"
 	"entgo.io/ent/dialect/sql/sqlgraph"
@@ -19,8 +19,9 @@ import (
 // BlogUpdate is the builder for updating Blog entities.
 type BlogUpdate struct {
 	config
-	hooks    []Hook
-	mutation *BlogMutation
+	hooks     []Hook
+	mutation  *BlogMutation
+	modifiers []func(*sql.UpdateBuilder)
 }
 
 // Where appends a list predicates to the BlogUpdate builder.
@@ -84,6 +85,26 @@ func (bu *BlogUpdate) ClearEpisode() *BlogUpdate {
 	return bu
 }
 
+// SetSearchVector sets the "search_vector" field.
+func (bu *BlogUpdate) SetSearchVector(s string) *BlogUpdate {
+	bu.mutation.SetSearchVector(s)
+	return bu
+}
+
+// SetNillableSearchVector sets the "search_vector" field if the given value is not nil.
+func (bu *BlogUpdate) SetNillableSearchVector(s *string) *BlogUpdate {
+	if s != nil {
+		bu.SetSearchVector(*s)
+	}
+	return bu
+}
+
+// ClearSearchVector clears the value of the "search_vector" field.
+func (bu *BlogUpdate) ClearSearchVector() *BlogUpdate {
+	bu.mutation.ClearSearchVector()
+	return bu
+}
+
 // SetUserID sets the "user" edge to the User entity by ID.
 func (bu *BlogUpdate) SetUserID(id int) *BlogUpdate {
 	bu.mutation.SetUserID(id)
@@ -197,6 +218,12 @@ func (bu *BlogUpdate) check() error {
 	return nil
 }
 
+// Modify adds a statement modifier for attaching custom logic to the UPDATE statement.
+func (bu *BlogUpdate) Modify(modifiers ...func(u *sql.UpdateBuilder)) *BlogUpdate {
+	bu.modifiers = append(bu.modifiers, modifiers...)
+	return bu
+}
+
 func (bu *BlogUpdate) sqlSave(ctx context.Context) (n int, err error) {
 	if err := bu.check(); err != nil {
 		return n, err
@@ -224,6 +251,12 @@ func (bu *BlogUpdate) sqlSave(ctx context.Context) (n int, err error) {
 	if bu.mutation.EpisodeCleared() {
 		_spec.ClearField(blog.FieldEpisode, field.TypeInt)
 	}
+	if value, ok := bu.mutation.SearchVector(); ok {
+		_spec.SetField(blog.FieldSearchVector, field.TypeString, value)
+	}
+	if bu.mutation.SearchVectorCleared() {
+		_spec.ClearField(blog.FieldSearchVector, field.TypeString)
+	}
 	if bu.mutation.UserCleared() {
 		edge := &sqlgraph.EdgeSpec{
 			Rel:     sqlgraph.M2O,
@@ -298,6 +331,7 @@ func (bu *BlogUpdate) sqlSave(ctx context.Context) (n int, err error) {
 		}
 		_spec.Edges.Add = append(_spec.Edges.Add, edge)
 	}
+	_spec.AddModifiers(bu.modifiers...)
 	if n, err = sqlgraph.UpdateNodes(ctx, bu.driver, _spec); err != nil {
 		if _, ok := err.(*sqlgraph.NotFoundError); ok {
 			err = &NotFoundError{blog.Label}
@@ -313,9 +347,10 @@ func (bu *BlogUpdate) sqlSave(ctx context.Context) (n int, err error) {
 // BlogUpdateOne is the builder for updating a single Blog entity.
 type BlogUpdateOne struct {
 	config
-	fields   []string
-	hooks    []Hook
-	mutation *BlogMutation
+	fields    []string
+	hooks     []Hook
+	mutation  *BlogMutation
+	modifiers []func(*sql.UpdateBuilder)
 }
 
 // SetTitle sets the "title" field.
@@ -373,6 +408,26 @@ func (buo *BlogUpdateOne) ClearEpisode() *BlogUpdateOne {
 	return buo
 }
 
+// SetSearchVector sets the "search_vector" field.
+func (buo *BlogUpdateOne) SetSearchVector(s string) *BlogUpdateOne {
+	buo.mutation.SetSearchVector(s)
+	return buo
+}
+
+// SetNillableSearchVector sets the "search_vector" field if the given value is not nil.
+func (buo *BlogUpdateOne) SetNillableSearchVector(s *string) *BlogUpdateOne {
+	if s != nil {
+		buo.SetSearchVector(*s)
+	}
+	return buo
+}
+
+// ClearSearchVector clears the value of the "search_vector" field.
+func (buo *BlogUpdateOne) ClearSearchVector() *BlogUpdateOne {
+	buo.mutation.ClearSearchVector()
+	return buo
+}
+
 // SetUserID sets the "user" edge to the User entity by ID.
 func (buo *BlogUpdateOne) SetUserID(id int) *BlogUpdateOne {
 	buo.mutation.SetUserID(id)
@@ -499,6 +554,12 @@ func (buo *BlogUpdateOne) check() error {
 	return nil
 }
 
+// Modify adds a statement modifier for attaching custom logic to the UPDATE statement.
+func (buo *BlogUpdateOne) Modify(modifiers ...func(u *sql.UpdateBuilder)) *BlogUpdateOne {
+	buo.modifiers = append(buo.modifiers, modifiers...)
+	return buo
+}
+
 func (buo *BlogUpdateOne) sqlSave(ctx context.Context) (_node *Blog, err error) {
 	if err := buo.check(); err != nil {
 		return _node, err
@@ -543,6 +604,12 @@ func (buo *BlogUpdateOne) sqlSave(ctx context.Context) (_node *Blog, err error)
 	if buo.mutation.EpisodeCleared() {
 		_spec.ClearField(blog.FieldEpisode, field.TypeInt)
 	}
+	if value, ok := buo.mutation.SearchVector(); ok {
+		_spec.SetField(blog.FieldSearchVector, field.TypeString, value)
+	}
+	if buo.mutation.SearchVectorCleared() {
+		_spec.ClearField(blog.FieldSearchVector, field.TypeString)
+	}
 	if buo.mutation.UserCleared() {
 		edge := &sqlgraph.EdgeSpec{
 			Rel:     sqlgraph.M2O,
@@ -617,6 +684,7 @@ func (buo *BlogUpdateOne) sqlSave(ctx context.Context) (_node *Blog, err error)
 		}
 		_spec.Edges.Add = append(_spec.Edges.Add, edge)
 	}
+	_spec.AddModifiers(buo.modifiers...)
 	_node = &Blog{config: buo.config}
 	_spec.Assign = _node.assignValues
 	_spec.ScanValues = _node.scanValues
@@ -5,10 +5,16 @@ package ent
 import (
 	"context"
 	"errors"
+	"fmt"
+	"go/djan/app/ent/apikey"
 	"go/djan/app/ent/blog"
+	"go/djan/app/ent/identity"
+	"go/djan/app/ent/passwordreset"
+	"go/djan/app/ent/recoverycode"
+	"go/djan/app/ent/refreshtoken"
+	"go/djan/app/ent/revokedtoken"
 	"go/djan/app/ent/tag"
 	"go/djan/app/ent/user"
-	"fmt"
 	"reflect"
 	"sync"
 
@@ -75,9 +81,15 @@ var (
 func checkColumn(table, column string) error {
 	initCheck.Do(func() {
 		columnCheck = sql.NewColumnCheck(map[string]func(string) bool{
-			blog.Table: blog.ValidColumn,
-			tag.Table:  tag.ValidColumn,
-			user.Table: user.ValidColumn,
+			apikey.Table:        apikey.ValidColumn,
+			blog.Table:          blog.ValidColumn,
+			identity.Table:      identity.ValidColumn,
+			passwordreset.Table: passwordreset.ValidColumn,
+			recoverycode.Table:  recoverycode.ValidColumn,
+			refreshtoken.Table:  refreshtoken.ValidColumn,
+			revokedtoken.Table:  revokedtoken.ValidColumn,
+			tag.Table:           tag.ValidColumn,
+			user.Table:          user.ValidColumn,
 		})
 	})
 	return columnCheck(table, column)
@@ -0,0 +1,283 @@
+// Code generated by ent, DO NOT EDIT.
+
+package ent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/djan/app/ent/passwordreset"
+	"go/djan/app/ent/user"
+	"time"
+
+	"entgo.io/ent/dialect/sql/sqlgraph"
+	"entgo.io/ent/schema/field"
+)
+
+// PasswordResetCreate is the builder for creating a PasswordReset entity.
+type PasswordResetCreate struct {
+	config
+	mutation *PasswordResetMutation
+	hooks    []Hook
+}
+
+// SetTokenHash sets the "token_hash" field.
+func (prc *PasswordResetCreate) SetTokenHash(s string) *PasswordResetCreate {
+	prc.mutation.SetTokenHash(s)
+	return prc
+}
+
+// SetExpiresAt sets the "expires_at" field.
+func (prc *PasswordResetCreate) SetExpiresAt(t time.Time) *PasswordResetCreate {
+	prc.mutation.SetExpiresAt(t)
+	return prc
+}
+
+// SetUsedAt sets the "used_at" field.
+func (prc *PasswordResetCreate) SetUsedAt(t time.Time) *PasswordResetCreate {
+	prc.mutation.SetUsedAt(t)
+	return prc
+}
+
+// SetNillableUsedAt sets the "used_at" field if the given value is not nil.
+func (prc *PasswordResetCreate) SetNillableUsedAt(t *time.Time) *PasswordResetCreate {
+	if t != nil {
+		prc.SetUsedAt(*t)
+	}
+	return prc
+}
+
+// SetCreatedAt sets the "created_at" field.
+func (prc *PasswordResetCreate) SetCreatedAt(t time.Time) *PasswordResetCreate {
+	prc.mutation.SetCreatedAt(t)
+	return prc
+}
+
+// SetNillableCreatedAt sets the "created_at" field if the given value is not nil.
+func (prc *PasswordResetCreate) SetNillableCreatedAt(t *time.Time) *PasswordResetCreate {
+	if t != nil {
+		prc.SetCreatedAt(*t)
+	}
+	return prc
+}
+
+// SetUserID sets the "user" edge to the User entity by ID.
+func (prc *PasswordResetCreate) SetUserID(id int) *PasswordResetCreate {
+	prc.mutation.SetUserID(id)
+	return prc
+}
+
+// SetUser sets the "user" edge to the User entity.
+func (prc *PasswordResetCreate) SetUser(u *User) *PasswordResetCreate {
+	return prc.SetUserID(u.ID)
+}
+
+// Mutation returns the PasswordResetMutation object of the builder.
+func (prc *PasswordResetCreate) Mutation() *PasswordResetMutation {
+	return prc.mutation
+}
+
+// Save creates the PasswordReset in the database.
+func (prc *PasswordResetCreate) Save(ctx context.Context) (*PasswordReset, error) {
+	prc.defaults()
+	return withHooks(ctx, prc.sqlSave, prc.mutation, prc.hooks)
+}
+
+// SaveX calls Save and panics if Save returns an error.
+func (prc *PasswordResetCreate) SaveX(ctx context.Context) *PasswordReset {
+	v, err := prc.Save(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Exec executes the query.
+func (prc *PasswordResetCreate) Exec(ctx context.Context) error {
+	_, err := prc.Save(ctx)
+	return err
+}
+
+// ExecX is like Exec, but panics if an error occurs.
+func (prc *PasswordResetCreate) ExecX(ctx context.Context) {
+	if err := prc.Exec(ctx); err != nil {
+		panic(err)
+	}
+}
+
+// defaults sets the default values of the builder before save.
+func (prc *PasswordResetCreate) defaults() {
+	if _, ok := prc.mutation.CreatedAt(); !ok {
+		v := passwordreset.DefaultCreatedAt()
+		prc.mutation.SetCreatedAt(v)
+	}
+}
+
+// check runs all checks and user-defined validators on the builder.
+func (prc *PasswordResetCreate) check() error {
+	if _, ok := prc.mutation.TokenHash(); !ok {
+		return &ValidationError{Name: "token_hash", err: errors.New(`ent: missing required field "PasswordReset.token_hash"`)}
+	}
+	if v, ok := prc.mutation.TokenHash(); ok {
+		if err := passwordreset.TokenHashValidator(v); err != nil {
+			return &ValidationError{Name: "token_hash", err: fmt.Errorf(`ent: validator failed for field "PasswordReset.token_hash": %w`, err)}
+		}
+	}
+	if _, ok := prc.mutation.ExpiresAt(); !ok {
+		return &ValidationError{Name: "expires_at", err: errors.New(`ent: missing required field "PasswordReset.expires_at"`)}
+	}
+	if _, ok := prc.mutation.CreatedAt(); !ok {
+		return &ValidationError{Name: "created_at", err: errors.New(`ent: missing required field "PasswordReset.created_at"`)}
+	}
+	if len(prc.mutation.UserIDs()) == 0 {
+		return &ValidationError{Name: "user", err: errors.New(`ent: missing required edge "PasswordReset.user"`)}
+	}
+	return nil
+}
+
+func (prc *PasswordResetCreate) sqlSave(ctx context.Context) (*PasswordReset, error) {
+	if err := prc.check(); err != nil {
+		return nil, err
+	}
+	_node, _spec := prc.createSpec()
+	if err := sqlgraph.CreateNode(ctx, prc.driver, _spec); err != nil {
+		if sqlgraph.IsConstraintError(err) {
+			err = &ConstraintError{msg: err.Error(), wrap: err}
+		}
+		return nil, err
+	}
+	id := _spec.ID.Value.(int64)
+	_node.ID = int(id)
+	prc.mutation.id = &_node.ID
+	prc.mutation.done = true
+	return _node, nil
+}
+
+func (prc *PasswordResetCreate) createSpec() (*PasswordReset, *sqlgraph.CreateSpec) {
+	var (
+		_node = &PasswordReset{config: prc.config}
+		_spec = sqlgraph.NewCreateSpec(passwordreset.Table, sqlgraph.NewFieldSpec(passwordreset.FieldID, field.TypeInt))
+	)
+	if value, ok := prc.mutation.TokenHash(); ok {
+		_spec.SetField(passwordreset.FieldTokenHash, field.TypeString, value)
+		_node.TokenHash = value
+	}
+	if value, ok := prc.mutation.ExpiresAt(); ok {
+		_spec.SetField(passwordreset.FieldExpiresAt, field.TypeTime, value)
+		_node.ExpiresAt = value
+	}
+	if value, ok := prc.mutation.UsedAt(); ok {
+		_spec.SetField(passwordreset.FieldUsedAt, field.TypeTime, value)
+		_node.UsedAt = &value
+	}
+	if value, ok := prc.mutation.CreatedAt(); ok {
+		_spec.SetField(passwordreset.FieldCreatedAt, field.TypeTime, value)
+		_node.CreatedAt = value
+	}
+	if nodes := prc.mutation.UserIDs(); len(nodes) > 0 {
+		edge := &sqlgraph.EdgeSpec{
+			Rel:     sqlgraph.M2O,
+			Inverse: true,
+			Table:   passwordreset.UserTable,
+			Columns: []string{passwordreset.UserColumn},
+			Bidi:    false,
+			Target: &sqlgraph.EdgeTarget{
+				IDSpec: sqlgraph.NewFieldSpec(user.FieldID, field.TypeInt),
+			},
+		}
+		for _, k := range nodes {
+			edge.Target.Nodes = append(edge.Target.Nodes, k)
+		}
+		_node.user_password_resets = &nodes[0]
+		_spec.Edges = append(_spec.Edges, edge)
+	}
+	return _node, _spec
+}
+
+// PasswordResetCreateBulk is the builder for creating many PasswordReset entities in bulk.
+type PasswordResetCreateBulk struct {
+	config
+	err      error
+	builders []*PasswordResetCreate
+}
+
+// Save creates the PasswordReset entities in the database.
+func (prcb *PasswordResetCreateBulk) Save(ctx context.Context) ([]*PasswordReset, error) {
+	if prcb.err != nil {
+		return nil, prcb.err
+	}
+	specs := make([]*sqlgraph.CreateSpec, len(prcb.builders))
+	nodes := make([]*PasswordReset, len(prcb.builders))
+	mutators := make([]Mutator, len(prcb.builders))
+	for i := range prcb.builders {
+		func(i int, root context.Context) {
+			builder := prcb.builders[i]
+			builder.defaults()
+			var mut Mutator = MutateFunc(func(ctx context.Context, m Mutation) (Value, error) {
+				mutation, ok := m.(*PasswordResetMutation)
+				if !ok {
+					return nil, fmt.Errorf("unexpected mutation type %T", m)
+				}
+				if err := builder.check(); err != nil {
+					return nil, err
+				}
+				builder.mutation = mutation
+				var err error
+				nodes[i], specs[i] = builder.createSpec()
+				if i < len(mutators)-1 {
+					_, err = mutators[i+1].Mutate(root, prcb.builders[i+1].mutation)
+				} else {
+					spec := &sqlgraph.BatchCreateSpec{Nodes: specs}
+					// Invoke the actual operation on the latest mutation in the chain.
+					if err = sqlgraph.BatchCreate(ctx, prcb.driver, spec); err != nil {
+						if sqlgraph.IsConstraintError(err) {
+							err = &ConstraintError{msg: err.Error(), wrap: err}
+						}
+					}
+				}
+				if err != nil {
+					return nil, err
+				}
+				mutation.id = &nodes[i].ID
+				if specs[i].ID.Value != nil {
+					id := specs[i].ID.Value.(int64)
+					nodes[i].ID = int(id)
+				}
+				mutation.done = true
+				return nodes[i], nil
+			})
+			for i := len(builder.hooks) - 1; i >= 0; i-- {
+				mut = builder.hooks[i](mut)
+			}
+			mutators[i] = mut
+		}(i, ctx)
+	}
+	if len(mutators) > 0 {
+		if _, err := mutators[0].Mutate(ctx, prcb.builders[0].mutation); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+// SaveX is like Save, but panics if an error occurs.
+func (prcb *PasswordResetCreateBulk) SaveX(ctx context.Context) []*PasswordReset {
+	v, err := prcb.Save(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Exec executes the query.
+func (prcb *PasswordResetCreateBulk) Exec(ctx context.Context) error {
+	_, err := prcb.Save(ctx)
+	return err
+}
+
+// ExecX is like Exec, but panics if an error occurs.
+func (prcb *PasswordResetCreateBulk) ExecX(ctx context.Context) {
+	if err := prcb.Exec(ctx); err != nil {
+		panic(err)
+	}
+}
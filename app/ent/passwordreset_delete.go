@@ -0,0 +1,88 @@
+// Code generated by ent, DO NOT EDIT.
+
+package ent
+
+import (
+	"context"
+	"go/djan/app/ent/passwordreset"
+	"go/djan/app/ent/predicate"
+
+	"entgo.io/ent/dialect/sql"
+	"entgo.io/ent/dialect/sql/sqlgraph"
+	"entgo.io/ent/schema/field"
+)
+
+// PasswordResetDelete is the builder for deleting a PasswordReset entity.
+type PasswordResetDelete struct {
+	config
+	hooks    []Hook
+	mutation *PasswordResetMutation
+}
+
+// Where appends a list predicates to the PasswordResetDelete builder.
+func (prd *PasswordResetDelete) Where(ps ...predicate.PasswordReset) *PasswordResetDelete {
+	prd.mutation.Where(ps...)
+	return prd
+}
+
+// Exec executes the deletion query and returns how many vertices were deleted.
+func (prd *PasswordResetDelete) Exec(ctx context.Context) (int, error) {
+	return withHooks(ctx, prd.sqlExec, prd.mutation, prd.hooks)
+}
+
+// ExecX is like Exec, but panics if an error occurs.
+func (prd *PasswordResetDelete) ExecX(ctx context.Context) int {
+	n, err := prd.Exec(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func (prd *PasswordResetDelete) sqlExec(ctx context.Context) (int, error) {
+	_spec := sqlgraph.NewDeleteSpec(passwordreset.Table, sqlgraph.NewFieldSpec(passwordreset.FieldID, field.TypeInt))
+	if ps := prd.mutation.predicates; len(ps) > 0 {
+		_spec.Predicate = func(selector *sql.Selector) {
+			for i := range ps {
+				ps[i](selector)
+			}
+		}
+	}
+	affected, err := sqlgraph.DeleteNodes(ctx, prd.driver, _spec)
+	if err != nil && sqlgraph.IsConstraintError(err) {
+		err = &ConstraintError{msg: err.Error(), wrap: err}
+	}
+	prd.mutation.done = true
+	return affected, err
+}
+
+// PasswordResetDeleteOne is the builder for deleting a single PasswordReset entity.
+type PasswordResetDeleteOne struct {
+	prd *PasswordResetDelete
+}
+
+// Where appends a list predicates to the PasswordResetDelete builder.
+func (prdo *PasswordResetDeleteOne) Where(ps ...predicate.PasswordReset) *PasswordResetDeleteOne {
+	prdo.prd.mutation.Where(ps...)
+	return prdo
+}
+
+// Exec executes the deletion query.
+func (prdo *PasswordResetDeleteOne) Exec(ctx context.Context) error {
+	n, err := prdo.prd.Exec(ctx)
+	switch {
+	case err != nil:
+		return err
+	case n == 0:
+		return &NotFoundError{passwordreset.Label}
+	default:
+		return nil
+	}
+}
+
+// ExecX is like Exec, but panics if an error occurs.
+func (prdo *PasswordResetDeleteOne) ExecX(ctx context.Context) {
+	if err := prdo.Exec(ctx); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,636 @@
+// Code generated by ent, DO NOT EDIT.
+
+package ent
+
+import (
+	"context"
+	"fmt"
+	"go/djan/app/ent/passwordreset"
+	"go/djan/app/ent/predicate"
+	"go/djan/app/ent/user"
+	"math"
+
+	"entgo.io/ent"
+	"entgo.io/ent/dialect/sql"
+	"entgo.io/ent/dialect/sql/sqlgraph"
+	"entgo.io/ent/schema/field"
+)
+
+// PasswordResetQuery is the builder for querying PasswordReset entities.
+type PasswordResetQuery struct {
+	config
+	ctx        *QueryContext
+	order      []passwordreset.OrderOption
+	inters     []Interceptor
+	predicates []predicate.PasswordReset
+	withUser   *UserQuery
+	withFKs    bool
+	modifiers  []func(*sql.Selector)
+	// intermediate query (i.e. traversal path).
+	sql  *sql.Selector
+	path func(context.Context) (*sql.Selector, error)
+}
+
+// Where adds a new predicate for the PasswordResetQuery builder.
+func (prq *PasswordResetQuery) Where(ps ...predicate.PasswordReset) *PasswordResetQuery {
+	prq.predicates = append(prq.predicates, ps...)
+	return prq
+}
+
+// Limit the number of records to be returned by this query.
+func (prq *PasswordResetQuery) Limit(limit int) *PasswordResetQuery {
+	prq.ctx.Limit = &limit
+	return prq
+}
+
+// Offset to start from.
+func (prq *PasswordResetQuery) Offset(offset int) *PasswordResetQuery {
+	prq.ctx.Offset = &offset
+	return prq
+}
+
+// Unique configures the query builder to filter duplicate records on query.
+// By default, unique is set to true, and can be disabled using this method.
+func (prq *PasswordResetQuery) Unique(unique bool) *PasswordResetQuery {
+	prq.ctx.Unique = &unique
+	return prq
+}
+
+// Order specifies how the records should be ordered.
+func (prq *PasswordResetQuery) Order(o ...passwordreset.OrderOption) *PasswordResetQuery {
+	prq.order = append(prq.order, o...)
+	return prq
+}
+
+// QueryUser chains the current query on the "user" edge.
+func (prq *PasswordResetQuery) QueryUser() *UserQuery {
+	query := (&UserClient{config: prq.config}).Query()
+	query.path = func(ctx context.Context) (fromU *sql.Selector, err error) {
+		if err := prq.prepareQuery(ctx); err != nil {
+			return nil, err
+		}
+		selector := prq.sqlQuery(ctx)
+		if err := selector.Err(); err != nil {
+			return nil, err
+		}
+		step := sqlgraph.NewStep(
+			sqlgraph.From(passwordreset.Table, passwordreset.FieldID, selector),
+			sqlgraph.To(user.Table, user.FieldID),
+			sqlgraph.Edge(sqlgraph.M2O, true, passwordreset.UserTable, passwordreset.UserColumn),
+		)
+		fromU = sqlgraph.SetNeighbors(prq.driver.Dialect(), step)
+		return fromU, nil
+	}
+	return query
+}
+
+// First returns the first PasswordReset entity from the query.
+// Returns a *NotFoundError when no PasswordReset was found.
+func (prq *PasswordResetQuery) First(ctx context.Context) (*PasswordReset, error) {
+	nodes, err := prq.Limit(1).All(setContextOp(ctx, prq.ctx, ent.OpQueryFirst))
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, &NotFoundError{passwordreset.Label}
+	}
+	return nodes[0], nil
+}
+
+// FirstX is like First, but panics if an error occurs.
+func (prq *PasswordResetQuery) FirstX(ctx context.Context) *PasswordReset {
+	node, err := prq.First(ctx)
+	if err != nil && !IsNotFound(err) {
+		panic(err)
+	}
+	return node
+}
+
+// FirstID returns the first PasswordReset ID from the query.
+// Returns a *NotFoundError when no PasswordReset ID was found.
+func (prq *PasswordResetQuery) FirstID(ctx context.Context) (id int, err error) {
+	var ids []int
+	if ids, err = prq.Limit(1).IDs(setContextOp(ctx, prq.ctx, ent.OpQueryFirstID)); err != nil {
+		return
+	}
+	if len(ids) == 0 {
+		err = &NotFoundError{passwordreset.Label}
+		return
+	}
+	return ids[0], nil
+}
+
+// FirstIDX is like FirstID, but panics if an error occurs.
+func (prq *PasswordResetQuery) FirstIDX(ctx context.Context) int {
+	id, err := prq.FirstID(ctx)
+	if err != nil && !IsNotFound(err) {
+		panic(err)
+	}
+	return id
+}
+
+// Only returns a single PasswordReset entity found by the query, ensuring it only returns one.
+// Returns a *NotSingularError when more than one PasswordReset entity is found.
+// Returns a *NotFoundError when no PasswordReset entities are found.
+func (prq *PasswordResetQuery) Only(ctx context.Context) (*PasswordReset, error) {
+	nodes, err := prq.Limit(2).All(setContextOp(ctx, prq.ctx, ent.OpQueryOnly))
+	if err != nil {
+		return nil, err
+	}
+	switch len(nodes) {
+	case 1:
+		return nodes[0], nil
+	case 0:
+		return nil, &NotFoundError{passwordreset.Label}
+	default:
+		return nil, &NotSingularError{passwordreset.Label}
+	}
+}
+
+// OnlyX is like Only, but panics if an error occurs.
+func (prq *PasswordResetQuery) OnlyX(ctx context.Context) *PasswordReset {
+	node, err := prq.Only(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return node
+}
+
+// OnlyID is like Only, but returns the only PasswordReset ID in the query.
+// Returns a *NotSingularError when more than one PasswordReset ID is found.
+// Returns a *NotFoundError when no entities are found.
+func (prq *PasswordResetQuery) OnlyID(ctx context.Context) (id int, err error) {
+	var ids []int
+	if ids, err = prq.Limit(2).IDs(setContextOp(ctx, prq.ctx, ent.OpQueryOnlyID)); err != nil {
+		return
+	}
+	switch len(ids) {
+	case 1:
+		id = ids[0]
+	case 0:
+		err = &NotFoundError{passwordreset.Label}
+	default:
+		err = &NotSingularError{passwordreset.Label}
+	}
+	return
+}
+
+// OnlyIDX is like OnlyID, but panics if an error occurs.
+func (prq *PasswordResetQuery) OnlyIDX(ctx context.Context) int {
+	id, err := prq.OnlyID(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// All executes the query and returns a list of PasswordResets.
+func (prq *PasswordResetQuery) All(ctx context.Context) ([]*PasswordReset, error) {
+	ctx = setContextOp(ctx, prq.ctx, ent.OpQueryAll)
+	if err := prq.prepareQuery(ctx); err != nil {
+		return nil, err
+	}
+	qr := querierAll[[]*PasswordReset, *PasswordResetQuery]()
+	return withInterceptors[[]*PasswordReset](ctx, prq, qr, prq.inters)
+}
+
+// AllX is like All, but panics if an error occurs.
+func (prq *PasswordResetQuery) AllX(ctx context.Context) []*PasswordReset {
+	nodes, err := prq.All(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return nodes
+}
+
+// IDs executes the query and returns a list of PasswordReset IDs.
+func (prq *PasswordResetQuery) IDs(ctx context.Context) (ids []int, err error) {
+	if prq.ctx.Unique == nil && prq.path != nil {
+		prq.Unique(true)
+	}
+	ctx = setContextOp(ctx, prq.ctx, ent.OpQueryIDs)
+	if err = prq.Select(passwordreset.FieldID).Scan(ctx, &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// IDsX is like IDs, but panics if an error occurs.
+func (prq *PasswordResetQuery) IDsX(ctx context.Context) []int {
+	ids, err := prq.IDs(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return ids
+}
+
+// Count returns the count of the given query.
+func (prq *PasswordResetQuery) Count(ctx context.Context) (int, error) {
+	ctx = setContextOp(ctx, prq.ctx, ent.OpQueryCount)
+	if err := prq.prepareQuery(ctx); err != nil {
+		return 0, err
+	}
+	return withInterceptors[int](ctx, prq, querierCount[*PasswordResetQuery](), prq.inters)
+}
+
+// CountX is like Count, but panics if an error occurs.
+func (prq *PasswordResetQuery) CountX(ctx context.Context) int {
+	count, err := prq.Count(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return count
+}
+
+// Exist returns true if the query has elements in the graph.
+func (prq *PasswordResetQuery) Exist(ctx context.Context) (bool, error) {
+	ctx = setContextOp(ctx, prq.ctx, ent.OpQueryExist)
+	switch _, err := prq.FirstID(ctx); {
+	case IsNotFound(err):
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("ent: check existence: %w", err)
+	default:
+		return true, nil
+	}
+}
+
+// ExistX is like Exist, but panics if an error occurs.
+func (prq *PasswordResetQuery) ExistX(ctx context.Context) bool {
+	exist, err := prq.Exist(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return exist
+}
+
+// Clone returns a duplicate of the PasswordResetQuery builder, including all associated steps. It can be
+// used to prepare common query builders and use them differently after the clone is made.
+func (prq *PasswordResetQuery) Clone() *PasswordResetQuery {
+	if prq == nil {
+		return nil
+	}
+	return &PasswordResetQuery{
+		config:     prq.config,
+		ctx:        prq.ctx.Clone(),
+		order:      append([]passwordreset.OrderOption{}, prq.order...),
+		inters:     append([]Interceptor{}, prq.inters...),
+		predicates: append([]predicate.PasswordReset{}, prq.predicates...),
+		withUser:   prq.withUser.Clone(),
+		// clone intermediate query.
+		sql:  prq.sql.Clone(),
+		path: prq.path,
+	}
+}
+
+// WithUser tells the query-builder to eager-load the nodes that are connected to
+// the "user" edge. The optional arguments are used to configure the query builder of the edge.
+func (prq *PasswordResetQuery) WithUser(opts ...func(*UserQuery)) *PasswordResetQuery {
+	query := (&UserClient{config: prq.config}).Query()
+	for _, opt := range opts {
+		opt(query)
+	}
+	prq.withUser = query
+	return prq
+}
+
+// GroupBy is used to group vertices by one or more fields/columns.
+// It is often used with aggregate functions, like: count, max, mean, min, sum.
+//
+// Example:
+//
+//	var v []struct {
+//		TokenHash string `json:"token_hash,omitempty"`
+//		Count int `json:"count,omitempty"`
+//	}
+//
+//	client.PasswordReset.Query().
+//		GroupBy(passwordreset.FieldTokenHash).
+//		Aggregate(ent.Count()).
+//		Scan(ctx, &v)
+func (prq *PasswordResetQuery) GroupBy(field string, fields ...string) *PasswordResetGroupBy {
+	prq.ctx.Fields = append([]string{field}, fields...)
+	grbuild := &PasswordResetGroupBy{build: prq}
+	grbuild.flds = &prq.ctx.Fields
+	grbuild.label = passwordreset.Label
+	grbuild.scan = grbuild.Scan
+	return grbuild
+}
+
+// Select allows the selection one or more fields/columns for the given query,
+// instead of selecting all fields in the entity.
+//
+// Example:
+//
+//	var v []struct {
+//		TokenHash string `json:"token_hash,omitempty"`
+//	}
+//
+//	client.PasswordReset.Query().
+//		Select(passwordreset.FieldTokenHash).
+//		Scan(ctx, &v)
+func (prq *PasswordResetQuery) Select(fields ...string) *PasswordResetSelect {
+	prq.ctx.Fields = append(prq.ctx.Fields, fields...)
+	sbuild := &PasswordResetSelect{PasswordResetQuery: prq}
+	sbuild.label = passwordreset.Label
+	sbuild.flds, sbuild.scan = &prq.ctx.Fields, sbuild.Scan
+	return sbuild
+}
+
+// Aggregate returns a PasswordResetSelect configured with the given aggregations.
+func (prq *PasswordResetQuery) Aggregate(fns ...AggregateFunc) *PasswordResetSelect {
+	return prq.Select().Aggregate(fns...)
+}
+
+func (prq *PasswordResetQuery) prepareQuery(ctx context.Context) error {
+	for _, inter := range prq.inters {
+		if inter == nil {
+			return fmt.Errorf("ent: uninitialized interceptor (forgotten import ent/runtime?)")
+		}
+		if trv, ok := inter.(Traverser); ok {
+			if err := trv.Traverse(ctx, prq); err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range prq.ctx.Fields {
+		if !passwordreset.ValidColumn(f) {
+			return &ValidationError{Name: f, err: fmt.Errorf("ent: invalid field %q for query", f)}
+		}
+	}
+	if prq.path != nil {
+		prev, err := prq.path(ctx)
+		if err != nil {
+			return err
+		}
+		prq.sql = prev
+	}
+	return nil
+}
+
+func (prq *PasswordResetQuery) sqlAll(ctx context.Context, hooks ...queryHook) ([]*PasswordReset, error) {
+	var (
+		nodes       = []*PasswordReset{}
+		withFKs     = prq.withFKs
+		_spec       = prq.querySpec()
+		loadedTypes = [1]bool{
+			prq.withUser != nil,
+		}
+	)
+	if prq.withUser != nil {
+		withFKs = true
+	}
+	if withFKs {
+		_spec.Node.Columns = append(_spec.Node.Columns, passwordreset.ForeignKeys...)
+	}
+	_spec.ScanValues = func(columns []string) ([]any, error) {
+		return (*PasswordReset).scanValues(nil, columns)
+	}
+	_spec.Assign = func(columns []string, values []any) error {
+		node := &PasswordReset{config: prq.config}
+		nodes = append(nodes, node)
+		node.Edges.loadedTypes = loadedTypes
+		return node.assignValues(columns, values)
+	}
+	if len(prq.modifiers) > 0 {
+		_spec.Modifiers = prq.modifiers
+	}
+	for i := range hooks {
+		hooks[i](ctx, _spec)
+	}
+	if err := sqlgraph.QueryNodes(ctx, prq.driver, _spec); err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nodes, nil
+	}
+	if query := prq.withUser; query != nil {
+		if err := prq.loadUser(ctx, query, nodes, nil,
+			func(n *PasswordReset, e *User) { n.Edges.User = e }); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+func (prq *PasswordResetQuery) loadUser(ctx context.Context, query *UserQuery, nodes []*PasswordReset, init func(*PasswordReset), assign func(*PasswordReset, *User)) error {
+	ids := make([]int, 0, len(nodes))
+	nodeids := make(map[int][]*PasswordReset)
+	for i := range nodes {
+		if nodes[i].user_password_resets == nil {
+			continue
+		}
+		fk := *nodes[i].user_password_resets
+		if _, ok := nodeids[fk]; !ok {
+			ids = append(ids, fk)
+		}
+		nodeids[fk] = append(nodeids[fk], nodes[i])
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	query.Where(user.IDIn(ids...))
+	neighbors, err := query.All(ctx)
+	if err != nil {
+		return err
+	}
+	for _, n := range neighbors {
+		nodes, ok := nodeids[n.ID]
+		if !ok {
+			return fmt.Errorf(`unexpected foreign-key "user_password_resets" returned %v`, n.ID)
+		}
+		for i := range nodes {
+			assign(nodes[i], n)
+		}
+	}
+	return nil
+}
+
+func (prq *PasswordResetQuery) sqlCount(ctx context.Context) (int, error) {
+	_spec := prq.querySpec()
+	if len(prq.modifiers) > 0 {
+		_spec.Modifiers = prq.modifiers
+	}
+	_spec.Node.Columns = prq.ctx.Fields
+	if len(prq.ctx.Fields) > 0 {
+		_spec.Unique = prq.ctx.Unique != nil && *prq.ctx.Unique
+	}
+	return sqlgraph.CountNodes(ctx, prq.driver, _spec)
+}
+
+func (prq *PasswordResetQuery) querySpec() *sqlgraph.QuerySpec {
+	_spec := sqlgraph.NewQuerySpec(passwordreset.Table, passwordreset.Columns, sqlgraph.NewFieldSpec(passwordreset.FieldID, field.TypeInt))
+	_spec.From = prq.sql
+	if unique := prq.ctx.Unique; unique != nil {
+		_spec.Unique = *unique
+	} else if prq.path != nil {
+		_spec.Unique = true
+	}
+	if fields := prq.ctx.Fields; len(fields) > 0 {
+		_spec.Node.Columns = make([]string, 0, len(fields))
+		_spec.Node.Columns = append(_spec.Node.Columns, passwordreset.FieldID)
+		for i := range fields {
+			if fields[i] != passwordreset.FieldID {
+				_spec.Node.Columns = append(_spec.Node.Columns, fields[i])
+			}
+		}
+	}
+	if ps := prq.predicates; len(ps) > 0 {
+		_spec.Predicate = func(selector *sql.Selector) {
+			for i := range ps {
+				ps[i](selector)
+			}
+		}
+	}
+	if limit := prq.ctx.Limit; limit != nil {
+		_spec.Limit = *limit
+	}
+	if offset := prq.ctx.Offset; offset != nil {
+		_spec.Offset = *offset
+	}
+	if ps := prq.order; len(ps) > 0 {
+		_spec.Order = func(selector *sql.Selector) {
+			for i := range ps {
+				ps[i](selector)
+			}
+		}
+	}
+	return _spec
+}
+
+func (prq *PasswordResetQuery) sqlQuery(ctx context.Context) *sql.Selector {
+	builder := sql.Dialect(prq.driver.Dialect())
+	t1 := builder.Table(passwordreset.Table)
+	columns := prq.ctx.Fields
+	if len(columns) == 0 {
+		columns = passwordreset.Columns
+	}
+	selector := builder.Select(t1.Columns(columns...)...).From(t1)
+	if prq.sql != nil {
+		selector = prq.sql
+		selector.Select(selector.Columns(columns...)...)
+	}
+	if prq.ctx.Unique != nil && *prq.ctx.Unique {
+		selector.Distinct()
+	}
+	for _, m := range prq.modifiers {
+		m(selector)
+	}
+	for _, p := range prq.predicates {
+		p(selector)
+	}
+	for _, p := range prq.order {
+		p(selector)
+	}
+	if offset := prq.ctx.Offset; offset != nil {
+		// limit is mandatory for offset clause. We start
+		// with default value, and override it below if needed.
+		selector.Offset(*offset).Limit(math.MaxInt32)
+	}
+	if limit := prq.ctx.Limit; limit != nil {
+		selector.Limit(*limit)
+	}
+	return selector
+}
+
+// Modify adds a query modifier for attaching custom logic to queries.
+func (prq *PasswordResetQuery) Modify(modifiers ...func(s *sql.Selector)) *PasswordResetSelect {
+	prq.modifiers = append(prq.modifiers, modifiers...)
+	return prq.Select()
+}
+
+// PasswordResetGroupBy is the group-by builder for PasswordReset entities.
+type PasswordResetGroupBy struct {
+	selector
+	build *PasswordResetQuery
+}
+
+// Aggregate adds the given aggregation functions to the group-by query.
+func (prgb *PasswordResetGroupBy) Aggregate(fns ...AggregateFunc) *PasswordResetGroupBy {
+	prgb.fns = append(prgb.fns, fns...)
+	return prgb
+}
+
+// Scan applies the selector query and scans the result into the given value.
+func (prgb *PasswordResetGroupBy) Scan(ctx context.Context, v any) error {
+	ctx = setContextOp(ctx, prgb.build.ctx, ent.OpQueryGroupBy)
+	if err := prgb.build.prepareQuery(ctx); err != nil {
+		return err
+	}
+	return scanWithInterceptors[*PasswordResetQuery, *PasswordResetGroupBy](ctx, prgb.build, prgb, prgb.build.inters, v)
+}
+
+func (prgb *PasswordResetGroupBy) sqlScan(ctx context.Context, root *PasswordResetQuery, v any) error {
+	selector := root.sqlQuery(ctx).Select()
+	aggregation := make([]string, 0, len(prgb.fns))
+	for _, fn := range prgb.fns {
+		aggregation = append(aggregation, fn(selector))
+	}
+	if len(selector.SelectedColumns()) == 0 {
+		columns := make([]string, 0, len(*prgb.flds)+len(prgb.fns))
+		for _, f := range *prgb.flds {
+			columns = append(columns, selector.C(f))
+		}
+		columns = append(columns, aggregation...)
+		selector.Select(columns...)
+	}
+	selector.GroupBy(selector.Columns(*prgb.flds...)...)
+	if err := selector.Err(); err != nil {
+		return err
+	}
+	rows := &sql.Rows{}
+	query, args := selector.Query()
+	if err := prgb.build.driver.Query(ctx, query, args, rows); err != nil {
+		return err
+	}
+	defer rows.Close()
+	return sql.ScanSlice(rows, v)
+}
+
+// PasswordResetSelect is the builder for selecting fields of PasswordReset entities.
+type PasswordResetSelect struct {
+	*PasswordResetQuery
+	selector
+}
+
+// Aggregate adds the given aggregation functions to the selector query.
+func (prs *PasswordResetSelect) Aggregate(fns ...AggregateFunc) *PasswordResetSelect {
+	prs.fns = append(prs.fns, fns...)
+	return prs
+}
+
+// Scan applies the selector query and scans the result into the given value.
+func (prs *PasswordResetSelect) Scan(ctx context.Context, v any) error {
+	ctx = setContextOp(ctx, prs.ctx, ent.OpQuerySelect)
+	if err := prs.prepareQuery(ctx); err != nil {
+		return err
+	}
+	return scanWithInterceptors[*PasswordResetQuery, *PasswordResetSelect](ctx, prs.PasswordResetQuery, prs, prs.inters, v)
+}
+
+func (prs *PasswordResetSelect) sqlScan(ctx context.Context, root *PasswordResetQuery, v any) error {
+	selector := root.sqlQuery(ctx)
+	aggregation := make([]string, 0, len(prs.fns))
+	for _, fn := range prs.fns {
+		aggregation = append(aggregation, fn(selector))
+	}
+	switch n := len(*prs.selector.flds); {
+	case n == 0 && len(aggregation) > 0:
+		selector.Select(aggregation...)
+	case n != 0 && len(aggregation) > 0:
+		selector.AppendSelect(aggregation...)
+	}
+	rows := &sql.Rows{}
+	query, args := selector.Query()
+	if err := prs.driver.Query(ctx, query, args, rows); err != nil {
+		return err
+	}
+	defer rows.Close()
+	return sql.ScanSlice(rows, v)
+}
+
+// Modify adds a query modifier for attaching custom logic to queries.
+func (prs *PasswordResetSelect) Modify(modifiers ...func(s *sql.Selector)) *PasswordResetSelect {
+	prs.modifiers = append(prs.modifiers, modifiers...)
+	return prs
+}
@@ -0,0 +1,270 @@
+// Code generated by ent, DO NOT EDIT.
+
+package ent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/djan/app/ent/recoverycode"
+	"go/djan/app/ent/user"
+	"time"
+
+	"entgo.io/ent/dialect/sql/sqlgraph"
+	"entgo.io/ent/schema/field"
+)
+
+// RecoveryCodeCreate is the builder for creating a RecoveryCode entity.
+type RecoveryCodeCreate struct {
+	config
+	mutation *RecoveryCodeMutation
+	hooks    []Hook
+}
+
+// SetCodeHash sets the "code_hash" field.
+func (rcc *RecoveryCodeCreate) SetCodeHash(s string) *RecoveryCodeCreate {
+	rcc.mutation.SetCodeHash(s)
+	return rcc
+}
+
+// SetUsedAt sets the "used_at" field.
+func (rcc *RecoveryCodeCreate) SetUsedAt(t time.Time) *RecoveryCodeCreate {
+	rcc.mutation.SetUsedAt(t)
+	return rcc
+}
+
+// SetNillableUsedAt sets the "used_at" field if the given value is not nil.
+func (rcc *RecoveryCodeCreate) SetNillableUsedAt(t *time.Time) *RecoveryCodeCreate {
+	if t != nil {
+		rcc.SetUsedAt(*t)
+	}
+	return rcc
+}
+
+// SetCreatedAt sets the "created_at" field.
+func (rcc *RecoveryCodeCreate) SetCreatedAt(t time.Time) *RecoveryCodeCreate {
+	rcc.mutation.SetCreatedAt(t)
+	return rcc
+}
+
+// SetNillableCreatedAt sets the "created_at" field if the given value is not nil.
+func (rcc *RecoveryCodeCreate) SetNillableCreatedAt(t *time.Time) *RecoveryCodeCreate {
+	if t != nil {
+		rcc.SetCreatedAt(*t)
+	}
+	return rcc
+}
+
+// SetUserID sets the "user" edge to the User entity by ID.
+func (rcc *RecoveryCodeCreate) SetUserID(id int) *RecoveryCodeCreate {
+	rcc.mutation.SetUserID(id)
+	return rcc
+}
+
+// SetUser sets the "user" edge to the User entity.
+func (rcc *RecoveryCodeCreate) SetUser(u *User) *RecoveryCodeCreate {
+	return rcc.SetUserID(u.ID)
+}
+
+// Mutation returns the RecoveryCodeMutation object of the builder.
+func (rcc *RecoveryCodeCreate) Mutation() *RecoveryCodeMutation {
+	return rcc.mutation
+}
+
+// Save creates the RecoveryCode in the database.
+func (rcc *RecoveryCodeCreate) Save(ctx context.Context) (*RecoveryCode, error) {
+	rcc.defaults()
+	return withHooks(ctx, rcc.sqlSave, rcc.mutation, rcc.hooks)
+}
+
+// SaveX calls Save and panics if Save returns an error.
+func (rcc *RecoveryCodeCreate) SaveX(ctx context.Context) *RecoveryCode {
+	v, err := rcc.Save(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Exec executes the query.
+func (rcc *RecoveryCodeCreate) Exec(ctx context.Context) error {
+	_, err := rcc.Save(ctx)
+	return err
+}
+
+// ExecX is like Exec, but panics if an error occurs.
+func (rcc *RecoveryCodeCreate) ExecX(ctx context.Context) {
+	if err := rcc.Exec(ctx); err != nil {
+		panic(err)
+	}
+}
+
+// defaults sets the default values of the builder before save.
+func (rcc *RecoveryCodeCreate) defaults() {
+	if _, ok := rcc.mutation.CreatedAt(); !ok {
+		v := recoverycode.DefaultCreatedAt()
+		rcc.mutation.SetCreatedAt(v)
+	}
+}
+
+// check runs all checks and user-defined validators on the builder.
+func (rcc *RecoveryCodeCreate) check() error {
+	if _, ok := rcc.mutation.CodeHash(); !ok {
+		return &ValidationError{Name: "code_hash", err: errors.New(`ent: missing required field "RecoveryCode.code_hash"`)}
+	}
+	if v, ok := rcc.mutation.CodeHash(); ok {
+		if err := recoverycode.CodeHashValidator(v); err != nil {
+			return &ValidationError{Name: "code_hash", err: fmt.Errorf(`ent: validator failed for field "RecoveryCode.code_hash": %w`, err)}
+		}
+	}
+	if _, ok := rcc.mutation.CreatedAt(); !ok {
+		return &ValidationError{Name: "created_at", err: errors.New(`ent: missing required field "RecoveryCode.created_at"`)}
+	}
+	if len(rcc.mutation.UserIDs()) == 0 {
+		return &ValidationError{Name: "user", err: errors.New(`ent: missing required edge "RecoveryCode.user"`)}
+	}
+	return nil
+}
+
+func (rcc *RecoveryCodeCreate) sqlSave(ctx context.Context) (*RecoveryCode, error) {
+	if err := rcc.check(); err != nil {
+		return nil, err
+	}
+	_node, _spec := rcc.createSpec()
+	if err := sqlgraph.CreateNode(ctx, rcc.driver, _spec); err != nil {
+		if sqlgraph.IsConstraintError(err) {
+			err = &ConstraintError{msg: err.Error(), wrap: err}
+		}
+		return nil, err
+	}
+	id := _spec.ID.Value.(int64)
+	_node.ID = int(id)
+	rcc.mutation.id = &_node.ID
+	rcc.mutation.done = true
+	return _node, nil
+}
+
+func (rcc *RecoveryCodeCreate) createSpec() (*RecoveryCode, *sqlgraph.CreateSpec) {
+	var (
+		_node = &RecoveryCode{config: rcc.config}
+		_spec = sqlgraph.NewCreateSpec(recoverycode.Table, sqlgraph.NewFieldSpec(recoverycode.FieldID, field.TypeInt))
+	)
+	if value, ok := rcc.mutation.CodeHash(); ok {
+		_spec.SetField(recoverycode.FieldCodeHash, field.TypeString, value)
+		_node.CodeHash = value
+	}
+	if value, ok := rcc.mutation.UsedAt(); ok {
+		_spec.SetField(recoverycode.FieldUsedAt, field.TypeTime, value)
+		_node.UsedAt = &value
+	}
+	if value, ok := rcc.mutation.CreatedAt(); ok {
+		_spec.SetField(recoverycode.FieldCreatedAt, field.TypeTime, value)
+		_node.CreatedAt = value
+	}
+	if nodes := rcc.mutation.UserIDs(); len(nodes) > 0 {
+		edge := &sqlgraph.EdgeSpec{
+			Rel:     sqlgraph.M2O,
+			Inverse: true,
+			Table:   recoverycode.UserTable,
+			Columns: []string{recoverycode.UserColumn},
+			Bidi:    false,
+			Target: &sqlgraph.EdgeTarget{
+				IDSpec: sqlgraph.NewFieldSpec(user.FieldID, field.TypeInt),
+			},
+		}
+		for _, k := range nodes {
+			edge.Target.Nodes = append(edge.Target.Nodes, k)
+		}
+		_node.user_recovery_codes = &nodes[0]
+		_spec.Edges = append(_spec.Edges, edge)
+	}
+	return _node, _spec
+}
+
+// RecoveryCodeCreateBulk is the builder for creating many RecoveryCode entities in bulk.
+type RecoveryCodeCreateBulk struct {
+	config
+	err      error
+	builders []*RecoveryCodeCreate
+}
+
+// Save creates the RecoveryCode entities in the database.
+func (rccb *RecoveryCodeCreateBulk) Save(ctx context.Context) ([]*RecoveryCode, error) {
+	if rccb.err != nil {
+		return nil, rccb.err
+	}
+	specs := make([]*sqlgraph.CreateSpec, len(rccb.builders))
+	nodes := make([]*RecoveryCode, len(rccb.builders))
+	mutators := make([]Mutator, len(rccb.builders))
+	for i := range rccb.builders {
+		func(i int, root context.Context) {
+			builder := rccb.builders[i]
+			builder.defaults()
+			var mut Mutator = MutateFunc(func(ctx context.Context, m Mutation) (Value, error) {
+				mutation, ok := m.(*RecoveryCodeMutation)
+				if !ok {
+					return nil, fmt.Errorf("unexpected mutation type %T", m)
+				}
+				if err := builder.check(); err != nil {
+					return nil, err
+				}
+				builder.mutation = mutation
+				var err error
+				nodes[i], specs[i] = builder.createSpec()
+				if i < len(mutators)-1 {
+					_, err = mutators[i+1].Mutate(root, rccb.builders[i+1].mutation)
+				} else {
+					spec := &sqlgraph.BatchCreateSpec{Nodes: specs}
+					// Invoke the actual operation on the latest mutation in the chain.
+					if err = sqlgraph.BatchCreate(ctx, rccb.driver, spec); err != nil {
+						if sqlgraph.IsConstraintError(err) {
+							err = &ConstraintError{msg: err.Error(), wrap: err}
+						}
+					}
+				}
+				if err != nil {
+					return nil, err
+				}
+				mutation.id = &nodes[i].ID
+				if specs[i].ID.Value != nil {
+					id := specs[i].ID.Value.(int64)
+					nodes[i].ID = int(id)
+				}
+				mutation.done = true
+				return nodes[i], nil
+			})
+			for i := len(builder.hooks) - 1; i >= 0; i-- {
+				mut = builder.hooks[i](mut)
+			}
+			mutators[i] = mut
+		}(i, ctx)
+	}
+	if len(mutators) > 0 {
+		if _, err := mutators[0].Mutate(ctx, rccb.builders[0].mutation); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+// SaveX is like Save, but panics if an error occurs.
+func (rccb *RecoveryCodeCreateBulk) SaveX(ctx context.Context) []*RecoveryCode {
+	v, err := rccb.Save(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Exec executes the query.
+func (rccb *RecoveryCodeCreateBulk) Exec(ctx context.Context) error {
+	_, err := rccb.Save(ctx)
+	return err
+}
+
+// ExecX is like Exec, but panics if an error occurs.
+func (rccb *RecoveryCodeCreateBulk) ExecX(ctx context.Context) {
+	if err := rccb.Exec(ctx); err != nil {
+		panic(err)
+	}
+}
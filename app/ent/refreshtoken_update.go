@@ -0,0 +1,399 @@
+// Code generated by ent, DO NOT EDIT.
+
+package ent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/djan/app/ent/predicate"
+	"go/djan/app/ent/refreshtoken"
+	"go/djan/app/ent/user"
+	"time"
+
+	"entgo.io/ent/dialect/sql"
+	"entgo.io/ent/dialect/sql/sqlgraph"
+	"entgo.io/ent/schema/field"
+)
+
+// RefreshTokenUpdate is the builder for updating RefreshToken entities.
+type RefreshTokenUpdate struct {
+	config
+	hooks     []Hook
+	mutation  *RefreshTokenMutation
+	modifiers []func(*sql.UpdateBuilder)
+}
+
+// Where appends a list predicates to the RefreshTokenUpdate builder.
+func (rtu *RefreshTokenUpdate) Where(ps ...predicate.RefreshToken) *RefreshTokenUpdate {
+	rtu.mutation.Where(ps...)
+	return rtu
+}
+
+// SetUsedAt sets the "used_at" field.
+func (rtu *RefreshTokenUpdate) SetUsedAt(t time.Time) *RefreshTokenUpdate {
+	rtu.mutation.SetUsedAt(t)
+	return rtu
+}
+
+// SetNillableUsedAt sets the "used_at" field if the given value is not nil.
+func (rtu *RefreshTokenUpdate) SetNillableUsedAt(t *time.Time) *RefreshTokenUpdate {
+	if t != nil {
+		rtu.SetUsedAt(*t)
+	}
+	return rtu
+}
+
+// ClearUsedAt clears the value of the "used_at" field.
+func (rtu *RefreshTokenUpdate) ClearUsedAt() *RefreshTokenUpdate {
+	rtu.mutation.ClearUsedAt()
+	return rtu
+}
+
+// SetRevoked sets the "revoked" field.
+func (rtu *RefreshTokenUpdate) SetRevoked(b bool) *RefreshTokenUpdate {
+	rtu.mutation.SetRevoked(b)
+	return rtu
+}
+
+// SetNillableRevoked sets the "revoked" field if the given value is not nil.
+func (rtu *RefreshTokenUpdate) SetNillableRevoked(b *bool) *RefreshTokenUpdate {
+	if b != nil {
+		rtu.SetRevoked(*b)
+	}
+	return rtu
+}
+
+// SetUserID sets the "user" edge to the User entity by ID.
+func (rtu *RefreshTokenUpdate) SetUserID(id int) *RefreshTokenUpdate {
+	rtu.mutation.SetUserID(id)
+	return rtu
+}
+
+// SetUser sets the "user" edge to the User entity.
+func (rtu *RefreshTokenUpdate) SetUser(u *User) *RefreshTokenUpdate {
+	return rtu.SetUserID(u.ID)
+}
+
+// Mutation returns the RefreshTokenMutation object of the builder.
+func (rtu *RefreshTokenUpdate) Mutation() *RefreshTokenMutation {
+	return rtu.mutation
+}
+
+// ClearUser clears the "user" edge to the User entity.
+func (rtu *RefreshTokenUpdate) ClearUser() *RefreshTokenUpdate {
+	rtu.mutation.ClearUser()
+	return rtu
+}
+
+// Save executes the query and returns the number of nodes affected by the update operation.
+func (rtu *RefreshTokenUpdate) Save(ctx context.Context) (int, error) {
+	return withHooks(ctx, rtu.sqlSave, rtu.mutation, rtu.hooks)
+}
+
+// SaveX is like Save, but panics if an error occurs.
+func (rtu *RefreshTokenUpdate) SaveX(ctx context.Context) int {
+	affected, err := rtu.Save(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return affected
+}
+
+// Exec executes the query.
+func (rtu *RefreshTokenUpdate) Exec(ctx context.Context) error {
+	_, err := rtu.Save(ctx)
+	return err
+}
+
+// ExecX is like Exec, but panics if an error occurs.
+func (rtu *RefreshTokenUpdate) ExecX(ctx context.Context) {
+	if err := rtu.Exec(ctx); err != nil {
+		panic(err)
+	}
+}
+
+// check runs all checks and user-defined validators on the builder.
+func (rtu *RefreshTokenUpdate) check() error {
+	if rtu.mutation.UserCleared() && len(rtu.mutation.UserIDs()) > 0 {
+		return errors.New(`ent: clearing a required unique edge "RefreshToken.user"`)
+	}
+	return nil
+}
+
+// Modify adds a statement modifier for attaching custom logic to the UPDATE statement.
+func (rtu *RefreshTokenUpdate) Modify(modifiers ...func(u *sql.UpdateBuilder)) *RefreshTokenUpdate {
+	rtu.modifiers = append(rtu.modifiers, modifiers...)
+	return rtu
+}
+
+func (rtu *RefreshTokenUpdate) sqlSave(ctx context.Context) (n int, err error) {
+	if err := rtu.check(); err != nil {
+		return n, err
+	}
+	_spec := sqlgraph.NewUpdateSpec(refreshtoken.Table, refreshtoken.Columns, sqlgraph.NewFieldSpec(refreshtoken.FieldID, field.TypeInt))
+	if ps := rtu.mutation.predicates; len(ps) > 0 {
+		_spec.Predicate = func(selector *sql.Selector) {
+			for i := range ps {
+				ps[i](selector)
+			}
+		}
+	}
+	if rtu.mutation.ScopesCleared() {
+		_spec.ClearField(refreshtoken.FieldScopes, field.TypeJSON)
+	}
+	if value, ok := rtu.mutation.UsedAt(); ok {
+		_spec.SetField(refreshtoken.FieldUsedAt, field.TypeTime, value)
+	}
+	if rtu.mutation.UsedAtCleared() {
+		_spec.ClearField(refreshtoken.FieldUsedAt, field.TypeTime)
+	}
+	if value, ok := rtu.mutation.Revoked(); ok {
+		_spec.SetField(refreshtoken.FieldRevoked, field.TypeBool, value)
+	}
+	if rtu.mutation.UserCleared() {
+		edge := &sqlgraph.EdgeSpec{
+			Rel:     sqlgraph.M2O,
+			Inverse: true,
+			Table:   refreshtoken.UserTable,
+			Columns: []string{refreshtoken.UserColumn},
+			Bidi:    false,
+			Target: &sqlgraph.EdgeTarget{
+				IDSpec: sqlgraph.NewFieldSpec(user.FieldID, field.TypeInt),
+			},
+		}
+		_spec.Edges.Clear = append(_spec.Edges.Clear, edge)
+	}
+	if nodes := rtu.mutation.UserIDs(); len(nodes) > 0 {
+		edge := &sqlgraph.EdgeSpec{
+			Rel:     sqlgraph.M2O,
+			Inverse: true,
+			Table:   refreshtoken.UserTable,
+			Columns: []string{refreshtoken.UserColumn},
+			Bidi:    false,
+			Target: &sqlgraph.EdgeTarget{
+				IDSpec: sqlgraph.NewFieldSpec(user.FieldID, field.TypeInt),
+			},
+		}
+		for _, k := range nodes {
+			edge.Target.Nodes = append(edge.Target.Nodes, k)
+		}
+		_spec.Edges.Add = append(_spec.Edges.Add, edge)
+	}
+	_spec.AddModifiers(rtu.modifiers...)
+	if n, err = sqlgraph.UpdateNodes(ctx, rtu.driver, _spec); err != nil {
+		if _, ok := err.(*sqlgraph.NotFoundError); ok {
+			err = &NotFoundError{refreshtoken.Label}
+		} else if sqlgraph.IsConstraintError(err) {
+			err = &ConstraintError{msg: err.Error(), wrap: err}
+		}
+		return 0, err
+	}
+	rtu.mutation.done = true
+	return n, nil
+}
+
+// RefreshTokenUpdateOne is the builder for updating a single RefreshToken entity.
+type RefreshTokenUpdateOne struct {
+	config
+	fields    []string
+	hooks     []Hook
+	mutation  *RefreshTokenMutation
+	modifiers []func(*sql.UpdateBuilder)
+}
+
+// SetUsedAt sets the "used_at" field.
+func (rtuo *RefreshTokenUpdateOne) SetUsedAt(t time.Time) *RefreshTokenUpdateOne {
+	rtuo.mutation.SetUsedAt(t)
+	return rtuo
+}
+
+// SetNillableUsedAt sets the "used_at" field if the given value is not nil.
+func (rtuo *RefreshTokenUpdateOne) SetNillableUsedAt(t *time.Time) *RefreshTokenUpdateOne {
+	if t != nil {
+		rtuo.SetUsedAt(*t)
+	}
+	return rtuo
+}
+
+// ClearUsedAt clears the value of the "used_at" field.
+func (rtuo *RefreshTokenUpdateOne) ClearUsedAt() *RefreshTokenUpdateOne {
+	rtuo.mutation.ClearUsedAt()
+	return rtuo
+}
+
+// SetRevoked sets the "revoked" field.
+func (rtuo *RefreshTokenUpdateOne) SetRevoked(b bool) *RefreshTokenUpdateOne {
+	rtuo.mutation.SetRevoked(b)
+	return rtuo
+}
+
+// SetNillableRevoked sets the "revoked" field if the given value is not nil.
+func (rtuo *RefreshTokenUpdateOne) SetNillableRevoked(b *bool) *RefreshTokenUpdateOne {
+	if b != nil {
+		rtuo.SetRevoked(*b)
+	}
+	return rtuo
+}
+
+// SetUserID sets the "user" edge to the User entity by ID.
+func (rtuo *RefreshTokenUpdateOne) SetUserID(id int) *RefreshTokenUpdateOne {
+	rtuo.mutation.SetUserID(id)
+	return rtuo
+}
+
+// SetUser sets the "user" edge to the User entity.
+func (rtuo *RefreshTokenUpdateOne) SetUser(u *User) *RefreshTokenUpdateOne {
+	return rtuo.SetUserID(u.ID)
+}
+
+// Mutation returns the RefreshTokenMutation object of the builder.
+func (rtuo *RefreshTokenUpdateOne) Mutation() *RefreshTokenMutation {
+	return rtuo.mutation
+}
+
+// ClearUser clears the "user" edge to the User entity.
+func (rtuo *RefreshTokenUpdateOne) ClearUser() *RefreshTokenUpdateOne {
+	rtuo.mutation.ClearUser()
+	return rtuo
+}
+
+// Where appends a list predicates to the RefreshTokenUpdate builder.
+func (rtuo *RefreshTokenUpdateOne) Where(ps ...predicate.RefreshToken) *RefreshTokenUpdateOne {
+	rtuo.mutation.Where(ps...)
+	return rtuo
+}
+
+// Select allows selecting one or more fields (columns) of the returned entity.
+// The default is selecting all fields defined in the entity schema.
+func (rtuo *RefreshTokenUpdateOne) Select(field string, fields ...string) *RefreshTokenUpdateOne {
+	rtuo.fields = append([]string{field}, fields...)
+	return rtuo
+}
+
+// Save executes the query and returns the updated RefreshToken entity.
+func (rtuo *RefreshTokenUpdateOne) Save(ctx context.Context) (*RefreshToken, error) {
+	return withHooks(ctx, rtuo.sqlSave, rtuo.mutation, rtuo.hooks)
+}
+
+// SaveX is like Save, but panics if an error occurs.
+func (rtuo *RefreshTokenUpdateOne) SaveX(ctx context.Context) *RefreshToken {
+	node, err := rtuo.Save(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return node
+}
+
+// Exec executes the query on the entity.
+func (rtuo *RefreshTokenUpdateOne) Exec(ctx context.Context) error {
+	_, err := rtuo.Save(ctx)
+	return err
+}
+
+// ExecX is like Exec, but panics if an error occurs.
+func (rtuo *RefreshTokenUpdateOne) ExecX(ctx context.Context) {
+	if err := rtuo.Exec(ctx); err != nil {
+		panic(err)
+	}
+}
+
+// check runs all checks and user-defined validators on the builder.
+func (rtuo *RefreshTokenUpdateOne) check() error {
+	if rtuo.mutation.UserCleared() && len(rtuo.mutation.UserIDs()) > 0 {
+		return errors.New(`ent: clearing a required unique edge "RefreshToken.user"`)
+	}
+	return nil
+}
+
+// Modify adds a statement modifier for attaching custom logic to the UPDATE statement.
+func (rtuo *RefreshTokenUpdateOne) Modify(modifiers ...func(u *sql.UpdateBuilder)) *RefreshTokenUpdateOne {
+	rtuo.modifiers = append(rtuo.modifiers, modifiers...)
+	return rtuo
+}
+
+func (rtuo *RefreshTokenUpdateOne) sqlSave(ctx context.Context) (_node *RefreshToken, err error) {
+	if err := rtuo.check(); err != nil {
+		return _node, err
+	}
+	_spec := sqlgraph.NewUpdateSpec(refreshtoken.Table, refreshtoken.Columns, sqlgraph.NewFieldSpec(refreshtoken.FieldID, field.TypeInt))
+	id, ok := rtuo.mutation.ID()
+	if !ok {
+		return nil, &ValidationError{Name: "id", err: errors.New(`ent: missing "RefreshToken.id" for update`)}
+	}
+	_spec.Node.ID.Value = id
+	if fields := rtuo.fields; len(fields) > 0 {
+		_spec.Node.Columns = make([]string, 0, len(fields))
+		_spec.Node.Columns = append(_spec.Node.Columns, refreshtoken.FieldID)
+		for _, f := range fields {
+			if !refreshtoken.ValidColumn(f) {
+				return nil, &ValidationError{Name: f, err: fmt.Errorf("ent: invalid field %q for query", f)}
+			}
+			if f != refreshtoken.FieldID {
+				_spec.Node.Columns = append(_spec.Node.Columns, f)
+			}
+		}
+	}
+	if ps := rtuo.mutation.predicates; len(ps) > 0 {
+		_spec.Predicate = func(selector *sql.Selector) {
+			for i := range ps {
+				ps[i](selector)
+			}
+		}
+	}
+	if rtuo.mutation.ScopesCleared() {
+		_spec.ClearField(refreshtoken.FieldScopes, field.TypeJSON)
+	}
+	if value, ok := rtuo.mutation.UsedAt(); ok {
+		_spec.SetField(refreshtoken.FieldUsedAt, field.TypeTime, value)
+	}
+	if rtuo.mutation.UsedAtCleared() {
+		_spec.ClearField(refreshtoken.FieldUsedAt, field.TypeTime)
+	}
+	if value, ok := rtuo.mutation.Revoked(); ok {
+		_spec.SetField(refreshtoken.FieldRevoked, field.TypeBool, value)
+	}
+	if rtuo.mutation.UserCleared() {
+		edge := &sqlgraph.EdgeSpec{
+			Rel:     sqlgraph.M2O,
+			Inverse: true,
+			Table:   refreshtoken.UserTable,
+			Columns: []string{refreshtoken.UserColumn},
+			Bidi:    false,
+			Target: &sqlgraph.EdgeTarget{
+				IDSpec: sqlgraph.NewFieldSpec(user.FieldID, field.TypeInt),
+			},
+		}
+		_spec.Edges.Clear = append(_spec.Edges.Clear, edge)
+	}
+	if nodes := rtuo.mutation.UserIDs(); len(nodes) > 0 {
+		edge := &sqlgraph.EdgeSpec{
+			Rel:     sqlgraph.M2O,
+			Inverse: true,
+			Table:   refreshtoken.UserTable,
+			Columns: []string{refreshtoken.UserColumn},
+			Bidi:    false,
+			Target: &sqlgraph.EdgeTarget{
+				IDSpec: sqlgraph.NewFieldSpec(user.FieldID, field.TypeInt),
+			},
+		}
+		for _, k := range nodes {
+			edge.Target.Nodes = append(edge.Target.Nodes, k)
+		}
+		_spec.Edges.Add = append(_spec.Edges.Add, edge)
+	}
+	_spec.AddModifiers(rtuo.modifiers...)
+	_node = &RefreshToken{config: rtuo.config}
+	_spec.Assign = _node.assignValues
+	_spec.ScanValues = _node.scanValues
+	if err = sqlgraph.UpdateNode(ctx, rtuo.driver, _spec); err != nil {
+		if _, ok := err.(*sqlgraph.NotFoundError); ok {
+			err = &NotFoundError{refreshtoken.Label}
+		} else if sqlgraph.IsConstraintError(err) {
+			err = &ConstraintError{msg: err.Error(), wrap: err}
+		}
+		return nil, err
+	}
+	rtuo.mutation.done = true
+	return _node, nil
+}
@@ -0,0 +1,233 @@
+// Code generated by ent, DO NOT EDIT.
+
+package ent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/djan/app/ent/revokedtoken"
+	"time"
+
+	"entgo.io/ent/dialect/sql/sqlgraph"
+	"entgo.io/ent/schema/field"
+)
+
+// RevokedTokenCreate is the builder for creating a RevokedToken entity.
+type RevokedTokenCreate struct {
+	config
+	mutation *RevokedTokenMutation
+	hooks    []Hook
+}
+
+// SetJti sets the "jti" field.
+func (rtc *RevokedTokenCreate) SetJti(s string) *RevokedTokenCreate {
+	rtc.mutation.SetJti(s)
+	return rtc
+}
+
+// SetExpiresAt sets the "expires_at" field.
+func (rtc *RevokedTokenCreate) SetExpiresAt(t time.Time) *RevokedTokenCreate {
+	rtc.mutation.SetExpiresAt(t)
+	return rtc
+}
+
+// SetCreatedAt sets the "created_at" field.
+func (rtc *RevokedTokenCreate) SetCreatedAt(t time.Time) *RevokedTokenCreate {
+	rtc.mutation.SetCreatedAt(t)
+	return rtc
+}
+
+// SetNillableCreatedAt sets the "created_at" field if the given value is not nil.
+func (rtc *RevokedTokenCreate) SetNillableCreatedAt(t *time.Time) *RevokedTokenCreate {
+	if t != nil {
+		rtc.SetCreatedAt(*t)
+	}
+	return rtc
+}
+
+// Mutation returns the RevokedTokenMutation object of the builder.
+func (rtc *RevokedTokenCreate) Mutation() *RevokedTokenMutation {
+	return rtc.mutation
+}
+
+// Save creates the RevokedToken in the database.
+func (rtc *RevokedTokenCreate) Save(ctx context.Context) (*RevokedToken, error) {
+	rtc.defaults()
+	return withHooks(ctx, rtc.sqlSave, rtc.mutation, rtc.hooks)
+}
+
+// SaveX calls Save and panics if Save returns an error.
+func (rtc *RevokedTokenCreate) SaveX(ctx context.Context) *RevokedToken {
+	v, err := rtc.Save(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Exec executes the query.
+func (rtc *RevokedTokenCreate) Exec(ctx context.Context) error {
+	_, err := rtc.Save(ctx)
+	return err
+}
+
+// ExecX is like Exec, but panics if an error occurs.
+func (rtc *RevokedTokenCreate) ExecX(ctx context.Context) {
+	if err := rtc.Exec(ctx); err != nil {
+		panic(err)
+	}
+}
+
+// defaults sets the default values of the builder before save.
+func (rtc *RevokedTokenCreate) defaults() {
+	if _, ok := rtc.mutation.CreatedAt(); !ok {
+		v := revokedtoken.DefaultCreatedAt()
+		rtc.mutation.SetCreatedAt(v)
+	}
+}
+
+// check runs all checks and user-defined validators on the builder.
+func (rtc *RevokedTokenCreate) check() error {
+	if _, ok := rtc.mutation.Jti(); !ok {
+		return &ValidationError{Name: "jti", err: errors.New(`ent: missing required field "RevokedToken.jti"`)}
+	}
+	if v, ok := rtc.mutation.Jti(); ok {
+		if err := revokedtoken.JtiValidator(v); err != nil {
+			return &ValidationError{Name: "jti", err: fmt.Errorf(`ent: validator failed for field "RevokedToken.jti": %w`, err)}
+		}
+	}
+	if _, ok := rtc.mutation.ExpiresAt(); !ok {
+		return &ValidationError{Name: "expires_at", err: errors.New(`ent: missing required field "RevokedToken.expires_at"`)}
+	}
+	if _, ok := rtc.mutation.CreatedAt(); !ok {
+		return &ValidationError{Name: "created_at", err: errors.New(`ent: missing required field "RevokedToken.created_at"`)}
+	}
+	return nil
+}
+
+func (rtc *RevokedTokenCreate) sqlSave(ctx context.Context) (*RevokedToken, error) {
+	if err := rtc.check(); err != nil {
+		return nil, err
+	}
+	_node, _spec := rtc.createSpec()
+	if err := sqlgraph.CreateNode(ctx, rtc.driver, _spec); err != nil {
+		if sqlgraph.IsConstraintError(err) {
+			err = &ConstraintError{msg: err.Error(), wrap: err}
+		}
+		return nil, err
+	}
+	id := _spec.ID.Value.(int64)
+	_node.ID = int(id)
+	rtc.mutation.id = &_node.ID
+	rtc.mutation.done = true
+	return _node, nil
+}
+
+func (rtc *RevokedTokenCreate) createSpec() (*RevokedToken, *sqlgraph.CreateSpec) {
+	var (
+		_node = &RevokedToken{config: rtc.config}
+		_spec = sqlgraph.NewCreateSpec(revokedtoken.Table, sqlgraph.NewFieldSpec(revokedtoken.FieldID, field.TypeInt))
+	)
+	if value, ok := rtc.mutation.Jti(); ok {
+		_spec.SetField(revokedtoken.FieldJti, field.TypeString, value)
+		_node.Jti = value
+	}
+	if value, ok := rtc.mutation.ExpiresAt(); ok {
+		_spec.SetField(revokedtoken.FieldExpiresAt, field.TypeTime, value)
+		_node.ExpiresAt = value
+	}
+	if value, ok := rtc.mutation.CreatedAt(); ok {
+		_spec.SetField(revokedtoken.FieldCreatedAt, field.TypeTime, value)
+		_node.CreatedAt = value
+	}
+	return _node, _spec
+}
+
+// RevokedTokenCreateBulk is the builder for creating many RevokedToken entities in bulk.
+type RevokedTokenCreateBulk struct {
+	config
+	err      error
+	builders []*RevokedTokenCreate
+}
+
+// Save creates the RevokedToken entities in the database.
+func (rtcb *RevokedTokenCreateBulk) Save(ctx context.Context) ([]*RevokedToken, error) {
+	if rtcb.err != nil {
+		return nil, rtcb.err
+	}
+	specs := make([]*sqlgraph.CreateSpec, len(rtcb.builders))
+	nodes := make([]*RevokedToken, len(rtcb.builders))
+	mutators := make([]Mutator, len(rtcb.builders))
+	for i := range rtcb.builders {
+		func(i int, root context.Context) {
+			builder := rtcb.builders[i]
+			builder.defaults()
+			var mut Mutator = MutateFunc(func(ctx context.Context, m Mutation) (Value, error) {
+				mutation, ok := m.(*RevokedTokenMutation)
+				if !ok {
+					return nil, fmt.Errorf("unexpected mutation type %T", m)
+				}
+				if err := builder.check(); err != nil {
+					return nil, err
+				}
+				builder.mutation = mutation
+				var err error
+				nodes[i], specs[i] = builder.createSpec()
+				if i < len(mutators)-1 {
+					_, err = mutators[i+1].Mutate(root, rtcb.builders[i+1].mutation)
+				} else {
+					spec := &sqlgraph.BatchCreateSpec{Nodes: specs}
+					// Invoke the actual operation on the latest mutation in the chain.
+					if err = sqlgraph.BatchCreate(ctx, rtcb.driver, spec); err != nil {
+						if sqlgraph.IsConstraintError(err) {
+							err = &ConstraintError{msg: err.Error(), wrap: err}
+						}
+					}
+				}
+				if err != nil {
+					return nil, err
+				}
+				mutation.id = &nodes[i].ID
+				if specs[i].ID.Value != nil {
+					id := specs[i].ID.Value.(int64)
+					nodes[i].ID = int(id)
+				}
+				mutation.done = true
+				return nodes[i], nil
+			})
+			for i := len(builder.hooks) - 1; i >= 0; i-- {
+				mut = builder.hooks[i](mut)
+			}
+			mutators[i] = mut
+		}(i, ctx)
+	}
+	if len(mutators) > 0 {
+		if _, err := mutators[0].Mutate(ctx, rtcb.builders[0].mutation); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+// SaveX is like Save, but panics if an error occurs.
+func (rtcb *RevokedTokenCreateBulk) SaveX(ctx context.Context) []*RevokedToken {
+	v, err := rtcb.Save(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Exec executes the query.
+func (rtcb *RevokedTokenCreateBulk) Exec(ctx context.Context) error {
+	_, err := rtcb.Save(ctx)
+	return err
+}
+
+// ExecX is like Exec, but panics if an error occurs.
+func (rtcb *RevokedTokenCreateBulk) ExecX(ctx context.Context) {
+	if err := rtcb.Exec(ctx); err != nil {
+		panic(err)
+	}
+}
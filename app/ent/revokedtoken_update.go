@@ -0,0 +1,191 @@
+// Code generated by ent, DO NOT EDIT.
+
+package ent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/djan/app/ent/predicate"
+	"go/djan/app/ent/revokedtoken"
+
+	"entgo.io/ent/dialect/sql"
+	"entgo.io/ent/dialect/sql/sqlgraph"
+	"entgo.io/ent/schema/field"
+)
+
+// RevokedTokenUpdate is the builder for updating RevokedToken entities.
+type RevokedTokenUpdate struct {
+	config
+	hooks     []Hook
+	mutation  *RevokedTokenMutation
+	modifiers []func(*sql.UpdateBuilder)
+}
+
+// Where appends a list predicates to the RevokedTokenUpdate builder.
+func (rtu *RevokedTokenUpdate) Where(ps ...predicate.RevokedToken) *RevokedTokenUpdate {
+	rtu.mutation.Where(ps...)
+	return rtu
+}
+
+// Mutation returns the RevokedTokenMutation object of the builder.
+func (rtu *RevokedTokenUpdate) Mutation() *RevokedTokenMutation {
+	return rtu.mutation
+}
+
+// Save executes the query and returns the number of nodes affected by the update operation.
+func (rtu *RevokedTokenUpdate) Save(ctx context.Context) (int, error) {
+	return withHooks(ctx, rtu.sqlSave, rtu.mutation, rtu.hooks)
+}
+
+// SaveX is like Save, but panics if an error occurs.
+func (rtu *RevokedTokenUpdate) SaveX(ctx context.Context) int {
+	affected, err := rtu.Save(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return affected
+}
+
+// Exec executes the query.
+func (rtu *RevokedTokenUpdate) Exec(ctx context.Context) error {
+	_, err := rtu.Save(ctx)
+	return err
+}
+
+// ExecX is like Exec, but panics if an error occurs.
+func (rtu *RevokedTokenUpdate) ExecX(ctx context.Context) {
+	if err := rtu.Exec(ctx); err != nil {
+		panic(err)
+	}
+}
+
+// Modify adds a statement modifier for attaching custom logic to the UPDATE statement.
+func (rtu *RevokedTokenUpdate) Modify(modifiers ...func(u *sql.UpdateBuilder)) *RevokedTokenUpdate {
+	rtu.modifiers = append(rtu.modifiers, modifiers...)
+	return rtu
+}
+
+func (rtu *RevokedTokenUpdate) sqlSave(ctx context.Context) (n int, err error) {
+	_spec := sqlgraph.NewUpdateSpec(revokedtoken.Table, revokedtoken.Columns, sqlgraph.NewFieldSpec(revokedtoken.FieldID, field.TypeInt))
+	if ps := rtu.mutation.predicates; len(ps) > 0 {
+		_spec.Predicate = func(selector *sql.Selector) {
+			for i := range ps {
+				ps[i](selector)
+			}
+		}
+	}
+	_spec.AddModifiers(rtu.modifiers...)
+	if n, err = sqlgraph.UpdateNodes(ctx, rtu.driver, _spec); err != nil {
+		if _, ok := err.(*sqlgraph.NotFoundError); ok {
+			err = &NotFoundError{revokedtoken.Label}
+		} else if sqlgraph.IsConstraintError(err) {
+			err = &ConstraintError{msg: err.Error(), wrap: err}
+		}
+		return 0, err
+	}
+	rtu.mutation.done = true
+	return n, nil
+}
+
+// RevokedTokenUpdateOne is the builder for updating a single RevokedToken entity.
+type RevokedTokenUpdateOne struct {
+	config
+	fields    []string
+	hooks     []Hook
+	mutation  *RevokedTokenMutation
+	modifiers []func(*sql.UpdateBuilder)
+}
+
+// Mutation returns the RevokedTokenMutation object of the builder.
+func (rtuo *RevokedTokenUpdateOne) Mutation() *RevokedTokenMutation {
+	return rtuo.mutation
+}
+
+// Where appends a list predicates to the RevokedTokenUpdate builder.
+func (rtuo *RevokedTokenUpdateOne) Where(ps ...predicate.RevokedToken) *RevokedTokenUpdateOne {
+	rtuo.mutation.Where(ps...)
+	return rtuo
+}
+
+// Select allows selecting one or more fields (columns) of the returned entity.
+// The default is selecting all fields defined in the entity schema.
+func (rtuo *RevokedTokenUpdateOne) Select(field string, fields ...string) *RevokedTokenUpdateOne {
+	rtuo.fields = append([]string{field}, fields...)
+	return rtuo
+}
+
+// Save executes the query and returns the updated RevokedToken entity.
+func (rtuo *RevokedTokenUpdateOne) Save(ctx context.Context) (*RevokedToken, error) {
+	return withHooks(ctx, rtuo.sqlSave, rtuo.mutation, rtuo.hooks)
+}
+
+// SaveX is like Save, but panics if an error occurs.
+func (rtuo *RevokedTokenUpdateOne) SaveX(ctx context.Context) *RevokedToken {
+	node, err := rtuo.Save(ctx)
+	if err != nil {
+		panic(err)
+	}
+	return node
+}
+
+// Exec executes the query on the entity.
+func (rtuo *RevokedTokenUpdateOne) Exec(ctx context.Context) error {
+	_, err := rtuo.Save(ctx)
+	return err
+}
+
+// ExecX is like Exec, but panics if an error occurs.
+func (rtuo *RevokedTokenUpdateOne) ExecX(ctx context.Context) {
+	if err := rtuo.Exec(ctx); err != nil {
+		panic(err)
+	}
+}
+
+// Modify adds a statement modifier for attaching custom logic to the UPDATE statement.
+func (rtuo *RevokedTokenUpdateOne) Modify(modifiers ...func(u *sql.UpdateBuilder)) *RevokedTokenUpdateOne {
+	rtuo.modifiers = append(rtuo.modifiers, modifiers...)
+	return rtuo
+}
+
+func (rtuo *RevokedTokenUpdateOne) sqlSave(ctx context.Context) (_node *RevokedToken, err error) {
+	_spec := sqlgraph.NewUpdateSpec(revokedtoken.Table, revokedtoken.Columns, sqlgraph.NewFieldSpec(revokedtoken.FieldID, field.TypeInt))
+	id, ok := rtuo.mutation.ID()
+	if !ok {
+		return nil, &ValidationError{Name: "id", err: errors.New(`ent: missing "RevokedToken.id" for update`)}
+	}
+	_spec.Node.ID.Value = id
+	if fields := rtuo.fields; len(fields) > 0 {
+		_spec.Node.Columns = make([]string, 0, len(fields))
+		_spec.Node.Columns = append(_spec.Node.Columns, revokedtoken.FieldID)
+		for _, f := range fields {
+			if !revokedtoken.ValidColumn(f) {
+				return nil, &ValidationError{Name: f, err: fmt.Errorf("ent: invalid field %q for query", f)}
+			}
+			if f != revokedtoken.FieldID {
+				_spec.Node.Columns = append(_spec.Node.Columns, f)
+			}
+		}
+	}
+	if ps := rtuo.mutation.predicates; len(ps) > 0 {
+		_spec.Predicate = func(selector *sql.Selector) {
+			for i := range ps {
+				ps[i](selector)
+			}
+		}
+	}
+	_spec.AddModifiers(rtuo.modifiers...)
+	_node = &RevokedToken{config: rtuo.config}
+	_spec.Assign = _node.assignValues
+	_spec.ScanValues = _node.scanValues
+	if err = sqlgraph.UpdateNode(ctx, rtuo.driver, _spec); err != nil {
+		if _, ok := err.(*sqlgraph.NotFoundError); ok {
+			err = &NotFoundError{revokedtoken.Label}
+		} else if sqlgraph.IsConstraintError(err) {
+			err = &ConstraintError{msg: err.Error(), wrap: err}
+		}
+		return nil, err
+	}
+	rtuo.mutation.done = true
+	return _node, nil
+}
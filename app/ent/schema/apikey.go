@@ -0,0 +1,59 @@
+package schema
+
+import (
+	"time"
+
+	"entgo.io/ent"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+)
+
+const APIKeyNameMaxLen = 100
+
+// APIKey holds the schema definition for the APIKey entity.
+type APIKey struct {
+	ent.Schema
+}
+
+// Fields of the APIKey.
+func (APIKey) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("name").
+			NotEmpty().
+			MaxLen(APIKeyNameMaxLen).
+			Comment("Name given by the user to tell their keys apart"),
+		field.String("prefix").
+			NotEmpty().
+			Immutable().
+			Comment("Start of the key, shown to recognize it"),
+		field.String("key_hash").
+			NotEmpty().
+			Unique().
+			Immutable().
+			Sensitive().
+			Comment("SHA-256 hash of the key, shown once to the user"),
+		field.Strings("scopes").
+			Optional().
+			Comment("Scopes granted to the key"),
+		field.Time("expires_at").
+			Optional().
+			Nillable().
+			Comment("Time after which the key is refused, never when null"),
+		field.Time("last_used_at").
+			Optional().
+			Nillable().
+			Comment("Time when the key was last used, to the minute"),
+		field.Time("created_at").
+			Immutable().
+			Default(time.Now).
+			Comment("Time when the key was created"),
+	}
+}
+
+// Edges of the APIKey.
+func (APIKey) Edges() []ent.Edge {
+	return []ent.Edge{
+		// Back referencing O2M from User
+		edge.From("user", User.Type).Ref("api_keys").Unique().Required(),
+	}
+}
@@ -4,8 +4,18 @@ import (
 	"time"
 
 	"entgo.io/ent"
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/entsql"
 	"entgo.io/ent/schema/edge"
 	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+// Constraints of the blog fields, also checked by the validation of requests
+const (
+	BlogTitleMinLen       = 3
+	BlogTitleMaxLen       = 30
+	BlogDescriptionMinLen = 3
 )
 
 // Blog holds the schema definition for the Blog entity.
@@ -17,11 +27,11 @@ type Blog struct {
 func (Blog) Fields() []ent.Field {
 	return []ent.Field{
 		field.String("title").
-			MinLen(3).
-			MaxLen(30).
+			MinLen(BlogTitleMinLen).
+			MaxLen(BlogTitleMaxLen).
 			Comment("Title of the Blog"),
 		field.String("description").
-			MinLen(3).
+			MinLen(BlogDescriptionMinLen).
 			Comment("Description of the Blog"),
 		field.Int("episode").
 			Positive().
@@ -30,6 +40,12 @@ func (Blog) Fields() []ent.Field {
 			Immutable().
 			Default(time.Now).
 			Comment("Time when the Blog was created"),
+		// Generated by PostgreSQL from the title and description, see searchVectorHook
+		field.String("search_vector").
+			SchemaType(map[string]string{dialect.Postgres: "tsvector"}).
+			Optional().
+			StructTag(`json:"-"`).
+			Comment("Full-text search document of the Blog"),
 	}
 }
 
@@ -42,3 +58,12 @@ func (Blog) Edges() []ent.Edge {
 		edge.From("tags", Tag.Type).Ref("blogs"),
 	}
 }
+
+// Indexes of the Blog.
+func (Blog) Indexes() []ent.Index {
+	return []ent.Index{
+		// Full-text search
+		index.Fields("search_vector").
+			Annotations(entsql.IndexTypes(map[string]string{dialect.Postgres: "GIN"})),
+	}
+}
@@ -0,0 +1,57 @@
+package schema
+
+import (
+	"time"
+
+	"entgo.io/ent"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+// Identity holds the schema definition for the Identity entity.
+type Identity struct {
+	ent.Schema
+}
+
+// Fields of the Identity.
+func (Identity) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("issuer").
+			NotEmpty().
+			Immutable().
+			Comment("Issuer URL of the OpenID Connect provider"),
+		field.String("subject").
+			NotEmpty().
+			Immutable().
+			Comment("Subject of the user at the provider, stable unlike the email address"),
+		field.String("email").
+			Optional().
+			Nillable().
+			Comment("Email address given by the provider at the last login"),
+		field.Time("created_at").
+			Immutable().
+			Default(time.Now).
+			Comment("Time when the identity was linked"),
+		field.Time("last_login_at").
+			Optional().
+			Nillable().
+			Comment("Time of the last login with the identity"),
+	}
+}
+
+// Edges of the Identity.
+func (Identity) Edges() []ent.Edge {
+	return []ent.Edge{
+		// Back referencing O2M from User
+		edge.From("user", User.Type).Ref("identities").Unique().Required(),
+	}
+}
+
+// Indexes of the Identity.
+func (Identity) Indexes() []ent.Index {
+	return []ent.Index{
+		// A provider account logs in as a single user
+		index.Fields("issuer", "subject").Unique(),
+	}
+}
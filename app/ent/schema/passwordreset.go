@@ -0,0 +1,53 @@
+package schema
+
+import (
+	"time"
+
+	"entgo.io/ent"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+// PasswordReset holds the schema definition for the PasswordReset entity.
+type PasswordReset struct {
+	ent.Schema
+}
+
+// Fields of the PasswordReset.
+func (PasswordReset) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("token_hash").
+			NotEmpty().
+			Unique().
+			Immutable().
+			Sensitive().
+			Comment("SHA-256 hash of the reset token sent to the user"),
+		field.Time("expires_at").
+			Immutable().
+			Comment("Time after which the token can no longer be used"),
+		field.Time("used_at").
+			Optional().
+			Nillable().
+			Comment("Time when the password was reset with the token, tokens are single use"),
+		field.Time("created_at").
+			Immutable().
+			Default(time.Now).
+			Comment("Time when the reset was requested"),
+	}
+}
+
+// Edges of the PasswordReset.
+func (PasswordReset) Edges() []ent.Edge {
+	return []ent.Edge{
+		// Back referencing O2M from User
+		edge.From("user", User.Type).Ref("password_resets").Unique().Required(),
+	}
+}
+
+// Indexes of the PasswordReset.
+func (PasswordReset) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("expires_at"),
+	}
+}
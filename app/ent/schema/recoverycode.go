@@ -0,0 +1,42 @@
+package schema
+
+import (
+	"time"
+
+	"entgo.io/ent"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+)
+
+// RecoveryCode holds the schema definition for the RecoveryCode entity.
+type RecoveryCode struct {
+	ent.Schema
+}
+
+// Fields of the RecoveryCode.
+func (RecoveryCode) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("code_hash").
+			NotEmpty().
+			Unique().
+			Immutable().
+			Sensitive().
+			Comment("SHA-256 hash of the recovery code, shown once to the user"),
+		field.Time("used_at").
+			Optional().
+			Nillable().
+			Comment("Time when the code was used to log in, codes are single use"),
+		field.Time("created_at").
+			Immutable().
+			Default(time.Now).
+			Comment("Time when the code was generated"),
+	}
+}
+
+// Edges of the RecoveryCode.
+func (RecoveryCode) Edges() []ent.Edge {
+	return []ent.Edge{
+		// Back referencing O2M from User
+		edge.From("user", User.Type).Ref("recovery_codes").Unique().Required(),
+	}
+}
@@ -0,0 +1,65 @@
+package schema
+
+import (
+	"time"
+
+	"entgo.io/ent"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+// RefreshToken holds the schema definition for the RefreshToken entity.
+type RefreshToken struct {
+	ent.Schema
+}
+
+// Fields of the RefreshToken.
+func (RefreshToken) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("token_hash").
+			NotEmpty().
+			Unique().
+			Immutable().
+			Sensitive().
+			Comment("SHA-256 hash of the opaque refresh token"),
+		field.String("family").
+			NotEmpty().
+			Immutable().
+			Comment("Session the token belongs to, shared by all rotations of a login"),
+		field.Strings("scopes").
+			Optional().
+			Immutable().
+			Comment("Scopes the session was narrowed to at login, none grants those of the user"),
+		field.Time("expires_at").
+			Immutable().
+			Comment("Time after which the token can no longer be used"),
+		field.Time("used_at").
+			Optional().
+			Nillable().
+			Comment("Time when the token was rotated, a used token must never be seen again"),
+		field.Bool("revoked").
+			Default(false).
+			Comment("Whether the token was revoked along with its family"),
+		field.Time("created_at").
+			Immutable().
+			Default(time.Now).
+			Comment("Time when the token was issued"),
+	}
+}
+
+// Edges of the RefreshToken.
+func (RefreshToken) Edges() []ent.Edge {
+	return []ent.Edge{
+		// Back referencing O2M from User
+		edge.From("user", User.Type).Ref("refresh_tokens").Unique().Required(),
+	}
+}
+
+// Indexes of the RefreshToken.
+func (RefreshToken) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("family"),
+		index.Fields("expires_at"),
+	}
+}
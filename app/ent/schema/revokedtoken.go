@@ -0,0 +1,40 @@
+package schema
+
+import (
+	"time"
+
+	"entgo.io/ent"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+)
+
+// RevokedToken holds the schema definition for the RevokedToken entity.
+type RevokedToken struct {
+	ent.Schema
+}
+
+// Fields of the RevokedToken.
+func (RevokedToken) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("jti").
+			NotEmpty().
+			Unique().
+			Immutable().
+			Comment("Token ID (jti claim) of the revoked token"),
+		field.Time("expires_at").
+			Immutable().
+			Comment("Expiration of the revoked token, after which the entry can be purged"),
+		field.Time("created_at").
+			Immutable().
+			Default(time.Now).
+			Comment("Time when the token was revoked"),
+	}
+}
+
+// Indexes of the RevokedToken.
+func (RevokedToken) Indexes() []ent.Index {
+	return []ent.Index{
+		// Used by the sweeper to purge expired entries
+		index.Fields("expires_at"),
+	}
+}
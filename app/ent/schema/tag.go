@@ -8,6 +8,18 @@ import (
 	"entgo.io/ent/schema/field"
 )
 
+// Constraints of the tag fields, also checked by the validation of requests
+const (
+	TagNameMinLen = 1
+	TagTypeMinLen = 1
+	TagTypeMaxLen = 10
+)
+
+var (
+	TagNamePattern = regexp.MustCompile("[a-zA-Z_ -]+$")
+	TagTypePattern = regexp.MustCompile("[a-zA-Z]+$")
+)
+
 // Tag holds the schema definition for the Tag entity.
 type Tag struct {
 	ent.Schema
@@ -17,14 +29,14 @@ type Tag struct {
 func (Tag) Fields() []ent.Field {
 	return []ent.Field{
 		field.String("name").
-			MinLen(1).
-			Match(regexp.MustCompile("[a-zA-Z_ -]+$")).
+			MinLen(TagNameMinLen).
+			Match(TagNamePattern).
 			Unique().
 			Comment("Name of the Tag"),
 		field.String("type").
-			MinLen(1).
-			MaxLen(10).
-			Match(regexp.MustCompile("[a-zA-Z]+$")).
+			MinLen(TagTypeMinLen).
+			MaxLen(TagTypeMaxLen).
+			Match(TagTypePattern).
 			Default("Common").
 			Comment("Type of Blog"),
 		field.Enum("category").
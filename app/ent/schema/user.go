@@ -5,10 +5,27 @@ import (
 	"time"
 
 	"entgo.io/ent"
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/entsql"
 	"entgo.io/ent/schema/edge"
 	"entgo.io/ent/schema/field"
 )
 
+// Constraints of the user fields, also checked by the validation of requests
+const (
+	UserNameMinLen  = 3
+	UserEmailMaxLen = 254
+)
+
+var (
+	UserNamePattern  = regexp.MustCompile(`^[a-zA-Z0-9_ !@#$%^&*()-+=\[\]{};:'",.<>?/\\|~]*$`)
+	UserEmailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+)
+
+// caseInsensitive makes PostgreSQL compare the values of a column, and check
+// its unique index, regardless of case
+var caseInsensitive = map[string]string{dialect.Postgres: "citext"}
+
 // User holds the schema definition for the User entity.
 type User struct {
 	ent.Schema
@@ -16,16 +33,30 @@ type User struct {
 
 // Fields of the User.
 func (User) Fields() []ent.Field {
-	re := regexp.MustCompile(`^[a-zA-Z0-9_ !@#$%^&*()-+=\[\]{};:'",.<>?/\\|~]*$`)
 	return []ent.Field{
 		field.String("name").
-			MinLen(3).
-			Match(re).
-			Comment("Name of the author/user"),
+			MinLen(UserNameMinLen).
+			Match(UserNamePattern).
+			SchemaType(caseInsensitive).
+			Unique().
+			Comment("Name of the author/user, unique regardless of case"),
 		field.String("password").
-			Default("QWERTYUIO").
 			Sensitive().
-			Comment("Password of the author/user"),
+			Comment("bcrypt hash of the password of the author/user"),
+		field.String("email").
+			Optional().
+			Nillable().
+			MaxLen(UserEmailMaxLen).
+			Match(UserEmailPattern).
+			SchemaType(caseInsensitive).
+			Unique().
+			StructTag(`json:"-"`).
+			Comment("Email address of the author/user, unique regardless of case"),
+		field.Time("email_verified_at").
+			Optional().
+			Nillable().
+			StructTag(`json:"-"`).
+			Comment("Time when the email address was verified, unverified users can't write"),
 		field.Int("age").
 			Positive().
 			Default(1).
@@ -34,10 +65,46 @@ func (User) Fields() []ent.Field {
 		field.Bool("is_active").
 			Default(true).
 			Comment("Activity of the author/user"),
+		field.Bool("is_superuser").
+			Default(false).
+			Comment("Superusers may modify any user, blog or tag"),
+		field.Enum("role").
+			Values("admin", "editor", "reader").
+			Default("reader").
+			Comment("Role of the author/user, editors may modify any tag"),
 		field.Time("created_at").
 			Immutable().
 			Default(time.Now).
 			Comment("Time when the user was created"),
+		field.Int("failed_logins").
+			NonNegative().
+			Default(0).
+			StructTag(`json:"-"`).
+			Comment("Failed logins since the last successful one"),
+		field.Time("locked_until").
+			Optional().
+			Nillable().
+			StructTag(`json:"-"`).
+			Comment("Time until which logins are refused after repeated failures"),
+		field.Bytes("totp_secret").
+			Optional().
+			Nillable().
+			Sensitive().
+			Comment("TOTP secret encrypted with TOTP_ENCRYPTION_KEY, pending until totp_enabled_at is set"),
+		field.Time("totp_enabled_at").
+			Optional().
+			Nillable().
+			StructTag(`json:"-"`).
+			Comment("Time when two-factor authentication was enabled"),
+		field.Int64("totp_last_step").
+			Default(0).
+			StructTag(`json:"-"`).
+			Comment("Time step of the last TOTP code accepted, so that codes can't be replayed"),
+		field.Time("password_changed_at").
+			Optional().
+			Nillable().
+			StructTag(`json:"-"`).
+			Comment("Time when the password was last changed, access tokens issued before are refused"),
 	}
 }
 
@@ -48,5 +115,20 @@ func (User) Edges() []ent.Edge {
 		edge.To("blogs", Blog.Type),
 		// Loop Back M2M Relation which can help in adding friends
 		edge.To("friends", User.Type),
+		// O2M relation to refresh tokens, removed along with the user
+		edge.To("refresh_tokens", RefreshToken.Type).
+			Annotations(entsql.OnDelete(entsql.Cascade)),
+		// O2M relation to password reset tokens, removed along with the user
+		edge.To("password_resets", PasswordReset.Type).
+			Annotations(entsql.OnDelete(entsql.Cascade)),
+		// O2M relation to the two-factor recovery codes, removed along with the user
+		edge.To("recovery_codes", RecoveryCode.Type).
+			Annotations(entsql.OnDelete(entsql.Cascade)),
+		// O2M relation to the external OpenID Connect identities, removed along with the user
+		edge.To("identities", Identity.Type).
+			Annotations(entsql.OnDelete(entsql.Cascade)),
+		// O2M relation to the API keys, removed along with the user
+		edge.To("api_keys", APIKey.Type).
+			Annotations(entsql.OnDelete(entsql.Cascade)),
 	}
 }
@@ -3,8 +3,8 @@
 package ent
 
 import (
-	"go/djan/app/ent/tag"
 	"fmt"
+	"go/djan/app/ent/tag"
 	"strings"
 
 	"entgo.io/ent"
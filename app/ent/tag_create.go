@@ -5,9 +5,9 @@ package ent
 import (
 	"context"
 	"errors"
+	"fmt"
 	"go/djan/app/ent/blog"
 	"go/djan/app/ent/tag"
-	"fmt"
 
 	"entgo.io/ent/dialect/sql/sqlgraph"
 	"entgo.io/ent/schema/field"
@@ -3,8 +3,8 @@
 package ent
 
 import (
-	"go/djan/app/ent/user"
 	"fmt"
+	"go/djan/app/ent/user"
 	"strings"
 	"time"
 
@@ -17,16 +17,36 @@ type User struct {
 	config `json:"-"`
 	// ID of the ent.
 	ID int `json:"id,omitempty"`
-	// Name of the author/user
+	// Name of the author/user, unique regardless of case
 	Name string `json:"name,omitempty"`
-	// Password of the author/user
+	// bcrypt hash of the password of the author/user
 	Password string `json:"-"`
+	// Email address of the author/user, unique regardless of case
+	Email *string `json:"-"`
+	// Time when the email address was verified, unverified users can't write
+	EmailVerifiedAt *time.Time `json:"-"`
 	// Age of the author/user
 	Age int `json:"age,omitempty"`
 	// Activity of the author/user
 	IsActive bool `json:"is_active,omitempty"`
+	// Superusers may modify any user, blog or tag
+	IsSuperuser bool `json:"is_superuser,omitempty"`
+	// Role of the author/user, editors may modify any tag
+	Role user.Role `json:"role,omitempty"`
 	// Time when the user was created
 	CreatedAt time.Time `json:"created_at,omitempty"`
+	// Failed logins since the last successful one
+	FailedLogins int `json:"-"`
+	// Time until which logins are refused after repeated failures
+	LockedUntil *time.Time `json:"-"`
+	// TOTP secret encrypted with TOTP_ENCRYPTION_KEY, pending until totp_enabled_at is set
+	TotpSecret *[]byte `json:"-"`
+	// Time when two-factor authentication was enabled
+	TotpEnabledAt *time.Time `json:"-"`
+	// Time step of the last TOTP code accepted, so that codes can't be replayed
+	TotpLastStep int64 `json:"-"`
+	// Time when the password was last changed, access tokens issued before are refused
+	PasswordChangedAt *time.Time `json:"-"`
 	// Edges holds the relations/edges for other nodes in the graph.
 	// The values are being populated by the UserQuery when eager-loading is set.
 	Edges        UserEdges `json:"edges"`
@@ -39,9 +59,19 @@ type UserEdges struct {
 	Blogs []*Blog `json:"blogs,omitempty"`
 	// Friends holds the value of the friends edge.
 	Friends []*User `json:"friends,omitempty"`
+	// RefreshTokens holds the value of the refresh_tokens edge.
+	RefreshTokens []*RefreshToken `json:"refresh_tokens,omitempty"`
+	// PasswordResets holds the value of the password_resets edge.
+	PasswordResets []*PasswordReset `json:"password_resets,omitempty"`
+	// RecoveryCodes holds the value of the recovery_codes edge.
+	RecoveryCodes []*RecoveryCode `json:"recovery_codes,omitempty"`
+	// Identities holds the value of the identities edge.
+	Identities []*Identity `json:"identities,omitempty"`
+	// APIKeys holds the value of the api_keys edge.
+	APIKeys []*APIKey `json:"api_keys,omitempty"`
 	// loadedTypes holds the information for reporting if a
 	// type was loaded (or requested) in eager-loading or not.
-	loadedTypes [2]bool
+	loadedTypes [7]bool
 }
 
 // BlogsOrErr returns the Blogs value or an error if the edge
@@ -62,18 +92,65 @@ func (e UserEdges) FriendsOrErr() ([]*User, error) {
 	return nil, &NotLoadedError{edge: "friends"}
 }
 
+// RefreshTokensOrErr returns the RefreshTokens value or an error if the edge
+// was not loaded in eager-loading.
+func (e UserEdges) RefreshTokensOrErr() ([]*RefreshToken, error) {
+	if e.loadedTypes[2] {
+		return e.RefreshTokens, nil
+	}
+	return nil, &NotLoadedError{edge: "refresh_tokens"}
+}
+
+// PasswordResetsOrErr returns the PasswordResets value or an error if the edge
+// was not loaded in eager-loading.
+func (e UserEdges) PasswordResetsOrErr() ([]*PasswordReset, error) {
+	if e.loadedTypes[3] {
+		return e.PasswordResets, nil
+	}
+	return nil, &NotLoadedError{edge: "password_resets"}
+}
+
+// RecoveryCodesOrErr returns the RecoveryCodes value or an error if the edge
+// was not loaded in eager-loading.
+func (e UserEdges) RecoveryCodesOrErr() ([]*RecoveryCode, error) {
+	if e.loadedTypes[4] {
+		return e.RecoveryCodes, nil
+	}
+	return nil, &NotLoadedError{edge: "recovery_codes"}
+}
+
+// IdentitiesOrErr returns the Identities value or an error if the edge
+// was not loaded in eager-loading.
+func (e UserEdges) IdentitiesOrErr() ([]*Identity, error) {
+	if e.loadedTypes[5] {
+		return e.Identities, nil
+	}
+	return nil, &NotLoadedError{edge: "identities"}
+}
+
+// APIKeysOrErr returns the APIKeys value or an error if the edge
+// was not loaded in eager-loading.
+func (e UserEdges) APIKeysOrErr() ([]*APIKey, error) {
+	if e.loadedTypes[6] {
+		return e.APIKeys, nil
+	}
+	return nil, &NotLoadedError{edge: "api_keys"}
+}
+
 // scanValues returns the types for scanning values from sql.Rows.
 func (*User) scanValues(columns []string) ([]any, error) {
 	values := make([]any, len(columns))
 	for i := range columns {
 		switch columns[i] {
-		case user.FieldIsActive:
+		case user.FieldTotpSecret:
+			values[i] = new([]byte)
+		case user.FieldIsActive, user.FieldIsSuperuser:
 			values[i] = new(sql.NullBool)
-		case user.FieldID, user.FieldAge:
+		case user.FieldID, user.FieldAge, user.FieldFailedLogins, user.FieldTotpLastStep:
 			values[i] = new(sql.NullInt64)
-		case user.FieldName, user.FieldPassword:
+		case user.FieldName, user.FieldPassword, user.FieldEmail, user.FieldRole:
 			values[i] = new(sql.NullString)
-		case user.FieldCreatedAt:
+		case user.FieldEmailVerifiedAt, user.FieldCreatedAt, user.FieldLockedUntil, user.FieldTotpEnabledAt, user.FieldPasswordChangedAt:
 			values[i] = new(sql.NullTime)
 		default:
 			values[i] = new(sql.UnknownType)
@@ -108,6 +185,20 @@ func (u *User) assignValues(columns []string, values []any) error {
 			} else if value.Valid {
 				u.Password = value.String
 			}
+		case user.FieldEmail:
+			if value, ok := values[i].(*sql.NullString); !ok {
+				return fmt.Errorf("unexpected type %T for field email", values[i])
+			} else if value.Valid {
+				u.Email = new(string)
+				*u.Email = value.String
+			}
+		case user.FieldEmailVerifiedAt:
+			if value, ok := values[i].(*sql.NullTime); !ok {
+				return fmt.Errorf("unexpected type %T for field email_verified_at", values[i])
+			} else if value.Valid {
+				u.EmailVerifiedAt = new(time.Time)
+				*u.EmailVerifiedAt = value.Time
+			}
 		case user.FieldAge:
 			if value, ok := values[i].(*sql.NullInt64); !ok {
 				return fmt.Errorf("unexpected type %T for field age", values[i])
@@ -120,12 +211,63 @@ func (u *User) assignValues(columns []string, values []any) error {
 			} else if value.Valid {
 				u.IsActive = value.Bool
 			}
+		case user.FieldIsSuperuser:
+			if value, ok := values[i].(*sql.NullBool); !ok {
+				return fmt.Errorf("unexpected type %T for field is_superuser", values[i])
+			} else if value.Valid {
+				u.IsSuperuser = value.Bool
+			}
+		case user.FieldRole:
+			if value, ok := values[i].(*sql.NullString); !ok {
+				return fmt.Errorf("unexpected type %T for field role", values[i])
+			} else if value.Valid {
+				u.Role = user.Role(value.String)
+			}
 		case user.FieldCreatedAt:
 			if value, ok := values[i].(*sql.NullTime); !ok {
 				return fmt.Errorf("unexpected type %T for field created_at", values[i])
 			} else if value.Valid {
 				u.CreatedAt = value.Time
 			}
+		case user.FieldFailedLogins:
+			if value, ok := values[i].(*sql.NullInt64); !ok {
+				return fmt.Errorf("unexpected type %T for field failed_logins", values[i])
+			} else if value.Valid {
+				u.FailedLogins = int(value.Int64)
+			}
+		case user.FieldLockedUntil:
+			if value, ok := values[i].(*sql.NullTime); !ok {
+				return fmt.Errorf("unexpected type %T for field locked_until", values[i])
+			} else if value.Valid {
+				u.LockedUntil = new(time.Time)
+				*u.LockedUntil = value.Time
+			}
+		case user.FieldTotpSecret:
+			if value, ok := values[i].(*[]byte); !ok {
+				return fmt.Errorf("unexpected type %T for field totp_secret", values[i])
+			} else if value != nil {
+				u.TotpSecret = value
+			}
+		case user.FieldTotpEnabledAt:
+			if value, ok := values[i].(*sql.NullTime); !ok {
+				return fmt.Errorf("unexpected type %T for field totp_enabled_at", values[i])
+			} else if value.Valid {
+				u.TotpEnabledAt = new(time.Time)
+				*u.TotpEnabledAt = value.Time
+			}
+		case user.FieldTotpLastStep:
+			if value, ok := values[i].(*sql.NullInt64); !ok {
+				return fmt.Errorf("unexpected type %T for field totp_last_step", values[i])
+			} else if value.Valid {
+				u.TotpLastStep = value.Int64
+			}
+		case user.FieldPasswordChangedAt:
+			if value, ok := values[i].(*sql.NullTime); !ok {
+				return fmt.Errorf("unexpected type %T for field password_changed_at", values[i])
+			} else if value.Valid {
+				u.PasswordChangedAt = new(time.Time)
+				*u.PasswordChangedAt = value.Time
+			}
 		default:
 			u.selectValues.Set(columns[i], values[i])
 		}
@@ -149,6 +291,31 @@ func (u *User) QueryFriends() *UserQuery {
 	return NewUserClient(u.config).QueryFriends(u)
 }
 
+// QueryRefreshTokens queries the "refresh_tokens" edge of the User entity.
+func (u *User) QueryRefreshTokens() *RefreshTokenQuery {
+	return NewUserClient(u.config).QueryRefreshTokens(u)
+}
+
+// QueryPasswordResets queries the "password_resets" edge of the User entity.
+func (u *User) QueryPasswordResets() *PasswordResetQuery {
+	return NewUserClient(u.config).QueryPasswordResets(u)
+}
+
+// QueryRecoveryCodes queries the "recovery_codes" edge of the User entity.
+func (u *User) QueryRecoveryCodes() *RecoveryCodeQuery {
+	return NewUserClient(u.config).QueryRecoveryCodes(u)
+}
+
+// QueryIdentities queries the "identities" edge of the User entity.
+func (u *User) QueryIdentities() *IdentityQuery {
+	return NewUserClient(u.config).QueryIdentities(u)
+}
+
+// QueryAPIKeys queries the "api_keys" edge of the User entity.
+func (u *User) QueryAPIKeys() *APIKeyQuery {
+	return NewUserClient(u.config).QueryAPIKeys(u)
+}
+
 // Update returns a builder for updating this User.
 // Note that you need to call User.Unwrap() before calling this method if this User
 // was returned from a transaction, and the transaction was committed or rolled back.
@@ -177,14 +344,53 @@ func (u *User) String() string {
 	builder.WriteString(", ")
 	builder.WriteString("password=<sensitive>")
 	builder.WriteString(", ")
+	if v := u.Email; v != nil {
+		builder.WriteString("email=")
+		builder.WriteString(*v)
+	}
+	builder.WriteString(", ")
+	if v := u.EmailVerifiedAt; v != nil {
+		builder.WriteString("email_verified_at=")
+		builder.WriteString(v.Format(time.ANSIC))
+	}
+	builder.WriteString(", ")
 	builder.WriteString("age=")
 	builder.WriteString(fmt.Sprintf("%v", u.Age))
 	builder.WriteString(", ")
 	builder.WriteString("is_active=")
 	builder.WriteString(fmt.Sprintf("%v", u.IsActive))
 	builder.WriteString(", ")
+	builder.WriteString("is_superuser=")
+	builder.WriteString(fmt.Sprintf("%v", u.IsSuperuser))
+	builder.WriteString(", ")
+	builder.WriteString("role=")
+	builder.WriteString(fmt.Sprintf("%v", u.Role))
+	builder.WriteString(", ")
 	builder.WriteString("created_at=")
 	builder.WriteString(u.CreatedAt.Format(time.ANSIC))
+	builder.WriteString(", ")
+	builder.WriteString("failed_logins=")
+	builder.WriteString(fmt.Sprintf("%v", u.FailedLogins))
+	builder.WriteString(", ")
+	if v := u.LockedUntil; v != nil {
+		builder.WriteString("locked_until=")
+		builder.WriteString(v.Format(time.ANSIC))
+	}
+	builder.WriteString(", ")
+	builder.WriteString("totp_secret=<sensitive>")
+	builder.WriteString(", ")
+	if v := u.TotpEnabledAt; v != nil {
+		builder.WriteString("totp_enabled_at=")
+		builder.WriteString(v.Format(time.ANSIC))
+	}
+	builder.WriteString(", ")
+	builder.WriteString("totp_last_step=")
+	builder.WriteString(fmt.Sprintf("%v", u.TotpLastStep))
+	builder.WriteString(", ")
+	if v := u.PasswordChangedAt; v != nil {
+		builder.WriteString("password_changed_at=")
+		builder.WriteString(v.Format(time.ANSIC))
+	}
 	builder.WriteByte(')')
 	return builder.String()
 }
@@ -5,9 +5,14 @@ package ent
 import (
 	"context"
 	"errors"
+	"fmt"
+	"go/djan/app/ent/apikey"
 	"go/djan/app/ent/blog"
+	"go/djan/app/ent/identity"
+	"go/djan/app/ent/passwordreset"
+	"go/djan/app/ent/recoverycode"
+	"go/djan/app/ent/refreshtoken"
 	"go/djan/app/ent/user"
-	"fmt"
 	"time"
 
 	"entgo.io/ent/dialect/sql/sqlgraph"
@@ -33,10 +38,30 @@ func (uc *UserCreate) SetPassword(s string) *UserCreate {
 	return uc
 }
 
-// SetNillablePassword sets the "password" field if the given value is not nil.
-func (uc *UserCreate) SetNillablePassword(s *string) *UserCreate {
+// SetEmail sets the "email" field.
+func (uc *UserCreate) SetEmail(s string) *UserCreate {
+	uc.mutation.SetEmail(s)
+	return uc
+}
+
+// SetNillableEmail sets the "email" field if the given value is not nil.
+func (uc *UserCreate) SetNillableEmail(s *string) *UserCreate {
 	if s != nil {
-		uc.SetPassword(*s)
+		uc.SetEmail(*s)
+	}
+	return uc
+}
+
+// SetEmailVerifiedAt sets the "email_verified_at" field.
+func (uc *UserCreate) SetEmailVerifiedAt(t time.Time) *UserCreate {
+	uc.mutation.SetEmailVerifiedAt(t)
+	return uc
+}
+
+// SetNillableEmailVerifiedAt sets the "email_verified_at" field if the given value is not nil.
+func (uc *UserCreate) SetNillableEmailVerifiedAt(t *time.Time) *UserCreate {
+	if t != nil {
+		uc.SetEmailVerifiedAt(*t)
 	}
 	return uc
 }
@@ -69,6 +94,34 @@ func (uc *UserCreate) SetNillableIsActive(b *bool) *UserCreate {
 	return uc
 }
 
+// SetIsSuperuser sets the "is_superuser" field.
+func (uc *UserCreate) SetIsSuperuser(b bool) *UserCreate {
+	uc.mutation.SetIsSuperuser(b)
+	return uc
+}
+
+// SetNillableIsSuperuser sets the "is_superuser" field if the given value is not nil.
+func (uc *UserCreate) SetNillableIsSuperuser(b *bool) *UserCreate {
+	if b != nil {
+		uc.SetIsSuperuser(*b)
+	}
+	return uc
+}
+
+// SetRole sets the "role" field.
+func (uc *UserCreate) SetRole(u user.Role) *UserCreate {
+	uc.mutation.SetRole(u)
+	return uc
+}
+
+// SetNillableRole sets the "role" field if the given value is not nil.
+func (uc *UserCreate) SetNillableRole(u *user.Role) *UserCreate {
+	if u != nil {
+		uc.SetRole(*u)
+	}
+	return uc
+}
+
 // SetCreatedAt sets the "created_at" field.
 func (uc *UserCreate) SetCreatedAt(t time.Time) *UserCreate {
 	uc.mutation.SetCreatedAt(t)
@@ -83,6 +136,82 @@ func (uc *UserCreate) SetNillableCreatedAt(t *time.Time) *UserCreate {
 	return uc
 }
 
+// SetFailedLogins sets the "failed_logins" field.
+func (uc *UserCreate) SetFailedLogins(i int) *UserCreate {
+	uc.mutation.SetFailedLogins(i)
+	return uc
+}
+
+// SetNillableFailedLogins sets the "failed_logins" field if the given value is not nil.
+func (uc *UserCreate) SetNillableFailedLogins(i *int) *UserCreate {
+	if i != nil {
+		uc.SetFailedLogins(*i)
+	}
+	return uc
+}
+
+// SetLockedUntil sets the "locked_until" field.
+func (uc *UserCreate) SetLockedUntil(t time.Time) *UserCreate {
+	uc.mutation.SetLockedUntil(t)
+	return uc
+}
+
+// SetNillableLockedUntil sets the "locked_until" field if the given value is not nil.
+func (uc *UserCreate) SetNillableLockedUntil(t *time.Time) *UserCreate {
+	if t != nil {
+		uc.SetLockedUntil(*t)
+	}
+	return uc
+}
+
+// SetTotpSecret sets the "totp_secret" field.
+func (uc *UserCreate) SetTotpSecret(b []byte) *UserCreate {
+	uc.mutation.SetTotpSecret(b)
+	return uc
+}
+
+// SetTotpEnabledAt sets the "totp_enabled_at" field.
+func (uc *UserCreate) SetTotpEnabledAt(t time.Time) *UserCreate {
+	uc.mutation.SetTotpEnabledAt(t)
+	return uc
+}
+
+// SetNillableTotpEnabledAt sets the "totp_enabled_at" field if the given value is not nil.
+func (uc *UserCreate) SetNillableTotpEnabledAt(t *time.Time) *UserCreate {
+	if t != nil {
+		uc.SetTotpEnabledAt(*t)
+	}
+	return uc
+}
+
+// SetTotpLastStep sets the "totp_last_step" field.
+func (uc *UserCreate) SetTotpLastStep(i int64) *UserCreate {
+	uc.mutation.SetTotpLastStep(i)
+	return uc
+}
+
+// SetNillableTotpLastStep sets the "totp_last_step" field if the given value is not nil.
+func (uc *UserCreate) SetNillableTotpLastStep(i *int64) *UserCreate {
+	if i != nil {
+		uc.SetTotpLastStep(*i)
+	}
+	return uc
+}
+
+// SetPasswordChangedAt sets the "password_changed_at" field.
+func (uc *UserCreate) SetPasswordChangedAt(t time.Time) *UserCreate {
+	uc.mutation.SetPasswordChangedAt(t)
+	return uc
+}
+
+// SetNillablePasswordChangedAt sets the "password_changed_at" field if the given value is not nil.
+func (uc *UserCreate) SetNillablePasswordChangedAt(t *time.Time) *UserCreate {
+	if t != nil {
+		uc.SetPasswordChangedAt(*t)
+	}
+	return uc
+}
+
 // AddBlogIDs adds the "blogs" edge to the Blog entity by IDs.
 func (uc *UserCreate) AddBlogIDs(ids ...int) *UserCreate {
 	uc.mutation.AddBlogIDs(ids...)
@@ -113,6 +242,81 @@ func (uc *UserCreate) AddFriends(u ...*User) *UserCreate {
 	return uc.AddFriendIDs(ids...)
 }
 
+// AddRefreshTokenIDs adds the "refresh_tokens" edge to the RefreshToken entity by IDs.
+func (uc *UserCreate) AddRefreshTokenIDs(ids ...int) *UserCreate {
+	uc.mutation.AddRefreshTokenIDs(ids...)
+	return uc
+}
+
+// AddRefreshTokens adds the "refresh_tokens" edges to the RefreshToken entity.
+func (uc *UserCreate) AddRefreshTokens(r ...*RefreshToken) *UserCreate {
+	ids := make([]int, len(r))
+	for i := range r {
+		ids[i] = r[i].ID
+	}
+	return uc.AddRefreshTokenIDs(ids...)
+}
+
+// AddPasswordResetIDs adds the "password_resets" edge to the PasswordReset entity by IDs.
+func (uc *UserCreate) AddPasswordResetIDs(ids ...int) *UserCreate {
+	uc.mutation.AddPasswordResetIDs(ids...)
+	return uc
+}
+
+// AddPasswordResets adds the "password_resets" edges to the PasswordReset entity.
+func (uc *UserCreate) AddPasswordResets(p ...*PasswordReset) *UserCreate {
+	ids := make([]int, len(p))
+	for i := range p {
+		ids[i] = p[i].ID
+	}
+	return uc.AddPasswordResetIDs(ids...)
+}
+
+// AddRecoveryCodeIDs adds the "recovery_codes" edge to the RecoveryCode entity by IDs.
+func (uc *UserCreate) AddRecoveryCodeIDs(ids ...int) *UserCreate {
+	uc.mutation.AddRecoveryCodeIDs(ids...)
+	return uc
+}
+
+// AddRecoveryCodes adds the "recovery_codes" edges to the RecoveryCode entity.
+func (uc *UserCreate) AddRecoveryCodes(r ...*RecoveryCode) *UserCreate {
+	ids := make([]int, len(r))
+	for i := range r {
+		ids[i] = r[i].ID
+	}
+	return uc.AddRecoveryCodeIDs(ids...)
+}
+
+// AddIdentityIDs adds the "identities" edge to the Identity entity by IDs.
+func (uc *UserCreate) AddIdentityIDs(ids ...int) *UserCreate {
+	uc.mutation.AddIdentityIDs(ids...)
+	return uc
+}
+
+// AddIdentities adds the "identities" edges to the Identity entity.
+func (uc *UserCreate) AddIdentities(i ...*Identity) *UserCreate {
+	ids := make([]int, len(i))
+	for j := range i {
+		ids[j] = i[j].ID
+	}
+	return uc.AddIdentityIDs(ids...)
+}
+
+// AddAPIKeyIDs adds the "api_keys" edge to the APIKey entity by IDs.
+func (uc *UserCreate) AddAPIKeyIDs(ids ...int) *UserCreate {
+	uc.mutation.AddAPIKeyIDs(ids...)
+	return uc
+}
+
+// AddAPIKeys adds the "api_keys" edges to the APIKey entity.
+func (uc *UserCreate) AddAPIKeys(a ...*APIKey) *UserCreate {
+	ids := make([]int, len(a))
+	for i := range a {
+		ids[i] = a[i].ID
+	}
+	return uc.AddAPIKeyIDs(ids...)
+}
+
 // Mutation returns the UserMutation object of the builder.
 func (uc *UserCreate) Mutation() *UserMutation {
 	return uc.mutation
@@ -148,10 +352,6 @@ func (uc *UserCreate) ExecX(ctx context.Context) {
 
 // defaults sets the default values of the builder before save.
 func (uc *UserCreate) defaults() {
-	if _, ok := uc.mutation.Password(); !ok {
-		v := user.DefaultPassword
-		uc.mutation.SetPassword(v)
-	}
 	if _, ok := uc.mutation.Age(); !ok {
 		v := user.DefaultAge
 		uc.mutation.SetAge(v)
@@ -160,10 +360,26 @@ func (uc *UserCreate) defaults() {
 		v := user.DefaultIsActive
 		uc.mutation.SetIsActive(v)
 	}
+	if _, ok := uc.mutation.IsSuperuser(); !ok {
+		v := user.DefaultIsSuperuser
+		uc.mutation.SetIsSuperuser(v)
+	}
+	if _, ok := uc.mutation.Role(); !ok {
+		v := user.DefaultRole
+		uc.mutation.SetRole(v)
+	}
 	if _, ok := uc.mutation.CreatedAt(); !ok {
 		v := user.DefaultCreatedAt()
 		uc.mutation.SetCreatedAt(v)
 	}
+	if _, ok := uc.mutation.FailedLogins(); !ok {
+		v := user.DefaultFailedLogins
+		uc.mutation.SetFailedLogins(v)
+	}
+	if _, ok := uc.mutation.TotpLastStep(); !ok {
+		v := user.DefaultTotpLastStep
+		uc.mutation.SetTotpLastStep(v)
+	}
 }
 
 // check runs all checks and user-defined validators on the builder.
@@ -179,6 +395,11 @@ func (uc *UserCreate) check() error {
 	if _, ok := uc.mutation.Password(); !ok {
 		return &ValidationError{Name: "password", err: errors.New(`ent: missing required field "User.password"`)}
 	}
+	if v, ok := uc.mutation.Email(); ok {
+		if err := user.EmailValidator(v); err != nil {
+			return &ValidationError{Name: "email", err: fmt.Errorf(`ent: validator failed for field "User.email": %w`, err)}
+		}
+	}
 	if v, ok := uc.mutation.Age(); ok {
 		if err := user.AgeValidator(v); err != nil {
 			return &ValidationError{Name: "age", err: fmt.Errorf(`ent: validator failed for field "User.age": %w`, err)}
@@ -187,9 +408,31 @@ func (uc *UserCreate) check() error {
 	if _, ok := uc.mutation.IsActive(); !ok {
 		return &ValidationError{Name: "is_active", err: errors.New(`ent: missing required field "User.is_active"`)}
 	}
+	if _, ok := uc.mutation.IsSuperuser(); !ok {
+		return &ValidationError{Name: "is_superuser", err: errors.New(`ent: missing required field "User.is_superuser"`)}
+	}
+	if _, ok := uc.mutation.Role(); !ok {
+		return &ValidationError{Name: "role", err: errors.New(`ent: missing required field "User.role"`)}
+	}
+	if v, ok := uc.mutation.Role(); ok {
+		if err := user.RoleValidator(v); err != nil {
+			return &ValidationError{Name: "role", err: fmt.Errorf(`ent: validator failed for field "User.role": %w`, err)}
+		}
+	}
 	if _, ok := uc.mutation.CreatedAt(); !ok {
 		return &ValidationError{Name: "created_at", err: errors.New(`ent: missing required field "User.created_at"`)}
 	}
+	if _, ok := uc.mutation.FailedLogins(); !ok {
+		return &ValidationError{Name: "failed_logins", err: errors.New(`ent: missing required field "User.failed_logins"`)}
+	}
+	if v, ok := uc.mutation.FailedLogins(); ok {
+		if err := user.FailedLoginsValidator(v); err != nil {
+			return &ValidationError{Name: "failed_logins", err: fmt.Errorf(`ent: validator failed for field "User.failed_logins": %w`, err)}
+		}
+	}
+	if _, ok := uc.mutation.TotpLastStep(); !ok {
+		return &ValidationError{Name: "totp_last_step", err: errors.New(`ent: missing required field "User.totp_last_step"`)}
+	}
 	return nil
 }
 
@@ -224,6 +467,14 @@ func (uc *UserCreate) createSpec() (*User, *sqlgraph.CreateSpec) {
 		_spec.SetField(user.FieldPassword, field.TypeString, value)
 		_node.Password = value
 	}
+	if value, ok := uc.mutation.Email(); ok {
+		_spec.SetField(user.FieldEmail, field.TypeString, value)
+		_node.Email = &value
+	}
+	if value, ok := uc.mutation.EmailVerifiedAt(); ok {
+		_spec.SetField(user.FieldEmailVerifiedAt, field.TypeTime, value)
+		_node.EmailVerifiedAt = &value
+	}
 	if value, ok := uc.mutation.Age(); ok {
 		_spec.SetField(user.FieldAge, field.TypeInt, value)
 		_node.Age = value
@@ -232,10 +483,42 @@ func (uc *UserCreate) createSpec() (*User, *sqlgraph.CreateSpec) {
 		_spec.SetField(user.FieldIsActive, field.TypeBool, value)
 		_node.IsActive = value
 	}
+	if value, ok := uc.mutation.IsSuperuser(); ok {
+		_spec.SetField(user.FieldIsSuperuser, field.TypeBool, value)
+		_node.IsSuperuser = value
+	}
+	if value, ok := uc.mutation.Role(); ok {
+		_spec.SetField(user.FieldRole, field.TypeEnum, value)
+		_node.Role = value
+	}
 	if value, ok := uc.mutation.CreatedAt(); ok {
 		_spec.SetField(user.FieldCreatedAt, field.TypeTime, value)
 		_node.CreatedAt = value
 	}
+	if value, ok := uc.mutation.FailedLogins(); ok {
+		_spec.SetField(user.FieldFailedLogins, field.TypeInt, value)
+		_node.FailedLogins = value
+	}
+	if value, ok := uc.mutation.LockedUntil(); ok {
+		_spec.SetField(user.FieldLockedUntil, field.TypeTime, value)
+		_node.LockedUntil = &value
+	}
+	if value, ok := uc.mutation.TotpSecret(); ok {
+		_spec.SetField(user.FieldTotpSecret, field.TypeBytes, value)
+		_node.TotpSecret = &value
+	}
+	if value, ok := uc.mutation.TotpEnabledAt(); ok {
+		_spec.SetField(user.FieldTotpEnabledAt, field.TypeTime, value)
+		_node.TotpEnabledAt = &value
+	}
+	if value, ok := uc.mutation.TotpLastStep(); ok {
+		_spec.SetField(user.FieldTotpLastStep, field.TypeInt64, value)
+		_node.TotpLastStep = value
+	}
+	if value, ok := uc.mutation.PasswordChangedAt(); ok {
+		_spec.SetField(user.FieldPasswordChangedAt, field.TypeTime, value)
+		_node.PasswordChangedAt = &value
+	}
 	if nodes := uc.mutation.BlogsIDs(); len(nodes) > 0 {
 		edge := &sqlgraph.EdgeSpec{
 			Rel:     sqlgraph.O2M,
@@ -268,6 +551,86 @@ func (uc *UserCreate) createSpec() (*User, *sqlgraph.CreateSpec) {
 		}
 		_spec.Edges = append(_spec.Edges, edge)
 	}
+	if nodes := uc.mutation.RefreshTokensIDs(); len(nodes) > 0 {
+		edge := &sqlgraph.EdgeSpec{
+			Rel:     sqlgraph.O2M,
+			Inverse: false,
+			Table:   user.RefreshTokensTable,
+			Columns: []string{user.RefreshTokensColumn},
+			Bidi:    false,
+			Target: &sqlgraph.EdgeTarget{
+				IDSpec: sqlgraph.NewFieldSpec(refreshtoken.FieldID, field.TypeInt),
+			},
+		}
+		for _, k := range nodes {
+			edge.Target.Nodes = append(edge.Target.Nodes, k)
+		}
+		_spec.Edges = append(_spec.Edges, edge)
+	}
+	if nodes := uc.mutation.PasswordResetsIDs(); len(nodes) > 0 {
+		edge := &sqlgraph.EdgeSpec{
+			Rel:     sqlgraph.O2M,
+			Inverse: false,
+			Table:   user.PasswordResetsTable,
+			Columns: []string{user.PasswordResetsColumn},
+			Bidi:    false,
+			Target: &sqlgraph.EdgeTarget{
+				IDSpec: sqlgraph.NewFieldSpec(passwordreset.FieldID, field.TypeInt),
+			},
+		}
+		for _, k := range nodes {
+			edge.Target.Nodes = append(edge.Target.Nodes, k)
+		}
+		_spec.Edges = append(_spec.Edges, edge)
+	}
+	if nodes := uc.mutation.RecoveryCodesIDs(); len(nodes) > 0 {
+		edge := &sqlgraph.EdgeSpec{
+			Rel:     sqlgraph.O2M,
+			Inverse: false,
+			Table:   user.RecoveryCodesTable,
+			Columns: []string{user.RecoveryCodesColumn},
+			Bidi:    false,
+			Target: &sqlgraph.EdgeTarget{
+				IDSpec: sqlgraph.NewFieldSpec(recoverycode.FieldID, field.TypeInt),
+			},
+		}
+		for _, k := range nodes {
+			edge.Target.Nodes = append(edge.Target.Nodes, k)
+		}
+		_spec.Edges = append(_spec.Edges, edge)
+	}
+	if nodes := uc.mutation.IdentitiesIDs(); len(nodes) > 0 {
+		edge := &sqlgraph.EdgeSpec{
+			Rel:     sqlgraph.O2M,
+			Inverse: false,
+			Table:   user.IdentitiesTable,
+			Columns: []string{user.IdentitiesColumn},
+			Bidi:    false,
+			Target: &sqlgraph.EdgeTarget{
+				IDSpec: sqlgraph.NewFieldSpec(identity.FieldID, field.TypeInt),
+			},
+		}
+		for _, k := range nodes {
+			edge.Target.Nodes = append(edge.Target.Nodes, k)
+		}
+		_spec.Edges = append(_spec.Edges, edge)
+	}
+	if nodes := uc.mutation.APIKeysIDs(); len(nodes) > 0 {
+		edge := &sqlgraph.EdgeSpec{
+			Rel:     sqlgraph.O2M,
+			Inverse: false,
+			Table:   user.APIKeysTable,
+			Columns: []string{user.APIKeysColumn},
+			Bidi:    false,
+			Target: &sqlgraph.EdgeTarget{
+				IDSpec: sqlgraph.NewFieldSpec(apikey.FieldID, field.TypeInt),
+			},
+		}
+		for _, k := range nodes {
+			edge.Target.Nodes = append(edge.Target.Nodes, k)
+		}
+		_spec.Edges = append(_spec.Edges, edge)
+	}
 	return _node, _spec
 }
 
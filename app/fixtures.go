@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/djan/app/ent"
+	"go/djan/app/ent/blog"
+	"go/djan/app/ent/tag"
+	"go/djan/app/ent/user"
+	"io"
+	"os"
+	"time"
+)
+
+// Fixture is the format of dumpdata and loaddata. Objects reference each
+// other by the ids they had in the dumped database.
+type Fixture struct {
+	Users []UserFixture `json:"users"`
+	Tags  []TagFixture  `json:"tags"`
+	Blogs []BlogFixture `json:"blogs"`
+}
+
+type UserFixture struct {
+	ID       int     `json:"id"`
+	Name     string  `json:"name"`
+	Password string  `json:"password"`
+	Email    *string `json:"email,omitempty"`
+	// EmailVerifiedAt is unset for users who didn't verify their address
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	Age             int        `json:"age,omitempty"`
+	IsActive        bool       `json:"is_active"`
+	IsSuperuser     bool       `json:"is_superuser"`
+	Role            user.Role  `json:"role"`
+	CreatedAt       time.Time  `json:"created_at"`
+	Friends         []int      `json:"friends,omitempty"`
+}
+
+type TagFixture struct {
+	ID       int          `json:"id"`
+	Name     string       `json:"name"`
+	Type     string       `json:"type"`
+	Category tag.Category `json:"category,omitempty"`
+}
+
+type BlogFixture struct {
+	ID          int       `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Episode     int       `json:"episode,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	User        int       `json:"user,omitempty"`
+	Tags        []int     `json:"tags,omitempty"`
+}
+
+// dumpData reads every user, tag and blog along with their relations
+func dumpData(ctx context.Context, client *ent.Client) (*Fixture, error) {
+	f := &Fixture{Users: []UserFixture{}, Tags: []TagFixture{}, Blogs: []BlogFixture{}}
+
+	users, err := client.User.Query().WithFriends().Order(ent.Asc(user.FieldID)).All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		uf := UserFixture{
+			ID:              u.ID,
+			Name:            u.Name,
+			Password:        u.Password,
+			Email:           u.Email,
+			EmailVerifiedAt: u.EmailVerifiedAt,
+			Age:             u.Age,
+			IsActive:        u.IsActive,
+			IsSuperuser:     u.IsSuperuser,
+			Role:            u.Role,
+			CreatedAt:       u.CreatedAt,
+		}
+		for _, friend := range u.Edges.Friends {
+			uf.Friends = append(uf.Friends, friend.ID)
+		}
+		f.Users = append(f.Users, uf)
+	}
+
+	tags, err := client.Tag.Query().Order(ent.Asc(tag.FieldID)).All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tags {
+		f.Tags = append(f.Tags, TagFixture{ID: t.ID, Name: t.Name, Type: t.Type, Category: t.Category})
+	}
+
+	blogs, err := client.Blog.Query().WithUser().WithTags().Order(ent.Asc(blog.FieldID)).All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range blogs {
+		bf := BlogFixture{
+			ID:          b.ID,
+			Title:       b.Title,
+			Description: b.Description,
+			Episode:     b.Episode,
+			CreatedAt:   b.CreatedAt,
+		}
+		if b.Edges.User != nil {
+			bf.User = b.Edges.User.ID
+		}
+		for _, t := range b.Edges.Tags {
+			bf.Tags = append(bf.Tags, t.ID)
+		}
+		f.Blogs = append(f.Blogs, bf)
+	}
+	return f, nil
+}
+
+// loadData creates the objects of a fixture in a single transaction. The
+// database assigns new ids, the relations are mapped to them.
+func loadData(ctx context.Context, client *ent.Client, f *Fixture) (err error) {
+	tx, err := client.Tx(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	users := map[int]int{}
+	for _, uf := range f.Users {
+		create := tx.User.
+			Create().
+			SetName(uf.Name).
+			SetPassword(uf.Password).
+			SetNillableEmail(uf.Email).
+			SetNillableEmailVerifiedAt(uf.EmailVerifiedAt).
+			SetIsActive(uf.IsActive).
+			SetIsSuperuser(uf.IsSuperuser).
+			SetRole(uf.Role).
+			SetCreatedAt(uf.CreatedAt)
+		if uf.Age != 0 {
+			create.SetAge(uf.Age)
+		}
+		u, err := create.Save(ctx)
+		if err != nil {
+			return fmt.Errorf("user %d: %w", uf.ID, err)
+		}
+		users[uf.ID] = u.ID
+	}
+	for _, uf := range f.Users {
+		var friends []int
+		for _, id := range uf.Friends {
+			friend, ok := users[id]
+			if !ok {
+				return fmt.Errorf("user %d: unknown friend %d", uf.ID, id)
+			}
+			// Friendships are symmetric and dumped from both sides
+			if id > uf.ID {
+				friends = append(friends, friend)
+			}
+		}
+		if len(friends) > 0 {
+			if err := tx.User.UpdateOneID(users[uf.ID]).AddFriendIDs(friends...).Exec(ctx); err != nil {
+				return fmt.Errorf("user %d: %w", uf.ID, err)
+			}
+		}
+	}
+
+	tags := map[int]int{}
+	for _, tf := range f.Tags {
+		create := tx.Tag.Create().SetName(tf.Name)
+		if tf.Type != "" {
+			create.SetType(tf.Type)
+		}
+		if tf.Category != "" {
+			create.SetCategory(tf.Category)
+		}
+		t, err := create.Save(ctx)
+		if err != nil {
+			return fmt.Errorf("tag %d: %w", tf.ID, err)
+		}
+		tags[tf.ID] = t.ID
+	}
+
+	for _, bf := range f.Blogs {
+		create := tx.Blog.
+			Create().
+			SetTitle(bf.Title).
+			SetDescription(bf.Description).
+			SetCreatedAt(bf.CreatedAt)
+		if bf.Episode != 0 {
+			create.SetEpisode(bf.Episode)
+		}
+		if bf.User != 0 {
+			id, ok := users[bf.User]
+			if !ok {
+				return fmt.Errorf("blog %d: unknown user %d", bf.ID, bf.User)
+			}
+			create.SetUserID(id)
+		}
+		for _, tf := range bf.Tags {
+			id, ok := tags[tf]
+			if !ok {
+				return fmt.Errorf("blog %d: unknown tag %d", bf.ID, tf)
+			}
+			create.AddTagIDs(id)
+		}
+		if _, err := create.Save(ctx); err != nil {
+			return fmt.Errorf("blog %d: %w", bf.ID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func dumpDataCommand(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("dumpdata", flag.ContinueOnError)
+	output := fset.String("o", "", "file to write to instead of stdout")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := dumpData(ctx, GetClient())
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(f)
+}
+
+func loadDataCommand(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("loaddata requires a fixture file, - for stdin")
+	}
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	f := &Fixture{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(f); err != nil {
+		return fmt.Errorf("invalid fixture: %w", err)
+	}
+	if err := loadData(ctx, GetClient(), f); err != nil {
+		return err
+	}
+	fmt.Printf("Loaded %d users, %d tags and %d blogs\n", len(f.Users), len(f.Tags), len(f.Blogs))
+	return nil
+}
@@ -1,36 +1,97 @@
 package main
 
 import (
-	"context"
-	"errors"
+	"fmt"
+	"go/djan/app/apierror"
 	"go/djan/app/ent"
 	"go/djan/app/ent/blog"
+	"go/djan/app/ent/predicate"
+	"go/djan/app/ent/schema"
 	"go/djan/app/ent/tag"
 	"go/djan/app/ent/user"
-	"log"
 	"net/http"
-	"strconv"
+	"strings"
 	"time"
 
-	"github.com/o1egl/paseto"
+	"entgo.io/ent/dialect/sql"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// LoginRequest may narrow the scopes granted to the session with a space
+// separated scope
 type LoginRequest struct {
 	Name     string `json:"name"`
 	Password string `json:"password"`
+	Scope    string `json:"scope"`
+}
+
+func (req *LoginRequest) validate(v *validator) {
+	v.required("name", req.Name != "")
+	v.required("password", req.Password != "")
+	validateScope(v, "scope", req.Scope)
+}
+
+// SignupRequest holds a name which must be valid for a new user, a password
+// which must pass the password policy, and the email address to verify
+type SignupRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	Email    string `json:"email"`
+}
+
+func (req *SignupRequest) validate(v *validator) {
+	if v.required("name", req.Name != "") {
+		validateUserName(v, req.Name)
+	}
+	if v.required("password", req.Password != "") {
+		v.password("password", req.Password)
+	}
+	if v.required("email", req.Email != "") {
+		validateEmail(v, req.Email)
+	}
 }
 
 type UserDetails struct {
 	Name     *string `json:"name"`
+	Email    *string `json:"email"`
 	Age      *int    `json:"age"`
 	IsActive *bool   `json:"is_active"`
 }
 
+func (req *UserDetails) validate(v *validator) {
+	if req.Name != nil {
+		validateUserName(v, *req.Name)
+	}
+	if req.Email != nil {
+		validateEmail(v, *req.Email)
+	}
+	if req.Age != nil {
+		v.positive("age", *req.Age)
+	}
+}
+
+func validateUserName(v *validator, name string) {
+	v.length("name", name, schema.UserNameMinLen, 0)
+	v.match("name", name, schema.UserNamePattern)
+}
+
+func validateEmail(v *validator, email string) {
+	v.length("email", email, 1, schema.UserEmailMaxLen)
+	if !schema.UserEmailPattern.MatchString(email) {
+		v.add("email", apierror.FieldInvalidFormat, "must be an email address")
+	}
+}
+
 type FriendRequest struct {
 	FriendID int `json:"friend_id"`
 }
 
+func (req *FriendRequest) validate(v *validator) {
+	if v.required("friend_id", req.FriendID != 0) {
+		v.positive("friend_id", req.FriendID)
+	}
+}
+
 type BlogDetails struct {
 	Title       *string  `json:"title"`
 	Description *string  `json:"description"`
@@ -39,49 +100,234 @@ type BlogDetails struct {
 	TagNames    []string `json:"tags"`
 }
 
+// validate checks the fields which are given, for partial updates
+func (req *BlogDetails) validate(v *validator) {
+	if req.Title != nil {
+		v.length("title", *req.Title, schema.BlogTitleMinLen, schema.BlogTitleMaxLen)
+	}
+	if req.Description != nil {
+		v.length("description", *req.Description, schema.BlogDescriptionMinLen, 0)
+	}
+	if req.Episode != nil {
+		v.positive("episode", *req.Episode)
+	}
+	if req.UserId != nil {
+		v.positive("user_id", *req.UserId)
+	}
+	for i, name := range req.TagNames {
+		field := fmt.Sprintf("tags[%d]", i)
+		v.length(field, name, schema.TagNameMinLen, 0)
+		v.match(field, name, schema.TagNamePattern)
+	}
+}
+
+// BlogCreateRequest is a BlogDetails whose title and description are required
+type BlogCreateRequest struct {
+	BlogDetails
+}
+
+func (req *BlogCreateRequest) validate(v *validator) {
+	v.required("title", req.Title != nil)
+	v.required("description", req.Description != nil)
+	req.BlogDetails.validate(v)
+}
+
 type TagUpdateRequest struct {
 	Name     *string `json:"name"`
 	Type     *string `json:"type"`
 	Category *string `json:"category"`
 }
 
+func (req *TagUpdateRequest) validate(v *validator) {
+	if req.Name != nil {
+		v.length("name", *req.Name, schema.TagNameMinLen, 0)
+		v.match("name", *req.Name, schema.TagNamePattern)
+	}
+	if req.Type != nil {
+		v.length("type", *req.Type, schema.TagTypeMinLen, schema.TagTypeMaxLen)
+		v.match("type", *req.Type, schema.TagTypePattern)
+	}
+	if req.Category != nil {
+		v.oneOf("category", tag.CategoryValidator(tag.Category(*req.Category)),
+			tag.CategoryHot.String(), tag.CategoryTrending.String(), tag.CategoryNewest.String(), tag.CategoryControversial.String())
+	}
+}
+
+var userOrderFields = map[string]orderField[*ent.User]{
+	"id":         orderByInt(user.FieldID, func(u *ent.User) int { return u.ID }),
+	"name":       orderByString(user.FieldName, func(u *ent.User) string { return u.Name }),
+	"created_at": orderByTime(user.FieldCreatedAt, func(u *ent.User) time.Time { return u.CreatedAt }),
+}
+
+var blogOrderFields = map[string]orderField[*ent.Blog]{
+	"id":         orderByInt(blog.FieldID, func(b *ent.Blog) int { return b.ID }),
+	"title":      orderByString(blog.FieldTitle, func(b *ent.Blog) string { return b.Title }),
+	"created_at": orderByTime(blog.FieldCreatedAt, func(b *ent.Blog) time.Time { return b.CreatedAt }),
+}
+
+var tagOrderFields = map[string]orderField[*ent.Tag]{
+	"id":   orderByInt(tag.FieldID, func(t *ent.Tag) int { return t.ID }),
+	"name": orderByString(tag.FieldName, func(t *ent.Tag) string { return t.Name }),
+}
+
+// userFilters maps the query parameters of getUsers onto user predicates
+func userFilters(r *http.Request) ([]predicate.User, error) {
+	var filters []predicate.User
+	query := r.URL.Query()
+
+	if name := query.Get("name"); name != "" {
+		filters = append(filters, user.NameContainsFold(name))
+	}
+	if role := query.Get("role"); role != "" {
+		if err := user.RoleValidator(user.Role(role)); err != nil {
+			return nil, apierror.InvalidParameter("role", "must be one of admin, editor or reader")
+		}
+		filters = append(filters, user.RoleEQ(user.Role(role)))
+	}
+	isActive, err := queryBool(r, "is_active")
+	if err != nil {
+		return nil, err
+	}
+	if isActive != nil {
+		filters = append(filters, user.IsActive(*isActive))
+	}
+	createdAfter, err := queryTime(r, "created_after")
+	if err != nil {
+		return nil, err
+	}
+	if createdAfter != nil {
+		filters = append(filters, user.CreatedAtGT(*createdAfter))
+	}
+	createdBefore, err := queryTime(r, "created_before")
+	if err != nil {
+		return nil, err
+	}
+	if createdBefore != nil {
+		filters = append(filters, user.CreatedAtLT(*createdBefore))
+	}
+	return filters, nil
+}
+
+// blogFilters maps the query parameters of getBlogs onto blog predicates
+func blogFilters(r *http.Request) ([]predicate.Blog, error) {
+	var filters []predicate.Blog
+	query := r.URL.Query()
+
+	if tagCategory := query.Get("category"); tagCategory != "" {
+		filters = append(filters, blog.HasTagsWith(tag.CategoryEQ(tag.Category(tagCategory))))
+	}
+	if tagName := query.Get("tag"); tagName != "" {
+		filters = append(filters, blog.HasTagsWith(tag.Name(tagName)))
+	}
+	userID, err := queryInt(r, "user_id")
+	if err != nil {
+		return nil, err
+	}
+	if userID != nil {
+		filters = append(filters, blog.HasUserWith(user.ID(*userID)))
+	}
+	episode, err := queryInt(r, "episode")
+	if err != nil {
+		return nil, err
+	}
+	if episode != nil {
+		filters = append(filters, blog.Episode(*episode))
+	}
+	createdAfter, err := queryTime(r, "created_after")
+	if err != nil {
+		return nil, err
+	}
+	if createdAfter != nil {
+		filters = append(filters, blog.CreatedAtGT(*createdAfter))
+	}
+	createdBefore, err := queryTime(r, "created_before")
+	if err != nil {
+		return nil, err
+	}
+	if createdBefore != nil {
+		filters = append(filters, blog.CreatedAtLT(*createdBefore))
+	}
+	return filters, nil
+}
+
+// tagFilters maps the query parameters of getTags onto tag predicates
+func tagFilters(r *http.Request) ([]predicate.Tag, error) {
+	var filters []predicate.Tag
+	query := r.URL.Query()
+
+	if name := query.Get("name"); name != "" {
+		filters = append(filters, tag.NameContainsFold(name))
+	}
+	if tagType := query.Get("type"); tagType != "" {
+		filters = append(filters, tag.Type(tagType))
+	}
+	if category := query.Get("category"); category != "" {
+		if err := tag.CategoryValidator(tag.Category(category)); err != nil {
+			return nil, apierror.InvalidParameter("category", "must be one of Hot, Trending, Newest or Controversial")
+		}
+		filters = append(filters, tag.CategoryEQ(tag.Category(category)))
+	}
+	return filters, nil
+}
+
 func getUsers(w http.ResponseWriter, r *http.Request) {
 	client := GetClient()
-	users, err := client.User.Query().WithBlogs().WithFriends().All(context.Background())
+
+	params, err := parsePageParams(r, userOrderFields, "id")
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"error": err.Error()})
+		writeError(w, r, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, users)
+	filters, err := userFilters(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	query := client.User.Query().Where(filters...).WithBlogs().WithFriends()
+	page, err := paginate(params, func(u *ent.User) int { return u.ID }, func(where, order func(*sql.Selector), limit int) ([]*ent.User, error) {
+		return query.Where(where).Order(order).Limit(limit).All(r.Context())
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
 }
 
 func getBlogs(w http.ResponseWriter, r *http.Request) {
 	client := GetClient()
 
 	// Extract query parameters
-	tagCategory := r.URL.Query().Get("category")
+	params, err := parsePageParams(r, blogOrderFields, "-created_at")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	filters, err := blogFilters(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
 	// Build the query
-	query := client.Blog.Query().WithUser().WithTags()
+	query := client.Blog.Query().Where(filters...).WithUser().WithTags()
 
-	if tagCategory != "" {
-		query = query.Where(blog.HasTagsWith(tag.CategoryEQ(tag.Category(tagCategory))))
-	}
-
-	blogs, err := query.All(context.Background())
+	page, err := paginate(params, func(b *ent.Blog) int { return b.ID }, func(where, order func(*sql.Selector), limit int) ([]*ent.Blog, error) {
+		return query.Where(where).Order(order).Limit(limit).All(r.Context())
+	})
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"error": err.Error()})
+		writeError(w, r, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, blogs)
+	writeJSON(w, http.StatusOK, page)
 }
 
 func getUserById(w http.ResponseWriter, r *http.Request) {
 	client := GetClient()
-	id_string := r.PathValue("id")
-	id, err := strconv.Atoi(id_string)
+	id, err := pathID(r)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"message": "Invalid Id received"})
+		writeError(w, r, err)
 		return
 	}
 	user, err := client.User.
@@ -92,40 +338,21 @@ func getUserById(w http.ResponseWriter, r *http.Request) {
 		Only(r.Context())
 
 	if err != nil {
-		message := ""
-		var notFoundError *ent.NotFoundError
-		if errors.As(err, &notFoundError) {
-			// Handle the case where the entity was not found
-			message = ("User with Id: " + id_string + " was not found")
-		} else {
-			// Handle other errors
-			message = err.Error()
-		}
-		writeJSON(w, http.StatusBadRequest, M{"error": message})
+		writeError(w, r, err)
 		return
 	}
 	writeJSON(w, http.StatusOK, user)
 }
 func getBlogById(w http.ResponseWriter, r *http.Request) {
 	client := GetClient()
-	id_string := r.PathValue("id")
-	id, err := strconv.Atoi(id_string)
+	id, err := pathID(r)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"message": "Invalid Id received"})
+		writeError(w, r, err)
 		return
 	}
-	users, err := client.Blog.Get(context.Background(), id)
+	users, err := client.Blog.Get(r.Context(), id)
 	if err != nil {
-		message := ""
-		var notFoundError *ent.NotFoundError
-		if errors.As(err, &notFoundError) {
-			// Handle the case where the entity was not found
-			message = ("Blog with Id: " + id_string + " was not found")
-		} else {
-			// Handle other errors
-			message = err.Error()
-		}
-		writeJSON(w, http.StatusBadRequest, M{"error": message})
+		writeError(w, r, err)
 		return
 	}
 	writeJSON(w, http.StatusOK, users)
@@ -133,73 +360,119 @@ func getBlogById(w http.ResponseWriter, r *http.Request) {
 
 func loginHandler(w http.ResponseWriter, r *http.Request) {
 	client := GetClient()
-	login_json := LoginRequest{}
+	login_json, err := decodeJSON[LoginRequest](w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
 
-	if err := readJSON(w, r, &login_json); err != nil {
-		writeJSON(w, http.StatusUnauthorized, M{"error": err.Error()})
+	if !allowRequest(w, r, authLimiters.user, strings.ToLower(login_json.Name)) {
 		return
 	}
 
+	// Unknown users, locked accounts and wrong passwords get the same answer,
+	// after as long a bcrypt comparison, so that names can't be enumerated
+	invalidCredentials := apierror.Unauthorized(apierror.CodeInvalidCredentials, "Invalid credentials")
 	user, err := client.User.
 		Query().
 		Where(user.Name(login_json.Name)).
-		Only(context.Background())
+		Only(r.Context())
+	if ent.IsNotFound(err) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(login_json.Password))
+		writeError(w, r, invalidCredentials)
+		return
+	}
 	if err != nil {
-		writeJSON(w, http.StatusUnauthorized, M{"error": "User not found"})
+		writeError(w, r, err)
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(login_json.Password)); err != nil {
-		writeJSON(w, http.StatusUnauthorized, M{"error": "Invalid credentials"})
+	passwordErr := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(login_json.Password))
+	// Logins of a locked account are refused without counting, even with the
+	// right password
+	if lockedFor(user) > 0 {
+		writeError(w, r, invalidCredentials)
 		return
 	}
+	if passwordErr != nil {
+		if err := recordFailedLogin(r.Context(), client, user); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeError(w, r, invalidCredentials)
+		return
+	}
+
+	completeLogin(w, r, client, user, parseScope(login_json.Scope))
+}
 
-	// Create PASETO token
-	token := paseto.NewV2()
-	jsonToken := paseto.JSONToken{
-		Subject:    strconv.Itoa(user.ID),
-		IssuedAt:   time.Now(),
-		Expiration: time.Now().Add(24 * time.Hour),
+// completeLogin answers a login whose first factor was checked with a token
+// pair, or with a short-lived token to submit the second factor with. Failed
+// logins are reset once every factor is checked.
+func completeLogin(w http.ResponseWriter, r *http.Request, client *ent.Client, user *ent.User, scopes []string) {
+	if hasTOTP(user) {
+		mfaToken, err := issueMFAToken(user, scopes)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, M{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+			"expires_in":   int(config.Auth.MFATokenLifetime.Seconds()),
+		})
+		return
 	}
-	encryptedToken, err := token.Encrypt(pasetoKey, jsonToken, nil)
+
+	if err := resetFailedLogins(r.Context(), client, user); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Create PASETO access token and start a new refresh token family
+	tokens, err := issueTokenPair(r.Context(), client, user, "", scopes)
 	if err != nil {
-		writeJSON(w, http.StatusUnauthorized, M{"error": "Internal Server Error"})
+		writeError(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 
-	writeJSON(w, http.StatusOK, M{"token": encryptedToken})
+	writeJSON(w, http.StatusOK, tokens)
 }
 
 func signUpHandler(w http.ResponseWriter, r *http.Request) {
 	client := GetClient()
 
-	signup_json := LoginRequest{}
-
-	if err := readJSON(w, r, &signup_json); err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"error": err.Error()})
+	signup_json, err := decodeJSON[SignupRequest](w, r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	// Check if user already exists
-	_, err := client.User.
+	// Check if user already exists, names and emails are compared regardless
+	// of case by the database
+	existing, err := client.User.
 		Query().
-		Where(user.Name(signup_json.Name)).
-		Only(context.Background())
+		Where(user.Or(user.Name(signup_json.Name), user.Email(signup_json.Email))).
+		First(r.Context())
 
 	if err == nil {
-		writeJSON(w, http.StatusConflict, M{"error": "User already exists"})
+		if strings.EqualFold(existing.Name, signup_json.Name) {
+			writeError(w, r, apierror.Conflict("User already exists"))
+		} else {
+			writeError(w, r, apierror.Conflict("Email address already in use"))
+		}
 		return
 	} else if !ent.IsNotFound(err) {
-		writeJSON(w, http.StatusInternalServerError, M{"error": "Internal server error"})
+		writeError(w, r, err)
 		return
 	}
 
 	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(signup_json.Password), bcrypt.DefaultCost)
+	hashedPassword, err := hashPassword(signup_json.Password)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, M{"error": "Internal server error"})
+		writeError(w, r, err)
 		return
 	}
 
@@ -207,19 +480,55 @@ func signUpHandler(w http.ResponseWriter, r *http.Request) {
 	newUser, err := client.User.
 		Create().
 		SetName(signup_json.Name).
-		SetPassword(string(hashedPassword)).
-		Save(context.Background())
+		SetPassword(hashedPassword).
+		SetEmail(signup_json.Email).
+		Save(r.Context())
 
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, M{"error": "Failed to create user"})
+		writeError(w, r, err)
 		return
 	}
 
+	// The account is usable for reading until the address is verified
+	if err := sendEmailVerification(r.Context(), newUser); err != nil {
+		loggerFromContext(r.Context()).Error("failed sending email verification", "error", err)
+	}
+
 	writeJSON(w, http.StatusCreated, M{"message": "User created successfully", "user_id": newUser.ID})
 }
 
 func signOutHandler(w http.ResponseWriter, r *http.Request) {
-	// Invalidate the token by removing it from the client's cookie
+	tokenString, err := bearerToken(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	jsonToken, err := parseAccessToken(tokenString)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	client := GetClient()
+
+	// Revoke the token server side, expired tokens are already unusable
+	if jsonToken.Expiration.After(time.Now()) {
+		if err := revokeToken(r.Context(), client, jsonToken); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	// End the session so that its refresh tokens can't mint new access tokens
+	if session := jsonToken.Get(sessionClaim); session != "" {
+		if err := revokeTokenFamily(r.Context(), client, session); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	// Also remove the token from the client's cookie
 	http.SetCookie(w, &http.Cookie{
 		Name:     "Authorization",
 		Value:    "",
@@ -235,30 +544,29 @@ func signOutHandler(w http.ResponseWriter, r *http.Request) {
 func createBlog(w http.ResponseWriter, r *http.Request) {
 	client := GetClient()
 
-	blog_json := BlogDetails{}
-
-	if err := readJSON(w, r, &blog_json); err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"error": err.Error()})
+	blog_json, err := decodeJSON[BlogCreateRequest](w, r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	log.Printf("blog_json: %v\n", blog_json)
+	loggerFromContext(r.Context()).Debug("creating blog", "body", blog_json)
 
 	user := GetUserFromContext(r.Context())
 
 	// Create or find tags
 	var tags []*ent.Tag
 	for _, tagName := range blog_json.TagNames {
-		tag, err := client.Tag.Query().Where(tag.Name(tagName)).Only(context.Background())
+		tag, err := client.Tag.Query().Where(tag.Name(tagName)).Only(r.Context())
 		if err != nil {
 			if ent.IsNotFound(err) {
 				// Create new tag if not found
-				tag, err = client.Tag.Create().SetName(tagName).Save(context.Background())
+				tag, err = client.Tag.Create().SetName(tagName).Save(r.Context())
 				if err != nil {
-					writeJSON(w, http.StatusInternalServerError, M{"error": "Failed to create tag"})
+					writeError(w, r, err)
 					return
 				}
 			} else {
-				writeJSON(w, http.StatusInternalServerError, M{"error": "Failed to query tag"})
+				writeError(w, r, err)
 				return
 			}
 		}
@@ -271,15 +579,15 @@ func createBlog(w http.ResponseWriter, r *http.Request) {
 	}
 	blog, err := save.Save(r.Context())
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"error": err.Error()})
+		writeError(w, r, err)
 		return
 	}
 
 	if len(tags) > 0 {
 		// Associate tags with the blog
-		_, err = client.Blog.UpdateOneID(blog.ID).AddTags(tags...).Save(context.Background())
+		_, err = client.Blog.UpdateOneID(blog.ID).AddTags(tags...).Save(r.Context())
 		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, M{"error": "Failed to add tags"})
+			writeError(w, r, err)
 			return
 		}
 	}
@@ -289,32 +597,28 @@ func createBlog(w http.ResponseWriter, r *http.Request) {
 func updateUserById(w http.ResponseWriter, r *http.Request) {
 	client := GetClient()
 
-	user_json := UserDetails{}
-
-	if err := readJSON(w, r, &user_json); err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"error": err.Error()})
+	user_json, err := decodeJSON[UserDetails](w, r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	id_string := r.PathValue("id")
-	id, err := strconv.Atoi(id_string)
+	id, err := pathID(r)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"error": "Invalid Id received"})
+		writeError(w, r, err)
+		return
+	}
+	loggerFromContext(r.Context()).Debug("updating user", "id", id, "body", user_json)
+
+	if !canModifyUser(GetUserFromContext(r.Context()), id) {
+		forbidden(w, r)
 		return
 	}
-	log.Printf("user_json: %v\n", user_json)
 
 	// Fetch existing user to ensure it exists
 	user, err := client.User.Get(r.Context(), id)
 	if err != nil {
-		var notFoundError *ent.NotFoundError
-		if errors.As(err, &notFoundError) {
-			// Handle the case where the entity was not found
-			writeJSON(w, http.StatusNotFound, M{"error": "User not found"})
-		} else {
-			// Handle other errors
-			writeJSON(w, http.StatusInternalServerError, M{"error": err.Error()})
-		}
+		writeError(w, r, err)
 		return
 	}
 
@@ -323,53 +627,67 @@ func updateUserById(w http.ResponseWriter, r *http.Request) {
 	if user_json.Name != nil {
 		update = update.SetName(*user_json.Name)
 	}
+	// A new address must be verified again
+	emailChanged := user_json.Email != nil && (user.Email == nil || !strings.EqualFold(*user.Email, *user_json.Email))
+	// The address receives the password resets, so it is a credential
+	if emailChanged && GetAPIKeyFromContext(r.Context()) != nil {
+		writeError(w, r, errAPIKeyNotAllowed)
+		return
+	}
+	if emailChanged {
+		update = update.SetEmail(*user_json.Email).ClearEmailVerifiedAt()
+	}
 	if user_json.Age != nil {
 		update = update.SetAge(*user_json.Age)
 	}
 	if user_json.IsActive != nil {
 		update = update.SetIsActive(*user_json.IsActive)
 	}
-	log.Printf("user_json: %v\n", user_json)
-
 	updatedUser, err := update.Save(r.Context())
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"error": err.Error()})
+		writeError(w, r, err)
 		return
 	}
-	log.Printf("updatedUser: %v\n", updatedUser)
-
+	if emailChanged {
+		if err := sendEmailVerification(r.Context(), updatedUser); err != nil {
+			loggerFromContext(r.Context()).Error("failed sending email verification", "error", err)
+		}
+	}
 	writeJSON(w, http.StatusOK, updatedUser)
 }
 
 func updateBlogById(w http.ResponseWriter, r *http.Request) {
 	client := GetClient()
 
-	blog_json := BlogDetails{}
-
-	if err := readJSON(w, r, &blog_json); err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"error": err.Error()})
+	blog_json, err := decodeJSON[BlogDetails](w, r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	id_string := r.PathValue("id")
-	id, err := strconv.Atoi(id_string)
+	id, err := pathID(r)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"error": "Invalid Id received"})
+		writeError(w, r, err)
 		return
 	}
-	log.Printf("blog_json: %v\n", blog_json)
+	loggerFromContext(r.Context()).Debug("updating blog", "id", id, "body", blog_json)
 
 	// Fetch existing user to ensure it exists
 	blog, err := client.Blog.Get(r.Context(), id)
 	if err != nil {
-		var notFoundError *ent.NotFoundError
-		if errors.As(err, &notFoundError) {
-			// Handle the case where the entity was not found
-			writeJSON(w, http.StatusNotFound, M{"error": "Blog not found"})
-		} else {
-			// Handle other errors
-			writeJSON(w, http.StatusInternalServerError, M{"error": err.Error()})
-		}
+		writeError(w, r, err)
+		return
+	}
+
+	actor := GetUserFromContext(r.Context())
+	allowed, err := canModifyBlog(r.Context(), client, actor, blog.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// Only admins may hand a blog over to another author
+	if !allowed || (blog_json.UserId != nil && !isAdmin(actor)) {
+		forbidden(w, r)
 		return
 	}
 
@@ -387,36 +705,31 @@ func updateBlogById(w http.ResponseWriter, r *http.Request) {
 	if blog_json.UserId != nil {
 		update = update.SetUserID(*blog_json.UserId)
 	}
-	log.Printf("blog_json: %v\n", blog_json)
-
 	updated_blog, err := update.Save(r.Context())
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"error": err.Error()})
+		writeError(w, r, err)
 		return
 	}
-	log.Printf("updated_blog: %v\n", updated_blog)
-
 	writeJSON(w, http.StatusOK, updated_blog)
 }
 
 func deleteUserById(w http.ResponseWriter, r *http.Request) {
 	client := GetClient()
 
-	id_string := r.PathValue("id")
-	id, err := strconv.Atoi(id_string)
+	id, err := pathID(r)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"error": "Invalid ID received"})
+		writeError(w, r, err)
+		return
+	}
+
+	if !canModifyUser(GetUserFromContext(r.Context()), id) {
+		forbidden(w, r)
 		return
 	}
 
 	err = client.User.DeleteOneID(id).Exec(r.Context())
 	if err != nil {
-		var notFoundError *ent.NotFoundError
-		if errors.As(err, &notFoundError) {
-			writeJSON(w, http.StatusNotFound, M{"error": "User with ID " + id_string + " not found"})
-		} else {
-			writeJSON(w, http.StatusInternalServerError, M{"error": err.Error()})
-		}
+		writeError(w, r, err)
 		return
 	}
 
@@ -426,21 +739,35 @@ func deleteUserById(w http.ResponseWriter, r *http.Request) {
 func deleteByBlogId(w http.ResponseWriter, r *http.Request) {
 	client := GetClient()
 
-	id_string := r.PathValue("id")
-	id, err := strconv.Atoi(id_string)
+	id, err := pathID(r)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"error": "Invalid ID received"})
+		writeError(w, r, err)
+		return
+	}
+
+	exists, err := client.Blog.Query().Where(blog.ID(id)).Exist(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !exists {
+		writeError(w, r, apierror.NotFound("Blog not found"))
+		return
+	}
+
+	allowed, err := canModifyBlog(r.Context(), client, GetUserFromContext(r.Context()), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !allowed {
+		forbidden(w, r)
 		return
 	}
 
 	err = client.Blog.DeleteOneID(id).Exec(r.Context())
 	if err != nil {
-		var notFoundError *ent.NotFoundError
-		if errors.As(err, &notFoundError) {
-			writeJSON(w, http.StatusNotFound, M{"error": "Blog with ID " + id_string + " not found"})
-		} else {
-			writeJSON(w, http.StatusInternalServerError, M{"error": err.Error()})
-		}
+		writeError(w, r, err)
 		return
 	}
 
@@ -450,25 +777,28 @@ func deleteByBlogId(w http.ResponseWriter, r *http.Request) {
 func addFriendById(w http.ResponseWriter, r *http.Request) {
 	client := GetClient()
 
-	var request FriendRequest
-	if err := readJSON(w, r, &request); err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"message": err.Error()})
+	request, err := decodeJSON[FriendRequest](w, r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 	// Fetch users to validate existence
 	user_entity := GetUserFromContext(r.Context())
-	log.Printf("user_entity: %v\n", user_entity)
 
 	friend, err := client.User.Get(r.Context(), request.FriendID)
+	if ent.IsNotFound(err) {
+		writeError(w, r, apierror.NotFound("Friend not found"))
+		return
+	}
 	if err != nil {
-		writeJSON(w, http.StatusNotFound, M{"error": "Friend not found"})
+		writeError(w, r, err)
 		return
 	}
 
 	// Add friend to the user's friends list
 	_, err = client.User.UpdateOne(user_entity).AddFriends(friend).Save(r.Context())
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, M{"error": err.Error()})
+		writeError(w, r, err)
 		return
 	}
 
@@ -478,9 +808,9 @@ func addFriendById(w http.ResponseWriter, r *http.Request) {
 func deleteFriendById(w http.ResponseWriter, r *http.Request) {
 	client := GetClient()
 
-	var request FriendRequest
-	if err := readJSON(w, r, &request); err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"message": err.Error()})
+	request, err := decodeJSON[FriendRequest](w, r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -488,69 +818,115 @@ func deleteFriendById(w http.ResponseWriter, r *http.Request) {
 	user := GetUserFromContext(r.Context())
 
 	friend, err := client.User.Get(r.Context(), request.FriendID)
+	if ent.IsNotFound(err) {
+		writeError(w, r, apierror.NotFound("Friend not found"))
+		return
+	}
 	if err != nil {
-		writeJSON(w, http.StatusNotFound, M{"error": "Friend not found"})
+		writeError(w, r, err)
 		return
 	}
 
 	// Remove friend from the user's friends list
 	_, err = client.User.UpdateOne(user).RemoveFriends(friend).Save(r.Context())
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, M{"error": err.Error()})
+		writeError(w, r, err)
 		return
 	}
 
 	writeJSON(w, http.StatusOK, M{"message": "Friend removed successfully"})
 }
 
+type TagWithCount struct {
+	*ent.Tag
+	Count int `json:"blogs_count"`
+}
+
 func getTags(w http.ResponseWriter, r *http.Request) {
 	client := GetClient()
-	var tags []struct {
-		TagUpdateRequest
+
+	params, err := parsePageParams(r, tagOrderFields, "name")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	filters, err := tagFilters(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	query := client.Tag.Query().Where(filters...)
+	page, err := paginate(params, func(t *ent.Tag) int { return t.ID }, func(where, order func(*sql.Selector), limit int) ([]*ent.Tag, error) {
+		return query.Where(where).Order(order).Limit(limit).All(r.Context())
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Count the blogs of the tags on the page
+	tags := page.Data.([]*ent.Tag)
+	ids := make([]int, len(tags))
+	for i, t := range tags {
+		ids[i] = t.ID
+	}
+	var counts []struct {
 		ID    int `json:"id"`
 		Count int `json:"blogs_count"`
 	}
-	err := client.Blog.Query().QueryTags().
-		GroupBy(blog.FieldID, tag.Columns...).
-		Aggregate(
-			ent.As(ent.Count(), "blogs_count"),
-		).
-		Scan(r.Context(), &tags)
+	err = client.Tag.Query().
+		Where(tag.IDIn(ids...)).
+		GroupBy(tag.FieldID).
+		Aggregate(func(s *sql.Selector) string {
+			t := sql.Table(tag.BlogsTable)
+			s.LeftJoin(t).On(s.C(tag.FieldID), t.C(tag.BlogsPrimaryKey[0]))
+			return sql.As(sql.Count(t.C(tag.BlogsPrimaryKey[1])), "blogs_count")
+		}).
+		Scan(r.Context(), &counts)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"error": err.Error()})
+		writeError(w, r, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, tags)
+	blogCounts := make(map[int]int, len(counts))
+	for _, c := range counts {
+		blogCounts[c.ID] = c.Count
+	}
+
+	data := make([]TagWithCount, len(tags))
+	for i, t := range tags {
+		data[i] = TagWithCount{Tag: t, Count: blogCounts[t.ID]}
+	}
+	page.Data = data
+
+	writeJSON(w, http.StatusOK, page)
 }
 
 func updateTagById(w http.ResponseWriter, r *http.Request) {
 	client := GetClient()
 
-	tag_json := TagUpdateRequest{}
-	if err := readJSON(w, r, &tag_json); err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"error": err.Error()})
+	if !canModifyTag(GetUserFromContext(r.Context())) {
+		forbidden(w, r)
+		return
+	}
+
+	tag_json, err := decodeJSON[TagUpdateRequest](w, r)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	// Extract tag ID from URL path
-	id_string := r.PathValue("id")
-	id, err := strconv.Atoi(id_string)
+	id, err := pathID(r)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"error": "Invalid ID received"})
+		writeError(w, r, err)
 		return
 	}
 
 	// Fetch the existing tag
-	tag_entity, err := client.Tag.Get(context.Background(), id)
+	tag_entity, err := client.Tag.Get(r.Context(), id)
 	if err != nil {
-		var notFoundError *ent.NotFoundError
-		if errors.As(err, &notFoundError) {
-			// Handle the case where the entity was not found
-			writeJSON(w, http.StatusNotFound, M{"error": "Tag not found"})
-		} else {
-			// Handle other errors
-			writeJSON(w, http.StatusInternalServerError, M{"error": err.Error()})
-		}
+		writeError(w, r, err)
 		return
 	}
 
@@ -566,9 +942,9 @@ func updateTagById(w http.ResponseWriter, r *http.Request) {
 		update = update.SetCategory(tag.Category(*tag_json.Category))
 	}
 
-	updatedTag, err := update.Save(context.Background())
+	updatedTag, err := update.Save(r.Context())
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, M{"error": err.Error()})
+		writeError(w, r, err)
 		return
 	}
 
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"go/djan/app/apierror"
+	"go/djan/app/ent/user"
+)
+
+func TestLoginDoesNotRevealNames(t *testing.T) {
+	client := newTestClient(t)
+	password, err := hashPassword("s3cret-pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.User.Create().SetName("alice").SetPassword(password).ExecX(context.Background())
+	client.User.Create().SetName("bob").SetPassword(password).SetLockedUntil(time.Now().Add(time.Hour)).ExecX(context.Background())
+
+	h := http.HandlerFunc(loginHandler)
+	for _, login := range []M{
+		{"name": "nobody", "password": "s3cret-pass"},
+		{"name": "alice", "password": "wrong-pass"},
+		// The right password of a locked account
+		{"name": "bob", "password": "s3cret-pass"},
+	} {
+		code, out := doJSON(t, h, "POST", "/auth/login/", "", login)
+		if code != http.StatusUnauthorized || out["code"] != apierror.CodeInvalidCredentials {
+			t.Errorf("login of %s: got %d %v", login["name"], code, out)
+		}
+	}
+
+	if code, out := doJSON(t, h, "POST", "/auth/login/", "", M{"name": "alice", "password": "s3cret-pass"}); code != http.StatusOK || out["token"] == nil {
+		t.Errorf("got %d %v", code, out)
+	}
+	// Logins of a locked account are not counted as failures
+	if bob := client.User.Query().Where(user.Name("bob")).OnlyX(context.Background()); bob.FailedLogins != 0 {
+		t.Errorf("bob has %d failed logins", bob.FailedLogins)
+	}
+}
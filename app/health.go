@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// readyTimeout bounds the checks of /readyz, which probes call frequently
+const readyTimeout = 2 * time.Second
+
+// readiness is the state checked by /readyz, set up by serve
+var readiness struct {
+	// shuttingDown is set as soon as the server is asked to stop, so that
+	// the orchestrator stops routing traffic to it while requests drain
+	shuttingDown atomic.Bool
+	migrator     *migrator
+	// expectedVersion is the last migration of the migrations directory
+	expectedVersion string
+}
+
+// setupReadiness makes /readyz require the migrations of m to be applied
+func setupReadiness(m *migrator) error {
+	version, err := m.latest()
+	if err != nil {
+		return err
+	}
+	readiness.migrator = m
+	readiness.expectedVersion = version
+	return nil
+}
+
+// healthzHandler reports that the process is up
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, M{"status": "ok"})
+}
+
+// readyzHandler reports whether the server can serve traffic: it is not
+// shutting down, the database answers and has the migrations of this version
+// applied. Newer migrations are accepted, as applied by the next version
+// during a rolling deployment.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if readiness.shuttingDown.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, M{"status": "shutting down"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	checks := M{"database": "ok", "migrations": "ok"}
+	ready := true
+	if err := GetDB().PingContext(ctx); err != nil {
+		slog.Warn("readiness check failed", "check", "database", "error", err)
+		checks["database"] = "unavailable"
+		checks["migrations"] = "unknown"
+		ready = false
+	} else if m := readiness.migrator; m != nil {
+		version, err := m.version(ctx)
+		if err != nil {
+			slog.Warn("readiness check failed", "check", "migrations", "error", err)
+			checks["migrations"] = "unavailable"
+			ready = false
+		} else if version < readiness.expectedVersion {
+			if version == "" {
+				version = "none"
+			}
+			checks["migrations"] = fmt.Sprintf("pending, %s expected but %s applied", readiness.expectedVersion, version)
+			ready = false
+		}
+	}
+
+	if !ready {
+		writeJSON(w, http.StatusServiceUnavailable, M{"status": "not ready", "checks": checks})
+		return
+	}
+	writeJSON(w, http.StatusOK, M{"status": "ready", "checks": checks})
+}
@@ -4,45 +4,102 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"go/djan/app/apierror"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
-
-	"github.com/spf13/viper"
+	"time"
 )
 
+// maxBodyBytes limits the size of the JSON bodies read from requests
+const maxBodyBytes = 1 << 20
+
 type malformedRequest struct {
 	status int
 	msg    string
+	// field is the JSON field at fault, if any, and reason why
+	field  string
+	reason string
 }
 
 func (mr *malformedRequest) Error() string {
 	return mr.msg
 }
 
+// APIError maps the request onto a malformed_json or unsupported_media_type
+// problem, with the field at fault
+func (mr *malformedRequest) APIError() *apierror.Error {
+	code := apierror.CodeMalformedJSON
+	if mr.status == http.StatusUnsupportedMediaType {
+		code = apierror.CodeUnsupportedMediaType
+	}
+	e := apierror.New(mr.status, code, mr.msg)
+	if mr.field != "" {
+		e = e.WithFields(apierror.FieldError{Field: mr.field, Code: apierror.FieldInvalid, Message: mr.reason})
+	}
+	return e
+}
+
 type M map[string]interface{}
 
 func writeJSON(w http.ResponseWriter, code int, data interface{}) {
+	writeBody(w, code, "application/json", data)
+}
+
+func writeBody(w http.ResponseWriter, code int, contentType string, data interface{}) {
 	jsonBytes, err := json.Marshal(data)
 
 	if err != nil {
-		log.Println(err)
-		errorResponse(w, http.StatusInternalServerError, "internal error")
-		return
+		slog.Error("failed encoding response", "error", err)
+		code, contentType = http.StatusInternalServerError, apierror.ContentType
+		jsonBytes, _ = json.Marshal(apierror.Internal(err).Problem(""))
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(code)
 	_, err = w.Write(jsonBytes)
 
 	if err != nil {
-		log.Println(err)
-		errorResponse(w, http.StatusInternalServerError, "internal error")
+		slog.Debug("failed writing response", "error", err)
 	}
 }
-func errorResponse(w http.ResponseWriter, code int, errs interface{}) {
-	writeJSON(w, code, M{"errors": errs})
+
+// writeError answers the request with the problem details of err. Internal
+// errors are logged, their details are not sent to the client.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := apierror.From(err)
+	if apiErr.Status >= http.StatusInternalServerError {
+		loggerFromContext(r.Context()).Error("request failed", "error", err)
+	}
+	writeProblem(w, r, apiErr)
+}
+
+// writeProblem answers the request with the problem details of apiErr
+func writeProblem(w http.ResponseWriter, r *http.Request, apiErr *apierror.Error) {
+	problem := apiErr.Problem(requestPath(r))
+	problem.RequestID = GetRequestIDFromContext(r.Context())
+	writeBody(w, apiErr.Status, apierror.ContentType, problem)
+}
+
+// requestPath returns the path the client requested, which mounted routers
+// strip from r.URL.Path
+func requestPath(r *http.Request) string {
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		return u.Path
+	}
+	return r.URL.Path
+}
+
+// pathID parses the id path parameter
+func pathID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return 0, apierror.InvalidParameter("id", "must be an integer")
+	}
+	return id, nil
 }
 
 func readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
@@ -55,12 +112,13 @@ func readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 		}
 	}
 
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 
 	err := dec.Decode(&dst)
 	if err != nil {
-		return &malformedRequest{status: http.StatusBadRequest, msg: err.Error()}
+		return decodeError(err)
 	}
 
 	err = dec.Decode(&struct{}{})
@@ -72,15 +130,70 @@ func readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 	return nil
 }
 
-func LoadEnv() error {
-	viper.SetConfigFile(".env")
+// decodeError describes the JSON decoding errors, with the field at fault
+// when there is one
+func decodeError(err error) *malformedRequest {
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	var maxBytesError *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesError):
+		msg := fmt.Sprintf("Request body must not be larger than %d bytes", maxBytesError.Limit)
+		return &malformedRequest{status: http.StatusRequestEntityTooLarge, msg: msg}
+	case errors.As(err, &syntaxError):
+		msg := fmt.Sprintf("Request body contains badly-formed JSON (at position %d)", syntaxError.Offset)
+		return &malformedRequest{status: http.StatusBadRequest, msg: msg}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &malformedRequest{status: http.StatusBadRequest, msg: "Request body contains badly-formed JSON"}
+	case errors.As(err, &typeError):
+		msg := fmt.Sprintf("Request body contains an invalid value for the %q field, expected %s", typeError.Field, typeError.Type)
+		reason := "must be of type " + typeError.Type.String()
+		return &malformedRequest{status: http.StatusBadRequest, msg: msg, field: typeError.Field, reason: reason}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		msg := fmt.Sprintf("Request body contains unknown field %q", field)
+		return &malformedRequest{status: http.StatusBadRequest, msg: msg, field: field, reason: "is not allowed"}
+	case errors.Is(err, io.EOF):
+		return &malformedRequest{status: http.StatusBadRequest, msg: "Request body must not be empty"}
+	}
+	return &malformedRequest{status: http.StatusBadRequest, msg: err.Error()}
+}
 
-	// Read environment variables from the file
-	if err := viper.ReadInConfig(); err != nil {
-		return fmt.Errorf("error reading .env file: %w", err)
+// queryInt parses an optional integer query parameter
+func queryInt(r *http.Request, name string) (*int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
 	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, apierror.InvalidParameter(name, "must be an integer")
+	}
+	return &n, nil
+}
 
-	// Automatically read environment variables
-	viper.AutomaticEnv()
-	return nil
+// queryBool parses an optional boolean query parameter
+func queryBool(r *http.Request, name string) (*bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, apierror.InvalidParameter(name, "must be a boolean")
+	}
+	return &b, nil
+}
+
+// queryTime parses an optional RFC 3339 time query parameter
+func queryTime(r *http.Request, name string) (*time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, apierror.InvalidParameter(name, "must be an RFC 3339 time")
+	}
+	return &t, nil
 }
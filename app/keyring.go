@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// pasetoLocal tokens are encrypted with a shared key, pasetoPublic tokens
+	// are signed with Ed25519 so that other services can verify them with the
+	// published public keys
+	pasetoLocal  = "local"
+	pasetoPublic = "public"
+)
+
+var pasetoModes = []string{pasetoLocal, pasetoPublic}
+
+// legacyKeyID is the ID of PASETO_KEY, which predates key IDs. Its tokens have
+// no key ID in their footer.
+const legacyKeyID = ""
+
+// tokenFooter is the unencrypted, but authenticated, footer of the tokens
+type tokenFooter struct {
+	KeyID string `json:"kid,omitempty"`
+}
+
+// Keyring holds the PASETO keys by ID. New tokens are sealed with the active
+// key of their purpose, tokens are opened with the key named by their footer,
+// so that keys can be rotated without logging out every user.
+type Keyring struct {
+	// Active encrypts the new local tokens
+	Active string
+	// ActivePublic signs the new access tokens when PASETO_MODE is public
+	ActivePublic string
+	// Keys encrypt local tokens
+	Keys map[string][]byte
+	// SigningKeys sign public tokens
+	SigningKeys map[string]ed25519.PrivateKey
+}
+
+// keyring is replaced as a whole by setupKeyring, requests in flight keep the
+// keyring they loaded
+var keyring atomic.Pointer[Keyring]
+
+func getKeyring() *Keyring {
+	return keyring.Load()
+}
+
+// keyringFile is the format of PASETO_KEYRING_FILE, managed by the keys command
+type keyringFile struct {
+	Active       string       `json:"active"`
+	ActivePublic string       `json:"active_public,omitempty"`
+	Keys         []keyringKey `json:"keys"`
+}
+
+type keyringKey struct {
+	ID string `json:"id"`
+	// Purpose is local or public, keys generated before public tokens have none
+	Purpose string `json:"purpose,omitempty"`
+	// Key is hex encoded, like PASETO_KEY. Public keys store the seed of
+	// their Ed25519 private key.
+	Key       string    `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (k keyringKey) purpose() string {
+	if k.Purpose == "" {
+		return pasetoLocal
+	}
+	return k.Purpose
+}
+
+// readKeyringFile reads the keyring file, a missing file is an empty keyring
+func readKeyringFile(path string) (*keyringFile, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &keyringFile{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading the keyring: %w", err)
+	}
+	var f keyringFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("error parsing the keyring %s: %w", path, err)
+	}
+	return &f, nil
+}
+
+// write replaces the keyring file, readable by the owner only. The file is
+// renamed into place so that servers reloading it never read half of it.
+func (f *keyringFile) write(path string) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keyring-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (f *keyringFile) find(id string) int {
+	return slices.IndexFunc(f.Keys, func(k keyringKey) bool { return k.ID == id })
+}
+
+// promote makes k the active key of its purpose
+func (f *keyringFile) promote(k keyringKey) {
+	if k.purpose() == pasetoPublic {
+		f.ActivePublic = k.ID
+	} else {
+		f.Active = k.ID
+	}
+}
+
+func (f *keyringFile) isActive(id string) bool {
+	return id == f.Active || id == f.ActivePublic
+}
+
+// loadKeyring builds the keyring of PASETO_KEY and the keyring file
+func loadKeyring(cfg AuthConfig) (*Keyring, error) {
+	kr := &Keyring{Keys: map[string][]byte{}, SigningKeys: map[string]ed25519.PrivateKey{}}
+	if cfg.PasetoKey != nil {
+		kr.Keys[legacyKeyID] = cfg.PasetoKey
+	}
+	if cfg.KeyringFile == "" {
+		return kr, nil
+	}
+
+	f, err := readKeyringFile(cfg.KeyringFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range f.Keys {
+		if k.ID == legacyKeyID {
+			return nil, fmt.Errorf("keyring %s has a key without an ID", cfg.KeyringFile)
+		}
+		if _, ok := kr.Keys[k.ID]; ok || kr.SigningKeys[k.ID] != nil {
+			return nil, fmt.Errorf("keyring %s has several keys with ID %s", cfg.KeyringFile, k.ID)
+		}
+		key, err := hex.DecodeString(k.Key)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("key %s of keyring %s must be 32 bytes, hex encoded", k.ID, cfg.KeyringFile)
+		}
+		switch k.purpose() {
+		case pasetoLocal:
+			kr.Keys[k.ID] = key
+		case pasetoPublic:
+			kr.SigningKeys[k.ID] = ed25519.NewKeyFromSeed(key)
+		default:
+			return nil, fmt.Errorf("key %s of keyring %s must be local or public", k.ID, cfg.KeyringFile)
+		}
+	}
+	if f.Active != legacyKeyID {
+		if _, ok := kr.Keys[f.Active]; !ok {
+			return nil, fmt.Errorf("active local key %s is not in keyring %s", f.Active, cfg.KeyringFile)
+		}
+		kr.Active = f.Active
+	}
+	if f.ActivePublic != "" {
+		if kr.SigningKeys[f.ActivePublic] == nil {
+			return nil, fmt.Errorf("active public key %s is not in keyring %s", f.ActivePublic, cfg.KeyringFile)
+		}
+		kr.ActivePublic = f.ActivePublic
+	}
+	return kr, nil
+}
+
+// setupKeyring loads the keys of the configuration, keeping the current keys
+// when they are invalid. A local key is always active, as the tokens other
+// than access tokens are encrypted whatever PASETO_MODE, and a public key as
+// well when PASETO_MODE is public.
+func setupKeyring(cfg AuthConfig) error {
+	kr, err := loadKeyring(cfg)
+	if err != nil {
+		return err
+	}
+	if _, ok := kr.Keys[kr.Active]; !ok {
+		return errors.New(`no active local PASETO key, set PASETO_KEY or run "keys generate -purpose local -promote"`)
+	}
+	if cfg.PasetoMode == pasetoPublic && kr.SigningKeys[kr.ActivePublic] == nil {
+		return errors.New(`PASETO_MODE public requires an active public key, run "keys generate -purpose public -promote"`)
+	}
+	keyring.Store(kr)
+	return nil
+}
+
+// publicKeysHandler publishes the public keys of the keyring, with which other
+// services verify the public tokens, along the PASERK of each key
+func publicKeysHandler(w http.ResponseWriter, r *http.Request) {
+	kr := getKeyring()
+	keys := []M{}
+	for _, id := range slices.Sorted(maps.Keys(kr.SigningKeys)) {
+		publicKey := base64.RawURLEncoding.EncodeToString(kr.SigningKeys[id].Public().(ed25519.PublicKey))
+		keys = append(keys, M{
+			"kid":        id,
+			"version":    "v2",
+			"purpose":    pasetoPublic,
+			"public_key": publicKey,
+			"paserk":     "k2.public." + publicKey,
+			"active":     id == kr.ActivePublic,
+		})
+	}
+	// Verifiers refetch the keys on an unknown key ID
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, M{"keys": keys})
+}
+
+// reloadKeyringOn reloads the keyring on every signal, so that the keys
+// generated or promoted by the keys command are used without a restart
+func reloadKeyringOn(signals <-chan os.Signal) {
+	for range signals {
+		if err := setupKeyring(config.Auth); err != nil {
+			slog.Error("failed reloading the PASETO keys, keeping the current ones", "error", err)
+			continue
+		}
+		slog.Info("Reloaded the PASETO keys", "active", getKeyring().Active, "active_public", getKeyring().ActivePublic)
+	}
+}
+
+// newKeyID returns a random key ID, short as it is part of every token
+func newKeyID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+const keysUsage = `usage: keys <command> [arguments]
+
+commands:
+  list                 list the PASETO keys
+  generate [-promote] [-purpose local|public]
+                       add a key of the purpose of PASETO_MODE, or the given
+                       one, -promote makes it the active key of its purpose
+  promote <id>         seal the new tokens of the purpose of a key with it
+  retire <id>          remove a key, refusing the tokens it sealed
+
+Servers reload the keys on SIGHUP. Generate a key, reload every server, then
+promote it, so that no server refuses the tokens of another.
+`
+
+// keysCommand manages the keys of PASETO_KEYRING_FILE
+func keysCommand(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("keys", flag.ContinueOnError)
+	fset.Usage = func() { fmt.Fprint(fset.Output(), keysUsage) }
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() == 0 {
+		fset.Usage()
+		return errors.New("missing keys command")
+	}
+	command, args := fset.Arg(0), fset.Args()[1:]
+
+	path := config.Auth.KeyringFile
+	if path == "" {
+		return errors.New("the keys command requires PASETO_KEYRING_FILE")
+	}
+	f, err := readKeyringFile(path)
+	if err != nil {
+		return err
+	}
+	keyID := func() (int, error) {
+		if len(args) == 0 {
+			return 0, fmt.Errorf("keys %s requires the ID of a key", command)
+		}
+		i := f.find(args[0])
+		if i < 0 {
+			return 0, fmt.Errorf("key %s does not exist", args[0])
+		}
+		return i, nil
+	}
+
+	switch command {
+	case "list":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tPURPOSE\tCREATED AT\tACTIVE")
+		if config.Auth.PasetoKey != nil {
+			fmt.Fprintf(w, "(PASETO_KEY)\t%s\t\t%t\n", pasetoLocal, f.Active == legacyKeyID)
+		}
+		for _, k := range f.Keys {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\n", k.ID, k.purpose(), k.CreatedAt.Format(time.RFC3339), f.isActive(k.ID))
+		}
+		return w.Flush()
+
+	case "generate":
+		gset := flag.NewFlagSet("keys generate", flag.ContinueOnError)
+		promote := gset.Bool("promote", false, "make the new key the active key")
+		purpose := gset.String("purpose", config.Auth.PasetoMode, "local or public")
+		if err := gset.Parse(args); err != nil {
+			return err
+		}
+		if !slices.Contains(pasetoModes, *purpose) {
+			return errors.New("the purpose must be local or public")
+		}
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		id, err := newKeyID()
+		if err != nil {
+			return err
+		}
+		if f.find(id) >= 0 {
+			return fmt.Errorf("key %s already exists, try again", id)
+		}
+		// A random seed makes an Ed25519 key as well
+		f.Keys = append(f.Keys, keyringKey{ID: id, Purpose: *purpose, Key: hex.EncodeToString(key), CreatedAt: time.Now().UTC()})
+		if *promote {
+			f.promote(f.Keys[len(f.Keys)-1])
+		}
+		if err := f.write(path); err != nil {
+			return err
+		}
+		if *promote {
+			fmt.Printf("Generated and promoted %s key %s\n", *purpose, id)
+		} else {
+			fmt.Printf("Generated %s key %s, promote it once every server has reloaded the keys\n", *purpose, id)
+		}
+		return nil
+
+	case "promote":
+		i, err := keyID()
+		if err != nil {
+			return err
+		}
+		f.promote(f.Keys[i])
+		if err := f.write(path); err != nil {
+			return err
+		}
+		fmt.Printf("Promoted %s key %s\n", f.Keys[i].purpose(), f.Keys[i].ID)
+		return nil
+
+	case "retire":
+		i, err := keyID()
+		if err != nil {
+			return err
+		}
+		id := f.Keys[i].ID
+		if f.isActive(id) {
+			return fmt.Errorf("key %s is an active key, promote another key first", id)
+		}
+		f.Keys = slices.Delete(f.Keys, i, i+1)
+		if err := f.write(path); err != nil {
+			return err
+		}
+		fmt.Printf("Retired key %s\n", id)
+		return nil
+
+	default:
+		fset.Usage()
+		return fmt.Errorf("unknown keys command %q", command)
+	}
+}
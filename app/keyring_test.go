@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/o1egl/paseto"
+)
+
+// usePublicTokens switches the test configuration to PASETO_MODE public with a
+// new signing key
+func usePublicTokens(t *testing.T) {
+	t.Helper()
+	config.Auth.KeyringFile = filepath.Join(t.TempDir(), "keyring.json")
+	if err := keysCommand(context.Background(), []string{"generate", "-purpose", "public", "-promote"}); err != nil {
+		t.Fatal(err)
+	}
+	config.Auth.PasetoMode = pasetoPublic
+	if err := setupKeyring(config.Auth); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublicTokens(t *testing.T) {
+	client := newTestClient(t)
+	u := client.User.Create().SetName("alice").SetPassword("hash").SaveX(context.Background())
+	localToken, err := issueAccessToken(u, "session", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usePublicTokens(t)
+
+	publicToken, err := issueAccessToken(u, "session", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(publicToken, "v2.public.") {
+		t.Errorf("access token %s is not signed", publicToken)
+	}
+	// Tokens carrying secrets stay encrypted
+	mfaToken, err := issueMFAToken(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(mfaToken, "v2.local.") {
+		t.Errorf("MFA token %s is not encrypted", mfaToken)
+	}
+	if _, err := parseAccessToken(mfaToken); err == nil {
+		t.Error("MFA token accepted as an access token")
+	}
+
+	if _, err := parseAccessToken(publicToken); err != nil {
+		t.Error(err)
+	}
+	if _, err := decryptToken(publicToken); err == nil {
+		t.Error("access token accepted as a local token")
+	}
+	if _, err := parseAccessToken(localToken); err == nil {
+		t.Error("local access token accepted in public mode")
+	}
+	// Until the end of the transition
+	config.Auth.PasetoTransitionUntil = time.Now().Add(time.Hour)
+	if _, err := parseAccessToken(localToken); err != nil {
+		t.Errorf("local access token refused during the transition: %v", err)
+	}
+}
+
+func TestPublicKeysWithoutOrigin(t *testing.T) {
+	newTestClient(t)
+	usePublicTokens(t)
+
+	// Other services send no Origin
+	rec := httptest.NewRecorder()
+	newRouter(CORSMiddleware).ServeHTTP(rec, httptest.NewRequest("GET", "/.well-known/paseto-keys", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"active":true`) {
+		t.Fatal(rec.Code, rec.Body)
+	}
+
+	var footer tokenFooter
+	token, err := sealAccessToken(paseto.JSONToken{Subject: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := paseto.ParseFooter(token, &footer); err != nil || !strings.Contains(rec.Body.String(), `"kid":"`+footer.KeyID+`"`) {
+		t.Errorf("key %q of the token is not published: %v", footer.KeyID, err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"go/djan/app/ent"
+	"time"
+)
+
+// lockoutDuration returns how long logins are refused after the given number
+// of consecutive failures: not at all below the threshold, then for the
+// lockout duration, doubled by every further failure up to the max
+func lockoutDuration(cfg AuthConfig, failures int) time.Duration {
+	if cfg.LockoutThreshold <= 0 || failures < cfg.LockoutThreshold {
+		return 0
+	}
+	d := cfg.LockoutDuration
+	for i := cfg.LockoutThreshold; i < failures && d < cfg.LockoutMaxDuration; i++ {
+		d *= 2
+	}
+	return min(d, cfg.LockoutMaxDuration)
+}
+
+// lockedFor returns how long the logins of u are still refused
+func lockedFor(u *ent.User) time.Duration {
+	if u.LockedUntil == nil {
+		return 0
+	}
+	return max(time.Until(*u.LockedUntil), 0)
+}
+
+// recordFailedLogin counts a failed login against u, and locks the account
+// once the failures reach the threshold
+func recordFailedLogin(ctx context.Context, client *ent.Client, u *ent.User) error {
+	u, err := client.User.UpdateOne(u).AddFailedLogins(1).Save(ctx)
+	if err != nil {
+		return err
+	}
+	if d := lockoutDuration(config.Auth, u.FailedLogins); d > 0 {
+		return client.User.UpdateOne(u).SetLockedUntil(time.Now().Add(d)).Exec(ctx)
+	}
+	return nil
+}
+
+// resetFailedLogins clears the failures of u after a successful login
+func resetFailedLogins(ctx context.Context, client *ent.Client, u *ent.User) error {
+	if u.FailedLogins == 0 && u.LockedUntil == nil {
+		return nil
+	}
+	return client.User.UpdateOne(u).SetFailedLogins(0).ClearLockedUntil().Exec(ctx)
+}
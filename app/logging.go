@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// redacted replaces the values of sensitive fields in the logs
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are redacted from the logs, both as attribute keys and as
+// fields of logged values
+var sensitiveKeys = map[string]bool{
+	"password":      true,
+	"token":         true,
+	"refresh_token": true,
+	"access_token":  true,
+	"authorization": true,
+	"cookie":        true,
+	"set-cookie":    true,
+	"secret":        true,
+	"api_key":       true,
+	"x-api-key":     true,
+	"paseto_key":    true,
+}
+
+type requestIDContextKey struct{}
+type requestLogContextKey struct{}
+
+// requestLog holds the logger of a request. Middlewares and handlers add
+// attributes to it, which end up in the access log line too.
+type requestLog struct {
+	logger *slog.Logger
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	return sensitiveKeys[key] ||
+		strings.HasSuffix(key, "_password") ||
+		strings.HasSuffix(key, "_token") ||
+		strings.HasSuffix(key, "_secret")
+}
+
+// newLogger returns a JSON logger redacting sensitive fields
+func newLogger(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	}))
+}
+
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if isSensitive(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	if a.Value.Kind() == slog.KindAny {
+		if _, ok := a.Value.Any().(error); !ok {
+			a.Value = slog.AnyValue(redactValue(a.Value.Any()))
+		}
+	}
+	return a
+}
+
+// redactValue redacts the sensitive fields of a value, such as a request
+// body, through its JSON representation
+func redactValue(v any) any {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var x any
+	if err := dec.Decode(&x); err != nil {
+		return v
+	}
+	return redactJSON(x)
+}
+
+func redactJSON(x any) any {
+	switch x := x.(type) {
+	case map[string]any:
+		for key, value := range x {
+			if isSensitive(key) {
+				x[key] = redacted
+			} else {
+				x[key] = redactJSON(value)
+			}
+		}
+	case []any:
+		for i, value := range x {
+			x[i] = redactJSON(value)
+		}
+	}
+	return x
+}
+
+// loggerFromContext returns the logger of the request, or the default logger
+// outside of requests
+func loggerFromContext(ctx context.Context) *slog.Logger {
+	if rl, ok := ctx.Value(requestLogContextKey{}).(*requestLog); ok {
+		return rl.logger
+	}
+	return slog.Default()
+}
+
+// addLogAttrs adds attributes to the logger of the request
+func addLogAttrs(ctx context.Context, args ...any) {
+	if rl, ok := ctx.Value(requestLogContextKey{}).(*requestLog); ok {
+		rl.logger = rl.logger.With(args...)
+	}
+}
+
+// GetRequestIDFromContext returns the ID of the request
+func GetRequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts the request IDs of clients and proxies as long as
+// they are short and can not break the log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID propagates the X-Request-ID of the request, or generates one, and
+// returns it in the response
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDContextKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// clientIP returns the IP address of the client
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// logging puts a logger carrying the request ID in the context, and writes an
+// access log line once the request is served. It must be wrapped by requestID.
+func logging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		rl := &requestLog{
+			logger: slog.Default().With("request_id", GetRequestIDFromContext(r.Context())),
+		}
+		ctx := context.WithValue(r.Context(), requestLogContextKey{}, rl)
+
+		wrappedWriter := &WrappedWriter{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+		next.ServeHTTP(wrappedWriter, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		if wrappedWriter.statusCode >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		rl.logger.LogAttrs(ctx, level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", wrappedWriter.statusCode),
+			slog.Int("bytes", wrappedWriter.bytes),
+			slog.String("remote_ip", clientIP(r)),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+		)
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is an email sent by the application
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails. The log and file mailers are meant for local use,
+// other deliveries, such as SMTP or the API of an email service, implement
+// it and are registered in mailers.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// mailers are the mailers which can be selected with MAILER
+var mailers = map[string]func(cfg MailConfig) (Mailer, error){
+	"log": func(MailConfig) (Mailer, error) {
+		return logMailer{}, nil
+	},
+	"file": func(cfg MailConfig) (Mailer, error) {
+		return newFileMailer(cfg.Dir)
+	},
+}
+
+// mailer sends the emails of the application, set up by serve
+var mailer Mailer = logMailer{}
+
+// pendingMails counts the mails sent off the request path, which serve waits
+// for before exiting
+var pendingMails sync.WaitGroup
+
+// mailerNames returns the names accepted by MAILER
+func mailerNames() []string {
+	names := make([]string, 0, len(mailers))
+	for name := range mailers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// setupMailer creates the mailer named by the configuration
+func setupMailer(cfg MailConfig) error {
+	newMailer, ok := mailers[cfg.Mailer]
+	if !ok {
+		return fmt.Errorf("unknown mailer %q", cfg.Mailer)
+	}
+	m, err := newMailer(cfg)
+	if err != nil {
+		return err
+	}
+	mailer = m
+	return nil
+}
+
+// logMailer writes emails to the log instead of sending them
+type logMailer struct{}
+
+func (logMailer) Send(ctx context.Context, msg Message) error {
+	loggerFromContext(ctx).Info("email",
+		"from", msg.From,
+		"to", msg.To,
+		"subject", msg.Subject,
+		"body", msg.Body,
+	)
+	return nil
+}
+
+// fileMailer writes every email to a file of its directory, in the format
+// of .eml files
+type fileMailer struct {
+	dir string
+}
+
+func newFileMailer(dir string) (*fileMailer, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating mail directory: %w", err)
+	}
+	return &fileMailer{dir: dir}, nil
+}
+
+func (m *fileMailer) Send(_ context.Context, msg Message) error {
+	now := time.Now()
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	name := fmt.Sprintf("%s-%d.eml", now.Format("20060102T150405"), now.Nanosecond())
+	return os.WriteFile(filepath.Join(m.dir, name), []byte(b.String()), 0o600)
+}
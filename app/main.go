@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
-	"log"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,73 +14,92 @@ import (
 
 func main() {
 
-	// Load the Env file
-	if err := LoadEnv(); err != nil {
-		log.Fatalf("Error loading env: %v", err)
+	// Load the configuration
+	cfg, err := LoadConfig()
+	if err != nil {
+		slog.Error("Error loading config", "error", err)
+		os.Exit(1)
 	}
+	config = cfg
+	slog.SetDefault(newLogger(os.Stderr, config.Log.Level))
+
+	if err := runCommand(context.Background(), os.Args[1:]); err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// serve runs the API server until it is interrupted
+func serve(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("serve", flag.ContinueOnError)
+	autoMigrateFlag := fset.Bool("auto-migrate", config.Database.AutoMigrate, "apply pending migrations at boot")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+
+	exporter, err := newSpanExporter(config.Tracing.Exporter)
+	if err != nil {
+		return err
+	}
+	shutdownTracing := setupTracing(config.Tracing, exporter)
 
 	client := GetClient()
 	defer client.Close()
 
-	// Run the auto migration tool.
-	if err := client.Schema.Create(context.Background()); err != nil {
-		log.Fatalf("failed creating schema resources: %v", err)
+	// Apply the versioned migrations of the migrations directory
+	migrations, err := newMigrator(GetDB(), config.Database.MigrationsDir)
+	if err != nil {
+		return err
+	}
+	if err := autoMigrate(ctx, migrations, *autoMigrateFlag); err != nil {
+		return fmt.Errorf("failed migrating the database: %w", err)
+	}
+	if err := setupReadiness(migrations); err != nil {
+		return err
 	}
 
 	// enable debugging
 	// client = client.Debug()
 
-	log.Println("Connected to db")
-
-	// Ordering by most specific wins, instead of top down approach
-	// panics if both routes are equally as specific
-
-	pasetoKey = getPasetoKey()
-
-	user_router := http.NewServeMux()
-	user_router.HandleFunc("GET /", getUsers)
-	user_router.HandleFunc("GET /{id}", getUserById)
-	user_router.HandleFunc("PATCH /{id}", updateUserById)
-	user_router.HandleFunc("DELETE /{id}", deleteUserById)
+	slog.Info("Connected to db")
 
-	friends_router := http.NewServeMux()
-	friends_router.HandleFunc("POST /", addFriendById)
-	friends_router.HandleFunc("DELETE /", deleteFriendById)
+	// Purge revoked, refresh and password reset tokens once they have expired
+	sweeperCtx, stopSweeper := context.WithCancel(ctx)
+	defer stopSweeper()
+	startRevocationSweeper(sweeperCtx, client, config.Auth.RevocationSweepInterval)
 
-	blog_router := http.NewServeMux()
-	blog_router.HandleFunc("GET /", getBlogs)
-	blog_router.HandleFunc("GET /{id}", getBlogById)
-	blog_router.HandleFunc("POST /", createBlog)
-	blog_router.HandleFunc("PATCH /{id}", updateBlogById)
-	blog_router.HandleFunc("DELETE /{id}", deleteByBlogId)
-
-	tags_router := http.NewServeMux()
-	tags_router.HandleFunc("PATCH /{id}", updateTagById)
-	tags_router.HandleFunc("GET /", getTags)
-
-	api_router := http.NewServeMux()
-	api_router.Handle("/user/", http.StripPrefix("/user", user_router))
-	api_router.Handle("/blog/", http.StripPrefix("/blog", blog_router))
-	api_router.Handle("/friend/", http.StripPrefix("/friend", friends_router))
-	api_router.Handle("/tag/", http.StripPrefix("/tag", tags_router))
-
-	login_router := http.NewServeMux()
-	login_router.HandleFunc("POST /signout/", signOutHandler)
-	login_router.HandleFunc("POST /login/", loginHandler)
-	login_router.HandleFunc("POST /signup/", signUpHandler)
-
-	router := http.NewServeMux()
-	router.Handle("/auth/", http.StripPrefix("/auth", login_router))
-	router.Handle("/api/", http.StripPrefix("/api", authenticateUser(api_router)))
-
-	stack := createStack(
+	// Throttle the auth endpoints against brute force
+	setupRateLimits(config.RateLimit)
+	if err := setupPasswordPolicy(config.Password); err != nil {
+		return err
+	}
+	if err := setupMailer(config.Mail); err != nil {
+		return err
+	}
+	if err := setupKeyring(config.Auth); err != nil {
+		return err
+	}
+	// Reload the PASETO keys after the keys command changed them
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+	go reloadKeyringOn(reload)
+
+	router := newRouter(
+		requestID,
 		logging,
+		tracing,
+		metrics,
 		CORSMiddleware,
+		recovery,
 	)
 
 	server := http.Server{
-		Addr:    ":8080",
-		Handler: stack(router),
+		Addr:         config.Server.Addr,
+		Handler:      router,
+		ReadTimeout:  config.Server.ReadTimeout,
+		WriteTimeout: config.Server.WriteTimeout,
+		IdleTimeout:  config.Server.IdleTimeout,
 	}
 
 	// We are adding the server to a goroutine now and
@@ -87,22 +108,40 @@ func main() {
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
 	go func() {
-		log.Println("Starting server on port 8080")
-		if err := server.ListenAndServeTLS("server.crt", "server.key"); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Error starting server: %v", err)
+		slog.Info("Starting server", "addr", config.Server.Addr)
+		if err := server.ListenAndServeTLS(config.Server.TLSCertFile, config.Server.TLSKeyFile); err != nil && err != http.ErrServerClosed {
+			slog.Error("Error starting server", "error", err)
+			os.Exit(1)
 		}
 	}()
 
-	<-stop
-	log.Println("Shutting down server...")
+	sig := <-stop
+	readiness.shuttingDown.Store(true)
+	// Keep serving while the orchestrator notices /readyz failing and stops
+	// routing traffic to the server
+	if sig == syscall.SIGTERM && config.Server.ShutdownDelay > 0 {
+		slog.Info("Draining traffic before shutting down", "delay", config.Server.ShutdownDelay)
+		time.Sleep(config.Server.ShutdownDelay)
+	}
+	slog.Info("Shutting down server...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(ctx, config.Server.ShutdownTimeout)
 	defer cancel()
 
-	client.Close()
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+	stopSweeper()
+	// The client is closed once the requests in flight are served
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("server forced to shutdown: %w", err)
+	}
+
+	// Send the mails of the last requests
+	pendingMails.Wait()
+
+	// Flush the spans of the last requests
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("failed exporting spans", "error", err)
 	}
 
-	log.Println("Server stopped gracefully")
+	slog.Info("Server stopped gracefully")
+	return nil
 }
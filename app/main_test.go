@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"entgo.io/ent/dialect"
+	"go/djan/app/ent"
+	"go/djan/app/ent/enttest"
+	"modernc.org/sqlite"
+)
+
+func init() {
+	// ent opens SQLite databases with the driver name of mattn/go-sqlite3
+	sql.Register(dialect.SQLite, &sqlite.Driver{})
+}
+
+var testDBs atomic.Int64
+
+// newTestClient opens an empty in-memory SQLite database with the ent schema,
+// returned by GetClient until the test ends, along with a test configuration
+func newTestClient(t *testing.T) *ent.Client {
+	t.Helper()
+	dsn := fmt.Sprintf("file:test%d?mode=memory&cache=shared&_pragma=foreign_keys(1)", testDBs.Add(1))
+	client := enttest.Open(t, dialect.SQLite, dsn)
+	t.Cleanup(func() { client.Close() })
+
+	once.Do(func() {})
+	clientInstance = client
+	config = testConfig()
+	if err := setupKeyring(config.Auth); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func testConfig() *Config {
+	return &Config{
+		Auth: AuthConfig{
+			PasetoKey:                 bytes.Repeat([]byte{1}, 32),
+			PasetoMode:                pasetoLocal,
+			AccessTokenLifetime:       15 * time.Minute,
+			RefreshTokenLifetime:      24 * time.Hour,
+			LockoutThreshold:          5,
+			LockoutDuration:           time.Minute,
+			LockoutMaxDuration:        time.Hour,
+			EmailVerificationLifetime: 24 * time.Hour,
+			EmailVerificationURL:      "https://localhost/verify-email",
+			TOTPKey:                   bytes.Repeat([]byte{2}, 32),
+			TOTPIssuer:                "Go-Djan",
+			MFATokenLifetime:          5 * time.Minute,
+		},
+		CORS:     CORSConfig{AllowedOrigins: []string{"http://localhost"}},
+		Password: PasswordConfig{MinLength: 8, ResetTokenLifetime: time.Hour, ResetURL: "https://localhost/reset-password"},
+		Mail:     MailConfig{Mailer: "log", From: "Go-Djan <no-reply@localhost>"},
+	}
+}
+
+// accessToken issues an access token of a new session of u
+func accessToken(t *testing.T, u *ent.User) string {
+	t.Helper()
+	token, err := issueAccessToken(u, "session", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// doJSON sends a request with a JSON body to the handler, with the bearer
+// token unless empty, and decodes the JSON response
+func doJSON(t *testing.T, h http.Handler, method, path, token string, body any) (int, M) {
+	t.Helper()
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(b))
+	req.Header.Set("Origin", "http://localhost")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	out := M{}
+	if strings.Contains(rec.Header().Get("Content-Type"), "json") {
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return rec.Code, out
+}
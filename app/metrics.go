@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"go/djan/app/ent"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	entgo "entgo.io/ent"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "djan"
+
+// metricsRegistry holds the metrics exposed at /metrics
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served, by route pattern and status code.",
+	}, []string{"route", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests, by route pattern and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "status"})
+
+	httpRequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests being served.",
+	})
+
+	entQueriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "ent_queries_total",
+		Help:      "ent queries, by entity, operation and result.",
+	}, []string{"entity", "op", "result"})
+
+	entMutationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "ent_mutations_total",
+		Help:      "ent mutations, by entity, operation and result.",
+	}, []string{"entity", "op", "result"})
+
+	httpPanicsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_panics_total",
+		Help:      "Panics recovered while serving HTTP requests, by route pattern.",
+	}, []string{"route"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequestsTotal,
+		httpRequestDuration,
+		httpRequestsInFlight,
+		entQueriesTotal,
+		entMutationsTotal,
+		httpPanicsTotal,
+	)
+}
+
+var metricsHTTPHandler = promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	metricsHTTPHandler.ServeHTTP(w, r)
+}
+
+// unmatchedRoute labels the requests which matched no route, so that
+// arbitrary paths do not each create a time series
+const unmatchedRoute = "unmatched"
+
+// metrics records the count and latency of the requests by route pattern and
+// status code
+func metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		httpRequestsInFlight.Inc()
+		defer httpRequestsInFlight.Dec()
+
+		ctx, route := withMatchedRoute(r.Context())
+		wrappedWriter := &WrappedWriter{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+		next.ServeHTTP(wrappedWriter, r.WithContext(ctx))
+
+		label := *route
+		if label == "" {
+			label = unmatchedRoute
+		}
+		status := strconv.Itoa(wrappedWriter.statusCode)
+		httpRequestsTotal.WithLabelValues(label, status).Inc()
+		httpRequestDuration.WithLabelValues(label, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// instrumentClient counts the queries and mutations of the client, and
+// exposes the connection pool stats of its database
+func instrumentClient(client *ent.Client, db *sql.DB) {
+	metricsRegistry.MustRegister(collectors.NewDBStatsCollector(db, "postgres"))
+
+	client.Intercept(ent.InterceptFunc(func(next ent.Querier) ent.Querier {
+		return ent.QuerierFunc(func(ctx context.Context, q ent.Query) (ent.Value, error) {
+			v, err := next.Query(ctx, q)
+			if qc := entgo.QueryFromContext(ctx); qc != nil {
+				entQueriesTotal.WithLabelValues(qc.Type, qc.Op, result(err)).Inc()
+			}
+			return v, err
+		})
+	}))
+
+	client.Use(func(next ent.Mutator) ent.Mutator {
+		return ent.MutateFunc(func(ctx context.Context, m ent.Mutation) (ent.Value, error) {
+			v, err := next.Mutate(ctx, m)
+			op := strings.TrimPrefix(m.Op().String(), "Op")
+			entMutationsTotal.WithLabelValues(m.Type(), op, result(err)).Inc()
+			return v, err
+		})
+	})
+}
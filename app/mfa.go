@@ -0,0 +1,557 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"go/djan/app/apierror"
+	"go/djan/app/ent"
+	"go/djan/app/ent/recoverycode"
+	"go/djan/app/ent/user"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/o1egl/paseto"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	// mfaPendingPurpose is the purpose of the tokens of logins waiting for
+	// their second factor
+	mfaPendingPurpose = "mfa_pending"
+	// recoveryCodeCount recovery codes are issued when enabling TOTP
+	recoveryCodeCount = 10
+	totpPeriod        = 30
+)
+
+var errTOTPDisabled = apierror.NotFound("Two-factor authentication is not configured")
+
+// totpOpts are the parameters expected by authenticator apps
+var totpOpts = totp.ValidateOpts{
+	Period:    totpPeriod,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// hasTOTP reports whether u logs in with a second factor
+func hasTOTP(u *ent.User) bool {
+	return u.TotpEnabledAt != nil
+}
+
+// sealSecret encrypts a TOTP secret with TOTP_ENCRYPTION_KEY, the nonce
+// prefixing the ciphertext
+func sealSecret(secret string) ([]byte, error) {
+	gcm, err := totpCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, []byte(secret), nil), nil
+}
+
+// openSecret decrypts a TOTP secret sealed by sealSecret
+func openSecret(sealed []byte) (string, error) {
+	gcm, err := totpCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("sealed TOTP secret is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	secret, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+func totpCipher() (cipher.AEAD, error) {
+	if len(config.Auth.TOTPKey) == 0 {
+		return nil, errors.New("TOTP_ENCRYPTION_KEY is not set")
+	}
+	block, err := aes.NewCipher(config.Auth.TOTPKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// matchTOTP returns the time step of the code, accepting the steps next to the
+// current one for clock drift. Steps up to last were already used.
+func matchTOTP(secret, code string, last int64, now time.Time) (int64, bool) {
+	current := now.Unix() / totpPeriod
+	for step := current - 1; step <= current+1; step++ {
+		if step <= last {
+			continue
+		}
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totpOpts)
+		if err == nil && subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// verifyTOTP checks a code of the secret of u. A code is only accepted once:
+// the step of the last accepted code is recorded.
+func verifyTOTP(ctx context.Context, client *ent.Client, u *ent.User, code string) (bool, error) {
+	if u.TotpSecret == nil {
+		return false, nil
+	}
+	secret, err := openSecret(*u.TotpSecret)
+	if err != nil {
+		return false, err
+	}
+	step, ok := matchTOTP(secret, strings.TrimSpace(code), u.TotpLastStep, time.Now())
+	if !ok {
+		return false, nil
+	}
+	// Concurrent requests can't both use the code
+	n, err := client.User.
+		Update().
+		Where(user.ID(u.ID), user.TotpLastStepLT(step)).
+		SetTotpLastStep(step).
+		Save(ctx)
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// normalizeRecoveryCode ignores the case, dashes and spaces of recovery codes
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
+
+// useRecoveryCode consumes an unused recovery code of u
+func useRecoveryCode(ctx context.Context, client *ent.Client, u *ent.User, code string) (bool, error) {
+	n, err := client.RecoveryCode.
+		Update().
+		Where(
+			recoverycode.CodeHash(hashToken(normalizeRecoveryCode(code))),
+			recoverycode.HasUserWith(user.ID(u.ID)),
+			recoverycode.UsedAtIsNil(),
+		).
+		SetUsedAt(time.Now()).
+		Save(ctx)
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// newRecoveryCode returns a random code of 10 characters formatted as
+// xxxxx-xxxxx
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+	return code[:5] + "-" + code[5:10], nil
+}
+
+// replaceRecoveryCodes issues new recovery codes to u, voiding the previous
+// ones. Only their hash is stored.
+func replaceRecoveryCodes(ctx context.Context, client *ent.Client, u *ent.User) (codes []string, err error) {
+	tx, err := client.Tx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	codes, err = issueRecoveryCodes(ctx, tx, u)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// enableTOTP completes the enrollment of u along with its first recovery codes
+func enableTOTP(ctx context.Context, client *ent.Client, u *ent.User) (codes []string, err error) {
+	tx, err := client.Tx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	codes, err = issueRecoveryCodes(ctx, tx, u)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.User.UpdateOne(u).SetTotpEnabledAt(time.Now()).Exec(ctx); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// issueRecoveryCodes replaces the recovery codes of u within tx
+func issueRecoveryCodes(ctx context.Context, tx *ent.Tx, u *ent.User) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	builders := make([]*ent.RecoveryCodeCreate, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		builders[i] = tx.RecoveryCode.
+			Create().
+			SetCodeHash(hashToken(normalizeRecoveryCode(code))).
+			SetUserID(u.ID)
+	}
+
+	_, err := tx.RecoveryCode.
+		Delete().
+		Where(recoverycode.HasUserWith(user.ID(u.ID))).
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.RecoveryCode.CreateBulk(builders...).Exec(ctx); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// issueMFAToken mints the token of a login whose password was checked, which
+// is exchanged for a token pair along with the second factor. It carries the
+// scopes the login was narrowed to.
+func issueMFAToken(u *ent.User, scopes []string) (string, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	jsonToken := paseto.JSONToken{
+		Jti:        jti,
+		Subject:    strconv.Itoa(u.ID),
+		IssuedAt:   now,
+		Expiration: now.Add(config.Auth.MFATokenLifetime),
+	}
+	jsonToken.Set(purposeClaim, mfaPendingPurpose)
+	if scopes != nil {
+		jsonToken.Set(scopeClaim, strings.Join(scopes, " "))
+	}
+	return encryptToken(jsonToken)
+}
+
+// enrollTOTP generates a new secret for the authenticated user, enabled once
+// a code of it is confirmed
+func enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	if len(config.Auth.TOTPKey) == 0 {
+		writeError(w, r, errTOTPDisabled)
+		return
+	}
+	client := GetClient()
+	user := GetUserFromContext(r.Context())
+	if hasTOTP(user) {
+		writeError(w, r, apierror.Conflict("Two-factor authentication is already enabled"))
+		return
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      config.Auth.TOTPIssuer,
+		AccountName: user.Name,
+		Period:      totpOpts.Period,
+		Digits:      totpOpts.Digits,
+		Algorithm:   totpOpts.Algorithm,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	sealed, err := sealSecret(key.Secret())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	err = client.User.
+		UpdateOne(user).
+		SetTotpSecret(sealed).
+		SetTotpLastStep(0).
+		Exec(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, M{
+		"secret":      key.Secret(),
+		"otpauth_uri": key.URL(),
+	})
+}
+
+type TOTPConfirmRequest struct {
+	Code string `json:"code"`
+}
+
+func (req *TOTPConfirmRequest) validate(v *validator) {
+	v.required("code", req.Code != "")
+}
+
+// confirmTOTP enables the pending secret of the authenticated user with one of
+// its codes, and returns the recovery codes
+func confirmTOTP(w http.ResponseWriter, r *http.Request) {
+	if len(config.Auth.TOTPKey) == 0 {
+		writeError(w, r, errTOTPDisabled)
+		return
+	}
+	client := GetClient()
+	confirm_json, err := decodeJSON[TOTPConfirmRequest](w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	user := GetUserFromContext(r.Context())
+	if hasTOTP(user) {
+		writeError(w, r, apierror.Conflict("Two-factor authentication is already enabled"))
+		return
+	}
+	if user.TotpSecret == nil {
+		writeError(w, r, apierror.Conflict("Start the enrollment with POST /api/user/me/mfa/totp first"))
+		return
+	}
+
+	ok, err := verifyTOTP(r.Context(), client, user, confirm_json.Code)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !ok {
+		writeError(w, r, apierror.Validation(apierror.FieldError{
+			Field:   "code",
+			Code:    apierror.FieldInvalid,
+			Message: "is invalid or has expired",
+		}))
+		return
+	}
+
+	codes, err := enableTOTP(r.Context(), client, user)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, M{"recovery_codes": codes})
+}
+
+type MFAPasswordRequest struct {
+	Password string `json:"password"`
+}
+
+func (req *MFAPasswordRequest) validate(v *validator) {
+	v.required("password", req.Password != "")
+}
+
+// disableTOTP turns off the second factor of the authenticated user, or
+// cancels a pending enrollment
+func disableTOTP(w http.ResponseWriter, r *http.Request) {
+	client := GetClient()
+	disable_json, err := decodeJSON[MFAPasswordRequest](w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	user := GetUserFromContext(r.Context())
+	if user.TotpSecret == nil {
+		writeError(w, r, apierror.Conflict("Two-factor authentication is not enabled"))
+		return
+	}
+	if err := checkCurrentPassword(w, r, client, user, "password", disable_json.Password); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := clearTOTP(r.Context(), client, user); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, M{"message": "Two-factor authentication disabled"})
+}
+
+// clearTOTP removes the secret and recovery codes of u
+func clearTOTP(ctx context.Context, client *ent.Client, u *ent.User) error {
+	err := client.User.
+		UpdateOne(u).
+		ClearTotpSecret().
+		ClearTotpEnabledAt().
+		SetTotpLastStep(0).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.RecoveryCode.
+		Delete().
+		Where(recoverycode.HasUserWith(user.ID(u.ID))).
+		Exec(ctx)
+	return err
+}
+
+// regenerateRecoveryCodes replaces the recovery codes of the authenticated
+// user, for instance once most are used
+func regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	client := GetClient()
+	regenerate_json, err := decodeJSON[MFAPasswordRequest](w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	user := GetUserFromContext(r.Context())
+	if !hasTOTP(user) {
+		writeError(w, r, apierror.Conflict("Two-factor authentication is not enabled"))
+		return
+	}
+	if err := checkCurrentPassword(w, r, client, user, "password", regenerate_json.Password); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	codes, err := replaceRecoveryCodes(r.Context(), client, user)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, M{"recovery_codes": codes})
+}
+
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func (req *MFALoginRequest) validate(v *validator) {
+	v.required("mfa_token", req.MFAToken != "")
+	if req.Code != "" && req.RecoveryCode != "" {
+		v.add("recovery_code", apierror.FieldInvalid, "must not be given along with code")
+	} else {
+		v.required("code", req.Code != "" || req.RecoveryCode != "")
+	}
+}
+
+// loginMFAHandler completes a login with a TOTP or recovery code, in exchange
+// for the token returned by loginHandler
+func loginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	client := GetClient()
+	mfa_json, err := decodeJSON[MFALoginRequest](w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	invalidToken := apierror.Unauthorized(apierror.CodeInvalidToken, "The login has expired, log in again")
+	jsonToken, err := decryptToken(mfa_json.MFAToken)
+	if err != nil || jsonToken.Get(purposeClaim) != mfaPendingPurpose || jsonToken.Expiration.Before(time.Now()) {
+		writeError(w, r, invalidToken)
+		return
+	}
+	user_id, err := strconv.Atoi(jsonToken.Subject)
+	if err != nil {
+		writeError(w, r, invalidToken)
+		return
+	}
+	// The token of a completed login can't be used again
+	revoked, err := isTokenRevoked(r.Context(), client, jsonToken.Jti)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if revoked {
+		writeError(w, r, invalidToken)
+		return
+	}
+	user, err := client.User.Get(r.Context(), user_id)
+	if ent.IsNotFound(err) {
+		writeError(w, r, invalidToken)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// The second factor was disabled since the password was checked
+	if !hasTOTP(user) {
+		writeError(w, r, invalidToken)
+		return
+	}
+
+	if d := lockedFor(user); d > 0 {
+		writeError(w, r, tooManyRequests(w, apierror.CodeAccountLocked, "Too many failed logins, the account is locked", d))
+		return
+	}
+
+	var ok bool
+	if mfa_json.RecoveryCode != "" {
+		ok, err = useRecoveryCode(r.Context(), client, user, mfa_json.RecoveryCode)
+	} else {
+		ok, err = verifyTOTP(r.Context(), client, user, mfa_json.Code)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !ok {
+		if err := recordFailedLogin(r.Context(), client, user); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeError(w, r, apierror.Unauthorized(apierror.CodeInvalidMFACode, "Invalid two-factor code"))
+		return
+	}
+	if err := resetFailedLogins(r.Context(), client, user); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// Claimed before the tokens are issued, so that of two concurrent logins
+	// with the same token only one succeeds
+	claimed, err := claimToken(r.Context(), client, jsonToken)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !claimed {
+		writeError(w, r, invalidToken)
+		return
+	}
+
+	tokens, err := issueTokenPair(r.Context(), client, user, "", parseScope(jsonToken.Get(scopeClaim)))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"go/djan/app/apierror"
+)
+
+func TestMFATokenIsSingleUse(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	u := client.User.Create().SetName("alice").SetPassword("hash").SetTotpEnabledAt(time.Now()).SaveX(ctx)
+	codes, err := replaceRecoveryCodes(ctx, client, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := client.RecoveryCode.Query().CountX(ctx); n != recoveryCodeCount {
+		t.Fatalf("%d recovery codes", n)
+	}
+	mfaToken, err := issueMFAToken(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := http.HandlerFunc(loginMFAHandler)
+	if code, out := doJSON(t, h, "POST", "/auth/login/mfa/", "", M{"mfa_token": mfaToken, "recovery_code": codes[0]}); code != http.StatusOK || out["token"] == nil {
+		t.Fatalf("got %d %v", code, out)
+	}
+	if code, out := doJSON(t, h, "POST", "/auth/login/mfa/", "", M{"mfa_token": mfaToken, "recovery_code": codes[1]}); code != http.StatusUnauthorized || out["code"] != apierror.CodeInvalidToken {
+		t.Errorf("second login with the token: got %d %v", code, out)
+	}
+}
+
+func TestMFATokenConcurrentLogins(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	u := client.User.Create().SetName("alice").SetPassword("hash").SetTotpEnabledAt(time.Now()).SaveX(ctx)
+	codes, err := replaceRecoveryCodes(ctx, client, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfaToken, err := issueMFAToken(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each login uses its own recovery code, so only the token is shared
+	statuses := make([]int, 4)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range statuses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			statuses[i], _ = doJSON(t, http.HandlerFunc(loginMFAHandler), "POST", "/auth/login/mfa/", "", M{"mfa_token": mfaToken, "recovery_code": codes[i]})
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	succeeded := 0
+	for _, status := range statuses {
+		switch status {
+		case http.StatusOK:
+			succeeded++
+		case http.StatusUnauthorized:
+		default:
+			t.Errorf("got status %d", status)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d logins succeeded, want 1", succeeded)
+	}
+	if n := client.RefreshToken.Query().CountX(ctx); n != 1 {
+		t.Errorf("%d refresh tokens issued, want 1", n)
+	}
+}
+
+func TestEnrollTOTPWithoutKey(t *testing.T) {
+	newTestClient(t)
+	config.Auth.TOTPKey = nil
+
+	for path, h := range map[string]http.HandlerFunc{
+		"/api/user/me/mfa/totp":         enrollTOTP,
+		"/api/user/me/mfa/totp/confirm": confirmTOTP,
+	} {
+		if code, out := doJSON(t, h, "POST", path, "", M{"code": "123456"}); code != http.StatusNotFound || out["code"] != apierror.CodeNotFound {
+			t.Errorf("%s: got %d %v", path, code, out)
+		}
+	}
+}
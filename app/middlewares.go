@@ -2,13 +2,13 @@ package main
 
 import (
 	"context"
-	"log"
+	"go/djan/app/apierror"
+	"go/djan/app/ent"
+	"go/djan/app/ent/user"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
-
-	"github.com/o1egl/paseto"
 )
 
 type Middleware func(http.Handler) http.Handler
@@ -23,92 +23,149 @@ func createStack(xs ...Middleware) Middleware {
 	}
 }
 
+// WrappedWriter records the status code and size of responses
 type WrappedWriter struct {
 	http.ResponseWriter
-	statusCode int
+	statusCode  int
+	bytes       int
+	wroteHeader bool
 }
 
 func (w *WrappedWriter) WriteHeader(statusCode int) {
 	w.ResponseWriter.WriteHeader(statusCode)
 	w.statusCode = statusCode
+	w.wroteHeader = true
 }
 
-func logging(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
+func (w *WrappedWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	w.wroteHeader = true
+	return n, err
+}
 
-		wrappedWriter := &WrappedWriter{
-			ResponseWriter: w,
-			statusCode:     http.StatusAccepted,
-		}
-		next.ServeHTTP(wrappedWriter, r)
-		log.Println(wrappedWriter.statusCode, r.Method, r.URL.Path, time.Since(start))
-	})
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *WrappedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
 
 func authenticateUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tokenString := r.Header.Get("Authorization")
-		if tokenString == "" {
-			writeJSON(w, http.StatusUnauthorized, M{"error": "Authorization header missing"})
+		// Machine clients authenticate with an API key instead of a token
+		if key, ok := apiKeyFromRequest(r); ok {
+			apiKey, jsonToken, err := authenticateAPIKey(r.Context(), GetClient(), key)
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			addLogAttrs(r.Context(), "user_id", apiKey.Edges.User.ID, "api_key_id", apiKey.ID)
+			ctx := context.WithValue(r.Context(), userContextKey, apiKey.Edges.User)
+			ctx = context.WithValue(ctx, tokenContextKey, jsonToken)
+			ctx = context.WithValue(ctx, apiKeyContextKey, apiKey)
+			next.ServeHTTP(w, r.WithContext(ctx))
 			return
 		}
 
-		// Remove "Bearer " prefix if present else raise error
-		bearerPrefix := "Bearer "
-		if len(tokenString) > len(bearerPrefix) && strings.HasPrefix(tokenString, bearerPrefix) {
-			tokenString = tokenString[len(bearerPrefix):]
-		} else {
-			writeJSON(w, http.StatusUnauthorized, M{"error": "Invalid token"})
+		tokenString, err := bearerToken(r)
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
 
-		var jsonToken paseto.JSONToken
-		var footer string
-		pasetoToken := paseto.NewV2()
-
-		if err := pasetoToken.Decrypt(tokenString, pasetoKey, &jsonToken, &footer); err != nil {
-			writeJSON(w, http.StatusUnauthorized, M{"error": "Invalid token"})
+		jsonToken, err := parseAccessToken(tokenString)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		// Tokens issued for another purpose are not access tokens
+		if jsonToken.Get(purposeClaim) != "" {
+			writeError(w, r, errInvalidToken)
 			return
 		}
 
 		// Check token expiration
 		if jsonToken.Expiration.Before(time.Now()) {
-			http.Redirect(w, r, "/auth/login/", http.StatusUnauthorized)
+			writeError(w, r, apierror.Unauthorized(apierror.CodeTokenExpired, "Token has expired"))
+			return
+		}
+
+		client := GetClient()
+
+		// Reject tokens which were signed out
+		revoked, err := isTokenRevoked(r.Context(), client, jsonToken.Jti)
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
+		if revoked {
+			writeError(w, r, apierror.Unauthorized(apierror.CodeTokenRevoked, "Token has been revoked"))
+			return
+		}
+
 		user_id_string := jsonToken.Subject
 		user_id, err := strconv.Atoi(user_id_string)
 		if err != nil {
-			writeJSON(w, http.StatusUnauthorized, M{"error": err.Error()})
+			writeError(w, r, errInvalidToken)
 			return
 		}
-		// Fetch the user from the database
-		client := GetClient()
 
-		user, err := client.User.Get(context.Background(), user_id)
+		// Fetch the user from the database
+		user, err := client.User.Get(r.Context(), user_id)
+		if ent.IsNotFound(err) {
+			writeError(w, r, apierror.Unauthorized(apierror.CodeInvalidToken, "User of the token no longer exists"))
+			return
+		}
 		if err != nil {
-			writeJSON(w, http.StatusUnauthorized, M{"error": "User Not Found"})
+			writeError(w, r, err)
 			return
 		}
 
-		// Attach the user to the request context
+		// Tokens issued before the password changed belong to ended sessions.
+		// Tokens only carry whole seconds, those of the second of the change
+		// are accepted so that the new session works right away.
+		if user.PasswordChangedAt != nil && jsonToken.IssuedAt.Before(user.PasswordChangedAt.Truncate(time.Second)) {
+			writeError(w, r, apierror.Unauthorized(apierror.CodeTokenRevoked, "Token was issued before the password changed, log in again"))
+			return
+		}
+
+		addLogAttrs(r.Context(), "user_id", user.ID)
+
+		// Attach the user and its token to the request context
 		ctx := context.WithValue(r.Context(), userContextKey, user)
+		ctx = context.WithValue(ctx, tokenContextKey, jsonToken)
 
 		// Pass the request to the next handler
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-var allowedOrigins = []string{
-	"http://localhost",
-	"http://127.0.0.1",
-	"https://localhost",
-	"https://127.0.0.1",
+// requireRoles only lets through requests of users holding one of the given
+// roles. The stored role is checked rather than the claim of the token, so
+// that a revoked role takes effect before the token expires. It must be
+// wrapped by authenticateUser.
+func requireRoles(roles ...user.Role) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			actor := GetUserFromContext(r.Context())
+			if actor == nil {
+				writeError(w, r, apierror.Unauthorized(apierror.CodeUnauthorized, "Authentication required"))
+				return
+			}
+
+			role := effectiveRole(actor)
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			forbidden(w, r)
+		})
+	}
 }
 
 // CORS middleware to handle CORS and set headers
-// We are allowing only local requests currently
+// Only the origins of CORS_ALLOWED_ORIGINS are allowed, local requests by default
 func CORSMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -116,7 +173,7 @@ func CORSMiddleware(next http.Handler) http.Handler {
 
 		// Check if the origin is in the list of allowed origins
 		allowed := false
-		for _, allowedOrigin := range allowedOrigins {
+		for _, allowedOrigin := range config.CORS.AllowedOrigins {
 			if strings.HasPrefix(origin, allowedOrigin) {
 				allowed = true
 				break
@@ -127,11 +184,11 @@ func CORSMiddleware(next http.Handler) http.Handler {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 		} else {
 			// Deny the request if the origin is not allowed
-			writeJSON(w, http.StatusForbidden, M{"error": "Cross Origin Forbidden"})
+			writeError(w, r, apierror.New(http.StatusForbidden, apierror.CodeOriginNotAllowed, "Cross Origin Forbidden"))
 			return
 		}
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 		// OPTIONS Header is used just to give the headers so returning it here only
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"go/djan/app/ent/migrate"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	atlasmigrate "ariga.io/atlas/sql/migrate"
+	"ariga.io/atlas/sql/sqltool"
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql/schema"
+)
+
+const (
+	// migrationsTable records the migrations applied to the database
+	migrationsTable = "schema_migrations"
+	// migrationsLockID is the key of the advisory lock held while migrating,
+	// so that instances started together do not apply the same migrations
+	migrationsLockID = 0x6d69677261746521
+)
+
+// migration is a versioned SQL migration of the migrations directory, in the
+// golang-migrate format: <version>_<name>.up.sql and <version>_<name>.down.sql
+type migration struct {
+	Version string
+	Name    string
+	up      atlasmigrate.File
+}
+
+type appliedMigration struct {
+	Version   string
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+type migrationStatus struct {
+	migration
+	Applied *appliedMigration
+}
+
+type migrator struct {
+	db  *sql.DB
+	dir *sqltool.GolangMigrateDir
+}
+
+// newMigrator opens the migrations directory and checks it against its
+// atlas.sum file, which catches migrations edited after being generated
+func newMigrator(db *sql.DB, path string) (*migrator, error) {
+	dir, err := sqltool.NewGolangMigrateDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening migrations directory: %w", err)
+	}
+	if err := atlasmigrate.Validate(dir); err != nil {
+		return nil, fmt.Errorf("migrations directory %s does not match atlas.sum, run \"migrate hash\" after editing migrations: %w", path, err)
+	}
+	return &migrator{db: db, dir: dir}, nil
+}
+
+func (m *migrator) migrations() ([]migration, error) {
+	files, err := m.dir.Files()
+	if err != nil {
+		return nil, err
+	}
+	migrations := make([]migration, len(files))
+	for i, f := range files {
+		migrations[i] = migration{Version: f.Version(), Name: f.Desc(), up: f}
+	}
+	return migrations, nil
+}
+
+// latest returns the version of the last migration of the directory
+func (m *migrator) latest() (string, error) {
+	migrations, err := m.migrations()
+	if err != nil || len(migrations) == 0 {
+		return "", err
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// version returns the version of the last migration applied to the database.
+// Unlike status it neither takes the migrations lock nor creates the table.
+func (m *migrator) version(ctx context.Context) (string, error) {
+	var version sql.NullString
+	err := m.db.QueryRowContext(ctx, "SELECT max(version) FROM "+migrationsTable).Scan(&version)
+	return version.String, err
+}
+
+func (mg migration) checksum() string {
+	sum := sha256.Sum256(mg.up.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+func (mg migration) downFile() string {
+	return mg.Version + "_" + mg.Name + ".down.sql"
+}
+
+// withLock runs fn on a connection holding the migrations lock
+func (m *migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationsLockID); err != nil {
+		return fmt.Errorf("error acquiring migrations lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationsLockID)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+migrationsTable+` (
+		version character varying NOT NULL PRIMARY KEY,
+		name character varying NOT NULL,
+		checksum character varying NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating %s table: %w", migrationsTable, err)
+	}
+	return fn(conn)
+}
+
+func applied(ctx context.Context, conn *sql.Conn) (map[string]*appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM "+migrationsTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[string]*appliedMigration{}
+	for rows.Next() {
+		a := &appliedMigration{}
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[a.Version] = a
+	}
+	return applied, rows.Err()
+}
+
+// status lists the migrations of the directory along with their state. It
+// fails if an applied migration was modified or removed from the directory.
+func (m *migrator) status(ctx context.Context, conn *sql.Conn) ([]migrationStatus, error) {
+	migrations, err := m.migrations()
+	if err != nil {
+		return nil, err
+	}
+	done, err := applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]migrationStatus, len(migrations))
+	var errs []error
+	for i, mg := range migrations {
+		statuses[i] = migrationStatus{migration: mg, Applied: done[mg.Version]}
+		if a := done[mg.Version]; a != nil {
+			if a.Checksum != mg.checksum() {
+				errs = append(errs, fmt.Errorf("migration %s_%s was modified after being applied", mg.Version, mg.Name))
+			}
+			delete(done, mg.Version)
+		}
+	}
+	for _, a := range done {
+		errs = append(errs, fmt.Errorf("applied migration %s_%s is missing from the migrations directory", a.Version, a.Name))
+	}
+	return statuses, errors.Join(errs...)
+}
+
+// runMigration runs the statements of a migration file and records the change in a
+// single transaction
+func runMigration(ctx context.Context, conn *sql.Conn, file atlasmigrate.File, record string, args ...any) error {
+	stmts, err := file.Stmts()
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %w", file.Name(), err)
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error running %s: %w\n%s", file.Name(), err, stmt)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// up applies the pending migrations in version order, at most n if n > 0
+func (m *migrator) up(ctx context.Context, n int) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			if s.Applied != nil {
+				continue
+			}
+			if n > 0 && count == n {
+				break
+			}
+			err := runMigration(ctx, conn, s.up,
+				"INSERT INTO "+migrationsTable+" (version, name, checksum) VALUES ($1, $2, $3)",
+				s.Version, s.Name, s.checksum())
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// down reverts the last n applied migrations
+func (m *migrator) down(ctx context.Context, n int) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(statuses) - 1; i >= 0 && count < n; i-- {
+			s := statuses[i]
+			if s.Applied == nil {
+				continue
+			}
+			b, err := fs.ReadFile(m.dir, s.downFile())
+			if err != nil {
+				return fmt.Errorf("migration %s_%s can not be reverted: %w", s.Version, s.Name, err)
+			}
+			err = runMigration(ctx, conn, atlasmigrate.NewLocalFile(s.downFile(), b),
+				"DELETE FROM "+migrationsTable+" WHERE version = $1", s.Version)
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// baseline marks the migrations up to the given version as applied without
+// running them, for databases created before versioned migrations
+func (m *migrator) baseline(ctx context.Context, version string) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			if s.Applied != nil {
+				return fmt.Errorf("migrations were applied already, baseline is only for databases without any")
+			}
+		}
+		for _, s := range statuses {
+			if s.Version > version {
+				break
+			}
+			_, err := conn.ExecContext(ctx,
+				"INSERT INTO "+migrationsTable+" (version, name, checksum) VALUES ($1, $2, $3)",
+				s.Version, s.Name, s.checksum())
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// pending returns the number of migrations not applied yet
+func (m *migrator) pending(ctx context.Context) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		for _, s := range statuses {
+			if s.Applied == nil {
+				count++
+			}
+		}
+		return err
+	})
+	return count, err
+}
+
+// diff writes a new migration with the changes between the migrations
+// directory and the ent schema. The directory is replayed on the dev
+// database, which must be empty.
+func (m *migrator) diff(ctx context.Context, devURL, name string) error {
+	if devURL == "" {
+		return errors.New("MIGRATE_DEV_URL is required to compute the schema changes")
+	}
+	opts := append([]schema.MigrateOption{
+		schema.WithDir(m.dir),
+		schema.WithMigrationMode(schema.ModeReplay),
+		schema.WithDialect(dialect.Postgres),
+		schema.WithFormatter(sqltool.GolangMigrateFormatter),
+		schema.WithDropColumn(true),
+		schema.WithDropIndex(true),
+		schema.WithErrNoPlan(true),
+	}, migrateOptions()...)
+	return migrate.NamedDiff(ctx, devURL, name, opts...)
+}
+
+// create writes an empty migration, for data migrations and changes which
+// can not be generated from the ent schema
+func (m *migrator) create(name string) (string, error) {
+	version := time.Now().UTC().Format("20060102150405")
+	base := version + "_" + name
+	if err := m.dir.WriteFile(base+".up.sql", nil); err != nil {
+		return "", err
+	}
+	if err := m.dir.WriteFile(base+".down.sql", nil); err != nil {
+		return "", err
+	}
+	return base, m.hash()
+}
+
+// hash rewrites atlas.sum after migrations were written by hand
+func (m *migrator) hash() error {
+	sum, err := m.dir.Checksum()
+	if err != nil {
+		return err
+	}
+	return atlasmigrate.WriteSumFile(m.dir, sum)
+}
+
+const migrateUsage = `usage: migrate <command> [arguments]
+
+commands:
+  up [n]             apply the pending migrations, or the next n
+  down [n]           revert the last migration, or the last n
+  status             list the migrations and whether they are applied
+  diff <name>        generate a migration from the changes of the ent schema
+  new <name>         create an empty migration to write by hand
+  hash               update atlas.sum after editing migrations
+  baseline <version> mark the migrations up to version as applied, for
+                     databases created by the former auto migration
+`
+
+// migrateCommand runs the migrate subcommand
+func migrateCommand(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fset.Usage = func() { fmt.Fprint(fset.Output(), migrateUsage) }
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() == 0 {
+		fset.Usage()
+		return errors.New("missing migrate command")
+	}
+	command, args := fset.Arg(0), fset.Args()[1:]
+
+	if command == "hash" {
+		dir, err := sqltool.NewGolangMigrateDir(config.Database.MigrationsDir)
+		if err != nil {
+			return err
+		}
+		return (&migrator{dir: dir}).hash()
+	}
+	// Commands which only work on the directory need no database
+	var db *sql.DB
+	if command != "new" && command != "diff" {
+		db = GetDB()
+	}
+	m, err := newMigrator(db, config.Database.MigrationsDir)
+	if err != nil {
+		return err
+	}
+
+	count := func() (int, error) {
+		if len(args) == 0 {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("n must be a positive integer")
+		}
+		return n, nil
+	}
+	name := func() (string, error) {
+		if len(args) == 0 || strings.ContainsAny(args[0], " /.") {
+			return "", fmt.Errorf("migrate %s requires a name, without spaces, slashes or dots", command)
+		}
+		return args[0], nil
+	}
+
+	switch command {
+	case "up":
+		n, err := count()
+		if err != nil {
+			return err
+		}
+		applied, err := m.up(ctx, n)
+		fmt.Printf("Applied %d migrations\n", applied)
+		return err
+
+	case "down":
+		n, err := count()
+		if err != nil {
+			return err
+		}
+		reverted, err := m.down(ctx, max(n, 1))
+		fmt.Printf("Reverted %d migrations\n", reverted)
+		return err
+
+	case "status":
+		var statuses []migrationStatus
+		err := m.withLock(ctx, func(conn *sql.Conn) error {
+			var err error
+			statuses, err = m.status(ctx, conn)
+			return err
+		})
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.Applied != nil {
+				appliedAt = s.Applied.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		w.Flush()
+		return err
+
+	case "diff":
+		name, err := name()
+		if err != nil {
+			return err
+		}
+		err = m.diff(ctx, config.Database.MigrateDevURL, name)
+		if errors.Is(err, atlasmigrate.ErrNoPlan) {
+			fmt.Println("The migrations are in sync with the ent schema")
+			return nil
+		}
+		return err
+
+	case "new":
+		name, err := name()
+		if err != nil {
+			return err
+		}
+		base, err := m.create(name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created %s.up.sql and %s.down.sql\n", base, base)
+		return nil
+
+	case "baseline":
+		if len(args) == 0 {
+			return errors.New("migrate baseline requires a version")
+		}
+		marked, err := m.baseline(ctx, args[0])
+		fmt.Printf("Marked %d migrations as applied\n", marked)
+		return err
+
+	default:
+		fset.Usage()
+		return fmt.Errorf("unknown migrate command %q", command)
+	}
+}
+
+// autoMigrate applies the pending migrations at boot, or only warns about
+// them when auto migration is disabled
+func autoMigrate(ctx context.Context, m *migrator, enabled bool) error {
+	if !enabled {
+		n, err := m.pending(ctx)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			slog.Warn("migrations are pending, run \"migrate up\" to apply them", "count", n)
+		}
+		return nil
+	}
+	n, err := m.up(ctx, 0)
+	if n > 0 {
+		slog.Info("applied migrations", "count", n)
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+-- reverse: create "user_friends" table
+DROP TABLE "user_friends";
+-- reverse: create "tag_blogs" table
+DROP TABLE "tag_blogs";
+-- reverse: create index "tags_name_key" to table: "tags"
+DROP INDEX "tags_name_key";
+-- reverse: create "tags" table
+DROP TABLE "tags";
+-- reverse: create index "refreshtoken_expires_at" to table: "refresh_tokens"
+DROP INDEX "refreshtoken_expires_at";
+-- reverse: create index "refreshtoken_family" to table: "refresh_tokens"
+DROP INDEX "refreshtoken_family";
+-- reverse: create index "refresh_tokens_token_hash_key" to table: "refresh_tokens"
+DROP INDEX "refresh_tokens_token_hash_key";
+-- reverse: create "refresh_tokens" table
+DROP TABLE "refresh_tokens";
+-- reverse: create index "blog_search_vector" to table: "blogs"
+DROP INDEX "blog_search_vector";
+-- reverse: create "blogs" table
+DROP TABLE "blogs";
+-- reverse: create "users" table
+DROP TABLE "users";
+-- reverse: create index "revokedtoken_expires_at" to table: "revoked_tokens"
+DROP INDEX "revokedtoken_expires_at";
+-- reverse: create index "revoked_tokens_jti_key" to table: "revoked_tokens"
+DROP INDEX "revoked_tokens_jti_key";
+-- reverse: create "revoked_tokens" table
+DROP TABLE "revoked_tokens";
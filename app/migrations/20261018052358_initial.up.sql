@@ -0,0 +1,28 @@
+-- create "revoked_tokens" table
+CREATE TABLE "revoked_tokens" ("id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY, "jti" character varying NOT NULL, "expires_at" timestamptz NOT NULL, "created_at" timestamptz NOT NULL, PRIMARY KEY ("id"));
+-- create index "revoked_tokens_jti_key" to table: "revoked_tokens"
+CREATE UNIQUE INDEX "revoked_tokens_jti_key" ON "revoked_tokens" ("jti");
+-- create index "revokedtoken_expires_at" to table: "revoked_tokens"
+CREATE INDEX "revokedtoken_expires_at" ON "revoked_tokens" ("expires_at");
+-- create "users" table
+CREATE TABLE "users" ("id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY, "name" character varying NOT NULL, "password" character varying NOT NULL DEFAULT 'QWERTYUIO', "age" bigint NULL DEFAULT 1, "is_active" boolean NOT NULL DEFAULT true, "is_superuser" boolean NOT NULL DEFAULT false, "role" character varying NOT NULL DEFAULT 'reader', "created_at" timestamptz NOT NULL, PRIMARY KEY ("id"));
+-- create "blogs" table
+CREATE TABLE "blogs" ("id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY, "title" character varying NOT NULL, "description" character varying NOT NULL, "episode" bigint NULL, "created_at" timestamptz NOT NULL, "search_vector" tsvector NULL GENERATED ALWAYS AS (setweight(to_tsvector('english', coalesce(title, '')), 'A') || setweight(to_tsvector('english', coalesce(description, '')), 'B')) STORED, "user_blogs" bigint NULL, PRIMARY KEY ("id"), CONSTRAINT "blogs_users_blogs" FOREIGN KEY ("user_blogs") REFERENCES "users" ("id") ON DELETE SET NULL);
+-- create index "blog_search_vector" to table: "blogs"
+CREATE INDEX "blog_search_vector" ON "blogs" USING GIN ("search_vector");
+-- create "refresh_tokens" table
+CREATE TABLE "refresh_tokens" ("id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY, "token_hash" character varying NOT NULL, "family" character varying NOT NULL, "expires_at" timestamptz NOT NULL, "used_at" timestamptz NULL, "revoked" boolean NOT NULL DEFAULT false, "created_at" timestamptz NOT NULL, "user_refresh_tokens" bigint NOT NULL, PRIMARY KEY ("id"), CONSTRAINT "refresh_tokens_users_refresh_tokens" FOREIGN KEY ("user_refresh_tokens") REFERENCES "users" ("id") ON DELETE CASCADE);
+-- create index "refresh_tokens_token_hash_key" to table: "refresh_tokens"
+CREATE UNIQUE INDEX "refresh_tokens_token_hash_key" ON "refresh_tokens" ("token_hash");
+-- create index "refreshtoken_family" to table: "refresh_tokens"
+CREATE INDEX "refreshtoken_family" ON "refresh_tokens" ("family");
+-- create index "refreshtoken_expires_at" to table: "refresh_tokens"
+CREATE INDEX "refreshtoken_expires_at" ON "refresh_tokens" ("expires_at");
+-- create "tags" table
+CREATE TABLE "tags" ("id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY, "name" character varying NOT NULL, "type" character varying NOT NULL DEFAULT 'Common', "category" character varying NULL, PRIMARY KEY ("id"));
+-- create index "tags_name_key" to table: "tags"
+CREATE UNIQUE INDEX "tags_name_key" ON "tags" ("name");
+-- create "tag_blogs" table
+CREATE TABLE "tag_blogs" ("tag_id" bigint NOT NULL, "blog_id" bigint NOT NULL, PRIMARY KEY ("tag_id", "blog_id"), CONSTRAINT "tag_blogs_tag_id" FOREIGN KEY ("tag_id") REFERENCES "tags" ("id") ON DELETE CASCADE, CONSTRAINT "tag_blogs_blog_id" FOREIGN KEY ("blog_id") REFERENCES "blogs" ("id") ON DELETE CASCADE);
+-- create "user_friends" table
+CREATE TABLE "user_friends" ("user_id" bigint NOT NULL, "friend_id" bigint NOT NULL, PRIMARY KEY ("user_id", "friend_id"), CONSTRAINT "user_friends_user_id" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE, CONSTRAINT "user_friends_friend_id" FOREIGN KEY ("friend_id") REFERENCES "users" ("id") ON DELETE CASCADE);
@@ -0,0 +1,8 @@
+-- reverse: create index "passwordreset_expires_at" to table: "password_resets"
+DROP INDEX "passwordreset_expires_at";
+-- reverse: create index "password_resets_token_hash_key" to table: "password_resets"
+DROP INDEX "password_resets_token_hash_key";
+-- reverse: create "password_resets" table
+DROP TABLE "password_resets";
+-- reverse: modify "users" table
+ALTER TABLE "users" DROP COLUMN "password_changed_at", DROP COLUMN "email", ALTER COLUMN "password" SET DEFAULT 'QWERTYUIO';
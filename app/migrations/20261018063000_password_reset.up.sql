@@ -0,0 +1,8 @@
+-- modify "users" table
+ALTER TABLE "users" ALTER COLUMN "password" DROP DEFAULT, ADD COLUMN "email" character varying NULL, ADD COLUMN "password_changed_at" timestamptz NULL;
+-- create "password_resets" table
+CREATE TABLE "password_resets" ("id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY, "token_hash" character varying NOT NULL, "expires_at" timestamptz NOT NULL, "used_at" timestamptz NULL, "created_at" timestamptz NOT NULL, "user_password_resets" bigint NOT NULL, PRIMARY KEY ("id"), CONSTRAINT "password_resets_users_password_resets" FOREIGN KEY ("user_password_resets") REFERENCES "users" ("id") ON DELETE CASCADE);
+-- create index "password_resets_token_hash_key" to table: "password_resets"
+CREATE UNIQUE INDEX "password_resets_token_hash_key" ON "password_resets" ("token_hash");
+-- create index "passwordreset_expires_at" to table: "password_resets"
+CREATE INDEX "passwordreset_expires_at" ON "password_resets" ("expires_at");
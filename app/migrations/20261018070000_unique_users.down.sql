@@ -0,0 +1,6 @@
+-- reverse: create index "users_email_key" to table: "users"
+DROP INDEX "users_email_key";
+-- reverse: create index "users_name_key" to table: "users"
+DROP INDEX "users_name_key";
+-- reverse: modify "users" table
+ALTER TABLE "users" DROP COLUMN "email_verified_at", ALTER COLUMN "email" TYPE character varying, ALTER COLUMN "name" TYPE character varying;
@@ -0,0 +1,33 @@
+-- This migration marks the existing users without an address as verified: they
+-- predate email verification and keep writing. Users with an address have to
+-- verify it, as reset links and single sign-on trust verified addresses.
+-- names and emails are compared regardless of case
+CREATE EXTENSION IF NOT EXISTS citext;
+-- users sharing a name, but the first one, get their id appended to it, and a
+-- counter as long as another user holds that name already, such as "bob_7"
+DO $$
+DECLARE
+  u record;
+  candidate text;
+  n int;
+BEGIN
+  FOR u IN SELECT "id", "name" FROM "users" WHERE "id" NOT IN (SELECT min("id") FROM "users" GROUP BY lower("name")) ORDER BY "id" LOOP
+    candidate := u."name" || '_' || u."id";
+    n := 1;
+    WHILE EXISTS (SELECT 1 FROM "users" WHERE lower("name") = lower(candidate)) LOOP
+      n := n + 1;
+      candidate := u."name" || '_' || u."id" || '_' || n;
+    END LOOP;
+    UPDATE "users" SET "name" = candidate WHERE "id" = u."id";
+  END LOOP;
+END $$;
+-- users sharing an email address, but the first one, lose it
+UPDATE "users" SET "email" = NULL WHERE "email" IS NOT NULL AND "id" NOT IN (SELECT min("id") FROM "users" WHERE "email" IS NOT NULL GROUP BY lower("email"));
+-- modify "users" table
+ALTER TABLE "users" ALTER COLUMN "name" TYPE citext, ALTER COLUMN "email" TYPE citext, ADD COLUMN "email_verified_at" timestamptz NULL;
+-- existing users without an address predate email verification, they are marked verified
+UPDATE "users" SET "email_verified_at" = now() WHERE "email" IS NULL;
+-- create index "users_name_key" to table: "users"
+CREATE UNIQUE INDEX "users_name_key" ON "users" ("name");
+-- create index "users_email_key" to table: "users"
+CREATE UNIQUE INDEX "users_email_key" ON "users" ("email");
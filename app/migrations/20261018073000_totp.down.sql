@@ -0,0 +1,6 @@
+-- reverse: create index "recovery_codes_code_hash_key" to table: "recovery_codes"
+DROP INDEX "recovery_codes_code_hash_key";
+-- reverse: create "recovery_codes" table
+DROP TABLE "recovery_codes";
+-- reverse: modify "users" table
+ALTER TABLE "users" DROP COLUMN "totp_last_step", DROP COLUMN "totp_enabled_at", DROP COLUMN "totp_secret";
@@ -0,0 +1,6 @@
+-- modify "users" table
+ALTER TABLE "users" ADD COLUMN "totp_secret" bytea NULL, ADD COLUMN "totp_enabled_at" timestamptz NULL, ADD COLUMN "totp_last_step" bigint NOT NULL DEFAULT 0;
+-- create "recovery_codes" table
+CREATE TABLE "recovery_codes" ("id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY, "code_hash" character varying NOT NULL, "used_at" timestamptz NULL, "created_at" timestamptz NOT NULL, "user_recovery_codes" bigint NOT NULL, PRIMARY KEY ("id"), CONSTRAINT "recovery_codes_users_recovery_codes" FOREIGN KEY ("user_recovery_codes") REFERENCES "users" ("id") ON DELETE CASCADE);
+-- create index "recovery_codes_code_hash_key" to table: "recovery_codes"
+CREATE UNIQUE INDEX "recovery_codes_code_hash_key" ON "recovery_codes" ("code_hash");
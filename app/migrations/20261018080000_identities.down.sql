@@ -0,0 +1,4 @@
+-- reverse: create index "identity_issuer_subject" to table: "identities"
+DROP INDEX "identity_issuer_subject";
+-- reverse: create "identities" table
+DROP TABLE "identities";
@@ -0,0 +1,4 @@
+-- create "identities" table
+CREATE TABLE "identities" ("id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY, "issuer" character varying NOT NULL, "subject" character varying NOT NULL, "email" character varying NULL, "created_at" timestamptz NOT NULL, "last_login_at" timestamptz NULL, "user_identities" bigint NOT NULL, PRIMARY KEY ("id"), CONSTRAINT "identities_users_identities" FOREIGN KEY ("user_identities") REFERENCES "users" ("id") ON DELETE CASCADE);
+-- create index "identity_issuer_subject" to table: "identities"
+CREATE UNIQUE INDEX "identity_issuer_subject" ON "identities" ("issuer", "subject");
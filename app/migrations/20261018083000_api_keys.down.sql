@@ -0,0 +1,4 @@
+-- reverse: create index "api_keys_key_hash_key" to table: "api_keys"
+DROP INDEX "api_keys_key_hash_key";
+-- reverse: create "api_keys" table
+DROP TABLE "api_keys";
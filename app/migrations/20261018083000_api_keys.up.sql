@@ -0,0 +1,4 @@
+-- create "api_keys" table
+CREATE TABLE "api_keys" ("id" bigint NOT NULL GENERATED BY DEFAULT AS IDENTITY, "name" character varying NOT NULL, "prefix" character varying NOT NULL, "key_hash" character varying NOT NULL, "scopes" jsonb NULL, "expires_at" timestamptz NULL, "last_used_at" timestamptz NULL, "created_at" timestamptz NOT NULL, "user_api_keys" bigint NOT NULL, PRIMARY KEY ("id"), CONSTRAINT "api_keys_users_api_keys" FOREIGN KEY ("user_api_keys") REFERENCES "users" ("id") ON DELETE CASCADE);
+-- create index "api_keys_key_hash_key" to table: "api_keys"
+CREATE UNIQUE INDEX "api_keys_key_hash_key" ON "api_keys" ("key_hash");
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"go/djan/app/apierror"
+	"go/djan/app/ent"
+	"go/djan/app/ent/identity"
+	"go/djan/app/ent/schema"
+	"go/djan/app/ent/user"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/o1egl/paseto"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// oidcStatePurpose is the purpose of the tokens carrying the state of an
+	// OpenID Connect login between its two steps
+	oidcStatePurpose  = "oidc_state"
+	oidcStateClaim    = "state"
+	oidcNonceClaim    = "nonce"
+	oidcVerifierClaim = "verifier"
+)
+
+var errOIDCDisabled = apierror.NotFound("OpenID Connect login is not configured")
+
+// oidcProvider is discovered on first use, so that the API starts while the
+// provider is down. Failed discoveries are retried by the next login.
+var oidcProvider struct {
+	sync.Mutex
+	provider *oidc.Provider
+}
+
+func getOIDCProvider(ctx context.Context) (*oidc.Provider, error) {
+	oidcProvider.Lock()
+	defer oidcProvider.Unlock()
+	if oidcProvider.provider != nil {
+		return oidcProvider.provider, nil
+	}
+	// The keys of the provider are fetched later with the context of the
+	// discovery, which must outlive the request
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	provider, err := oidc.NewProvider(ctx, config.OIDC.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	oidcProvider.provider = provider
+	return provider, nil
+}
+
+func oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     config.OIDC.ClientID,
+		ClientSecret: config.OIDC.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  config.OIDC.RedirectURL,
+		Scopes:       config.OIDC.Scopes,
+	}
+}
+
+// oidcLogin starts a login with the provider. The front end keeps the session
+// token and redirects the user to the authorization URL, the provider then
+// sends the user back to OIDC_REDIRECT_URL with a code and the state.
+func oidcLogin(w http.ResponseWriter, r *http.Request) {
+	if config.OIDC.Issuer == "" {
+		writeError(w, r, errOIDCDisabled)
+		return
+	}
+	provider, err := getOIDCProvider(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	state, err := newOpaqueToken()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	nonce, err := newOpaqueToken()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	// The verifier must not travel along the code, so it is kept by the front
+	// end in the session token, encrypted whatever PASETO_MODE, rather than in
+	// the state
+	jti, err := newTokenID()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	now := time.Now()
+	jsonToken := paseto.JSONToken{
+		Jti:        jti,
+		IssuedAt:   now,
+		Expiration: now.Add(config.OIDC.StateLifetime),
+	}
+	jsonToken.Set(purposeClaim, oidcStatePurpose)
+	jsonToken.Set(oidcStateClaim, state)
+	jsonToken.Set(oidcNonceClaim, nonce)
+	jsonToken.Set(oidcVerifierClaim, verifier)
+	session, err := encryptToken(jsonToken)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	authURL := oauth2Config(provider).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	writeJSON(w, http.StatusOK, M{
+		"authorization_url": authURL,
+		"state":             state,
+		"session":           session,
+		"expires_in":        int(config.OIDC.StateLifetime.Seconds()),
+	})
+}
+
+type OIDCCallbackRequest struct {
+	Code    string `json:"code"`
+	State   string `json:"state"`
+	Session string `json:"session"`
+}
+
+func (req *OIDCCallbackRequest) validate(v *validator) {
+	v.required("code", req.Code != "")
+	v.required("state", req.State != "")
+	v.required("session", req.Session != "")
+}
+
+// oidcClaims are the claims of the ID token used to link or create the user
+type oidcClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// oidcCallback completes a login with the code returned by the provider,
+// logging in the user linked to the identity like loginHandler does
+func oidcCallback(w http.ResponseWriter, r *http.Request) {
+	if config.OIDC.Issuer == "" {
+		writeError(w, r, errOIDCDisabled)
+		return
+	}
+	client := GetClient()
+	callback_json, err := decodeJSON[OIDCCallbackRequest](w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	invalidState := apierror.Unauthorized(apierror.CodeInvalidToken, "The login is invalid or has expired, log in again")
+	session, err := decryptToken(callback_json.Session)
+	if err != nil || session.Get(purposeClaim) != oidcStatePurpose || session.Expiration.Before(time.Now()) {
+		writeError(w, r, invalidState)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(session.Get(oidcStateClaim)), []byte(callback_json.State)) != 1 {
+		writeError(w, r, invalidState)
+		return
+	}
+	// Each session completes one login at most, even when the same code is
+	// posted twice concurrently
+	claimed, err := claimToken(r.Context(), client, session)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !claimed {
+		writeError(w, r, invalidState)
+		return
+	}
+
+	provider, err := getOIDCProvider(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	logger := loggerFromContext(r.Context())
+	rejected := apierror.Unauthorized(apierror.CodeInvalidCredentials, "The identity provider did not confirm the login")
+	token, err := oauth2Config(provider).Exchange(r.Context(), callback_json.Code, oauth2.VerifierOption(session.Get(oidcVerifierClaim)))
+	if err != nil {
+		logger.Warn("failed exchanging the OpenID Connect code", "error", err)
+		writeError(w, r, rejected)
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		logger.Warn("OpenID Connect token response without id_token")
+		writeError(w, r, rejected)
+		return
+	}
+	// Checks the signature against the keys of the provider, the issuer, the
+	// audience and the expiry
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: config.OIDC.ClientID}).Verify(r.Context(), rawIDToken)
+	if err != nil {
+		logger.Warn("invalid OpenID Connect ID token", "error", err)
+		writeError(w, r, rejected)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(session.Get(oidcNonceClaim))) != 1 {
+		logger.Warn("OpenID Connect ID token with a wrong nonce")
+		writeError(w, r, rejected)
+		return
+	}
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	user, err := linkIdentity(r.Context(), client, idToken.Issuer, idToken.Subject, claims)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	addLogAttrs(r.Context(), "user_id", user.ID)
+	completeLogin(w, r, client, user, nil)
+}
+
+// linkIdentity returns the user of an identity of the provider. With
+// OIDC_LINK_VERIFIED_EMAILS, new identities are linked to the user with the
+// same address when both the user and the provider verified it, otherwise a
+// user is created.
+func linkIdentity(ctx context.Context, client *ent.Client, issuer, subject string, claims oidcClaims) (*ent.User, error) {
+	var email *string
+	if claims.Email != "" {
+		email = &claims.Email
+	}
+
+	linked, err := client.Identity.
+		Query().
+		Where(identity.Issuer(issuer), identity.Subject(subject)).
+		WithUser().
+		Only(ctx)
+	if err == nil {
+		err = linked.Update().SetNillableEmail(email).SetLastLoginAt(time.Now()).Exec(ctx)
+		return linked.Edges.User, err
+	}
+	if !ent.IsNotFound(err) {
+		return nil, err
+	}
+
+	var u *ent.User
+	if email != nil && claims.EmailVerified && config.OIDC.LinkVerifiedEmails {
+		u, err = client.User.
+			Query().
+			Where(user.Email(claims.Email), user.EmailVerifiedAtNotNil()).
+			Only(ctx)
+		if err != nil && !ent.IsNotFound(err) {
+			return nil, err
+		}
+	}
+	if u == nil {
+		if u, err = createOIDCUser(ctx, client, claims); err != nil {
+			return nil, err
+		}
+	}
+
+	err = client.Identity.
+		Create().
+		SetIssuer(issuer).
+		SetSubject(subject).
+		SetNillableEmail(email).
+		SetLastLoginAt(time.Now()).
+		SetUser(u).
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// createOIDCUser creates the user of a new identity. Its name is derived from
+// the claims and its password is random, a password reset sets one.
+func createOIDCUser(ctx context.Context, client *ent.Client, claims oidcClaims) (*ent.User, error) {
+	random, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	password, err := hashPassword(random)
+	if err != nil {
+		return nil, err
+	}
+
+	create := client.User.
+		Create().
+		SetPassword(password)
+	// The address is only kept when verified and not used by another user
+	if claims.Email != "" && claims.EmailVerified && schema.UserEmailPattern.MatchString(claims.Email) {
+		taken, err := client.User.Query().Where(user.Email(claims.Email)).Exist(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !taken {
+			create.SetEmail(claims.Email).SetEmailVerifiedAt(time.Now())
+		}
+	}
+
+	name := oidcUserName(claims)
+	for attempt := 0; ; attempt++ {
+		taken, err := client.User.Query().Where(user.Name(name)).Exist(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !taken {
+			break
+		}
+		if attempt == 5 {
+			return nil, apierror.Conflict("Could not find a free user name")
+		}
+		suffix := make([]byte, 3)
+		if _, err := rand.Read(suffix); err != nil {
+			return nil, err
+		}
+		name = oidcUserName(claims) + "_" + hex.EncodeToString(suffix)
+	}
+	return create.SetName(name).Save(ctx)
+}
+
+// oidcUserName derives a user name from the preferred username or the address
+// of the claims, dropping the characters user names don't allow
+func oidcUserName(claims oidcClaims) string {
+	name := claims.PreferredUsername
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	name = strings.Map(func(r rune) rune {
+		if r == ' ' || !schema.UserNamePattern.MatchString(string(r)) {
+			return -1
+		}
+		return r
+	}, name)
+	if len(name) < schema.UserNameMinLen {
+		name = "user"
+	}
+	return name
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go/djan/app/apierror"
+	"go/djan/app/ent"
+	"go/djan/app/ent/user"
+
+	jose "github.com/go-jose/go-jose/v4"
+)
+
+// fakeIdP is an OpenID Connect provider serving the discovery document, its
+// keys and a token endpoint checking the PKCE verifier
+type fakeIdP struct {
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]fakeGrant
+	// tamper alters the claims of the next ID token
+	tamper func(M)
+}
+
+// fakeGrant is an authorization code with what the token endpoint expects
+type fakeGrant struct {
+	challenge, nonce string
+	claims           M
+}
+
+// newFakeIdP starts a provider and configures the OpenID Connect login with it
+// until the test ends
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{key: key, grants: map[string]fakeGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, M{
+			"issuer":                                idp.srv.URL,
+			"authorization_endpoint":                idp.srv.URL + "/authorize",
+			"token_endpoint":                        idp.srv.URL + "/token",
+			"jwks_uri":                              idp.srv.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		idp.mu.Lock()
+		grant, ok := idp.grants[r.Form.Get("code")]
+		delete(idp.grants, r.Form.Get("code"))
+		tamper := idp.tamper
+		idp.tamper = nil
+		idp.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+			writeJSON(w, http.StatusBadRequest, M{"error": "invalid_grant"})
+			return
+		}
+		now := time.Now()
+		claims := M{"iss": idp.srv.URL, "aud": "djan", "iat": now.Unix(), "exp": now.Add(time.Hour).Unix(), "nonce": grant.nonce}
+		for k, v := range grant.claims {
+			claims[k] = v
+		}
+		if tamper != nil {
+			tamper(claims)
+		}
+		writeJSON(w, http.StatusOK, M{"access_token": "access", "token_type": "Bearer", "id_token": idp.sign(t, claims)})
+	})
+	idp.srv = httptest.NewServer(mux)
+	t.Cleanup(idp.srv.Close)
+
+	config.OIDC = OIDCConfig{
+		Issuer:        idp.srv.URL,
+		ClientID:      "djan",
+		ClientSecret:  "s3cret",
+		RedirectURL:   "https://localhost/oidc/callback",
+		Scopes:        []string{"openid", "email"},
+		StateLifetime: time.Minute,
+	}
+	oidcProvider.provider = nil
+	t.Cleanup(func() { oidcProvider.provider = nil })
+	return idp
+}
+
+func (idp *fakeIdP) sign(t *testing.T, claims M) string {
+	opts := (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "test")
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: idp.key}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// authorize plays the user consenting at the authorization URL and returns the
+// code the provider sends back
+func (idp *fakeIdP) authorize(t *testing.T, authURL string, claims M) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "djan" {
+		t.Fatalf("authorization URL %s", authURL)
+	}
+	code, err := newOpaqueToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.mu.Lock()
+	idp.grants[code] = fakeGrant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), claims: claims}
+	idp.mu.Unlock()
+	return code
+}
+
+// startOIDC starts a login, returning the authorization URL, state and session
+func startOIDC(t *testing.T) M {
+	t.Helper()
+	code, out := doJSON(t, http.HandlerFunc(oidcLogin), "GET", "/auth/oidc/login/", "", nil)
+	if code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	return out
+}
+
+// loginOIDC logs in through the provider with the claims of the ID token
+func loginOIDC(t *testing.T, idp *fakeIdP, claims M) (int, M) {
+	t.Helper()
+	start := startOIDC(t)
+	code := idp.authorize(t, start["authorization_url"].(string), claims)
+	return doJSON(t, http.HandlerFunc(oidcCallback), "POST", "/auth/oidc/callback/", "", M{
+		"code":    code,
+		"state":   start["state"],
+		"session": start["session"],
+	})
+}
+
+func TestOIDCLinksIdentities(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	idp := newFakeIdP(t)
+	config.OIDC.LinkVerifiedEmails = true
+	now := time.Now()
+	verified := client.User.Create().SetName("alice").SetPassword("hash").SetEmail("alice@example.com").SetEmailVerifiedAt(now).SaveX(ctx)
+	unverified := client.User.Create().SetName("bob").SetPassword("hash").SetEmail("bob@example.com").SaveX(ctx)
+
+	// A verified address of the provider matching a verified user
+	if code, out := loginOIDC(t, idp, M{"sub": "alice", "email": "alice@example.com", "email_verified": true}); code != http.StatusOK || out["token"] == nil {
+		t.Fatal(code, out)
+	}
+	if id := client.Identity.Query().QueryUser().OnlyIDX(ctx); id != verified.ID {
+		t.Fatalf("identity linked to user %d, want %d", id, verified.ID)
+	}
+	// The same identity logs in the same user
+	if code, out := loginOIDC(t, idp, M{"sub": "alice"}); code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+
+	for _, claims := range []M{
+		// The provider didn't verify the address
+		{"sub": "mallory", "email": "alice@example.com", "email_verified": false},
+		// The user didn't verify the address
+		{"sub": "bob", "email": "bob@example.com", "email_verified": true},
+	} {
+		if code, out := loginOIDC(t, idp, claims); code != http.StatusOK {
+			t.Fatal(code, out)
+		}
+		u := client.User.Query().Order(ent.Desc(user.FieldID)).FirstX(ctx)
+		if u.ID == verified.ID || u.ID == unverified.ID {
+			t.Errorf("identity %s linked to user %s", claims["sub"], u.Name)
+		}
+		// The address belongs to another user
+		if u.Email != nil {
+			t.Errorf("user %s created with the address %s", u.Name, *u.Email)
+		}
+	}
+	if n := client.User.Query().CountX(ctx); n != 4 {
+		t.Errorf("%d users, want 4", n)
+	}
+}
+
+func TestOIDCLinkingIsOptIn(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	idp := newFakeIdP(t)
+	alice := client.User.Create().SetName("alice").SetPassword("hash").SetEmail("alice@example.com").SetEmailVerifiedAt(time.Now()).SaveX(ctx)
+
+	if code, out := loginOIDC(t, idp, M{"sub": "alice", "email": "alice@example.com", "email_verified": true}); code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	if id := client.Identity.Query().QueryUser().OnlyIDX(ctx); id == alice.ID {
+		t.Error("identity linked to the user with the same address")
+	}
+}
+
+func TestOIDCNameCollision(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	idp := newFakeIdP(t)
+	client.User.Create().SetName("alicesmith").SetPassword("hash").ExecX(ctx)
+
+	code, out := loginOIDC(t, idp, M{"sub": "alice", "email": "alice@idp.example", "email_verified": true, "preferred_username": "alice smith"})
+	if code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	u := client.User.Query().Where(user.NameNEQ("alicesmith")).OnlyX(ctx)
+	if !strings.HasPrefix(u.Name, "alicesmith_") {
+		t.Errorf("got name %s", u.Name)
+	}
+	if u.Email == nil || *u.Email != "alice@idp.example" || u.EmailVerifiedAt == nil {
+		t.Errorf("got address %v verified at %v", u.Email, u.EmailVerifiedAt)
+	}
+}
+
+func TestOIDCCallbackRejects(t *testing.T) {
+	client := newTestClient(t)
+	idp := newFakeIdP(t)
+	callback := http.HandlerFunc(oidcCallback)
+
+	// The state returned by the provider is not the one of the session
+	start := startOIDC(t)
+	code := idp.authorize(t, start["authorization_url"].(string), M{"sub": "alice"})
+	if status, out := doJSON(t, callback, "POST", "/auth/oidc/callback/", "", M{"code": code, "state": "other", "session": start["session"]}); status != http.StatusUnauthorized || out["code"] != apierror.CodeInvalidToken {
+		t.Errorf("state mismatch: got %d %v", status, out)
+	}
+
+	// The code is exchanged with the PKCE verifier of another login
+	other := startOIDC(t)
+	if status, out := doJSON(t, callback, "POST", "/auth/oidc/callback/", "", M{"code": code, "state": other["state"], "session": other["session"]}); status != http.StatusUnauthorized || out["code"] != apierror.CodeInvalidCredentials {
+		t.Errorf("wrong verifier: got %d %v", status, out)
+	}
+
+	idp.tamper = func(claims M) { claims["nonce"] = "other" }
+	if status, out := loginOIDC(t, idp, M{"sub": "alice"}); status != http.StatusUnauthorized || out["code"] != apierror.CodeInvalidCredentials {
+		t.Errorf("nonce mismatch: got %d %v", status, out)
+	}
+
+	config.OIDC.StateLifetime = -time.Second
+	if status, out := loginOIDC(t, idp, M{"sub": "alice"}); status != http.StatusUnauthorized || out["code"] != apierror.CodeInvalidToken {
+		t.Errorf("expired session: got %d %v", status, out)
+	}
+
+	if n := client.User.Query().CountX(context.Background()); n != 0 {
+		t.Errorf("%d users created", n)
+	}
+
+	// The session of a completed login can't be used again, even with a new code
+	config.OIDC.StateLifetime = time.Minute
+	start = startOIDC(t)
+	body := M{"code": idp.authorize(t, start["authorization_url"].(string), M{"sub": "alice"}), "state": start["state"], "session": start["session"]}
+	if status, out := doJSON(t, callback, "POST", "/auth/oidc/callback/", "", body); status != http.StatusOK {
+		t.Fatalf("login: got %d %v", status, out)
+	}
+	body["code"] = idp.authorize(t, start["authorization_url"].(string), M{"sub": "alice"})
+	if status, out := doJSON(t, callback, "POST", "/auth/oidc/callback/", "", body); status != http.StatusUnauthorized || out["code"] != apierror.CodeInvalidToken {
+		t.Errorf("replayed session: got %d %v", status, out)
+	}
+}
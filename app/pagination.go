@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"go/djan/app/apierror"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"entgo.io/ent/dialect/sql"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// orderField is a column which list endpoints may be ordered by. Only non
+// nullable columns are allowed, as rows are compared against the cursor.
+type orderField[T any] struct {
+	column string
+	// value returns the column value of a row, stored in the cursor
+	value func(T) any
+	// parse converts a JSON decoded cursor value back to the column type
+	parse func(any) (any, error)
+}
+
+func orderByInt[T any](column string, value func(T) int) orderField[T] {
+	return orderField[T]{
+		column: column,
+		value:  func(row T) any { return value(row) },
+		parse: func(v any) (any, error) {
+			n, ok := v.(json.Number)
+			if !ok {
+				return nil, fmt.Errorf("expected a number")
+			}
+			i, err := n.Int64()
+			return int(i), err
+		},
+	}
+}
+
+func orderByString[T any](column string, value func(T) string) orderField[T] {
+	return orderField[T]{
+		column: column,
+		value:  func(row T) any { return value(row) },
+		parse: func(v any) (any, error) {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a string")
+			}
+			return s, nil
+		},
+	}
+}
+
+func orderByTime[T any](column string, value func(T) time.Time) orderField[T] {
+	return orderField[T]{
+		column: column,
+		value:  func(row T) any { return value(row) },
+		parse: func(v any) (any, error) {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a time")
+			}
+			return time.Parse(time.RFC3339Nano, s)
+		},
+	}
+}
+
+// cursor points at the boundary row of a page. It is handed out base64 encoded
+// so that clients treat it as opaque.
+type cursor struct {
+	OrderBy string `json:"o"`
+	Value   any    `json:"v"`
+	ID      int    `json:"id"`
+	// Prev is set for cursors pointing to the page before the boundary row
+	Prev bool `json:"p,omitempty"`
+}
+
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	c := &cursor{}
+	dec := json.NewDecoder(strings.NewReader(string(b)))
+	dec.UseNumber()
+	if err := dec.Decode(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+type Page struct {
+	Limit   int     `json:"limit"`
+	OrderBy string  `json:"order_by"`
+	Next    *string `json:"next"`
+	Prev    *string `json:"prev"`
+}
+
+// PageEnvelope is the response of every list endpoint
+type PageEnvelope struct {
+	Data any  `json:"data"`
+	Page Page `json:"page"`
+}
+
+type pageParams[T any] struct {
+	limit   int
+	orderBy string
+	field   orderField[T]
+	desc    bool
+	cursor  *cursor
+}
+
+// parsePageParams reads the limit, order_by and cursor query parameters.
+// order_by takes one of the whitelisted fields, prefixed with "-" for a
+// descending order.
+func parsePageParams[T any](r *http.Request, fields map[string]orderField[T], defaultOrder string) (pageParams[T], error) {
+	query := r.URL.Query()
+	p := pageParams[T]{limit: defaultPageLimit, orderBy: defaultOrder}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return p, apierror.InvalidParameter("limit", "must be a positive integer")
+		}
+		p.limit = min(n, maxPageLimit)
+	}
+
+	if orderBy := query.Get("order_by"); orderBy != "" {
+		p.orderBy = orderBy
+	}
+	name, desc := strings.CutPrefix(p.orderBy, "-")
+	field, ok := fields[name]
+	if !ok {
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return p, apierror.InvalidParameter("order_by", "must be one of "+strings.Join(names, ", "))
+	}
+	p.field, p.desc = field, desc
+
+	after, before := query.Get("next"), query.Get("prev")
+	if after != "" && before != "" {
+		return p, apierror.InvalidParameter("prev", "must not be given along with next")
+	}
+	if raw := after + before; raw != "" {
+		param := "next"
+		if before != "" {
+			param = "prev"
+		}
+		c, err := decodeCursor(raw)
+		if err != nil || c.OrderBy != p.orderBy || c.Prev != (before != "") {
+			return p, apierror.InvalidParameter(param, "is not a valid cursor")
+		}
+		if c.Value, err = field.parse(c.Value); err != nil {
+			return p, apierror.InvalidParameter(param, "is not a valid cursor")
+		}
+		p.cursor = c
+	}
+	return p, nil
+}
+
+// backwards reports whether rows are fetched in the reverse of the requested order
+func (p pageParams[T]) backwards() bool {
+	return p.cursor != nil && p.cursor.Prev
+}
+
+// where restricts the rows to the ones past the cursor
+func (p pageParams[T]) where(s *sql.Selector) {
+	if p.cursor == nil {
+		return
+	}
+	cmp := sql.GT
+	if p.desc != p.backwards() {
+		cmp = sql.LT
+	}
+	if p.field.column == "id" {
+		s.Where(cmp(s.C("id"), p.cursor.ID))
+		return
+	}
+	s.Where(sql.Or(
+		cmp(s.C(p.field.column), p.cursor.Value),
+		sql.And(
+			sql.EQ(s.C(p.field.column), p.cursor.Value),
+			cmp(s.C("id"), p.cursor.ID),
+		),
+	))
+}
+
+// order sorts by the requested column, using the id as a tie breaker
+func (p pageParams[T]) order(s *sql.Selector) {
+	direction := sql.Asc
+	if p.desc != p.backwards() {
+		direction = sql.Desc
+	}
+	if p.field.column != "id" {
+		s.OrderBy(direction(s.C(p.field.column)))
+	}
+	s.OrderBy(direction(s.C("id")))
+}
+
+// paginate fetches a page through the given query function and wraps it in
+// the page envelope. The query function must apply the where and order
+// selectors and the limit.
+func paginate[T any](p pageParams[T], id func(T) int, query func(where, order func(*sql.Selector), limit int) ([]T, error)) (*PageEnvelope, error) {
+	// One extra row tells whether there is a page beyond this one
+	rows, err := query(p.where, p.order, p.limit+1)
+	if err != nil {
+		return nil, err
+	}
+	more := len(rows) > p.limit
+	if more {
+		rows = rows[:p.limit]
+	}
+	if p.backwards() {
+		slices.Reverse(rows)
+	}
+	if rows == nil {
+		rows = []T{}
+	}
+
+	page := Page{Limit: p.limit, OrderBy: p.orderBy}
+	boundary := func(row T, prev bool) *string {
+		s := cursor{OrderBy: p.orderBy, Value: p.field.value(row), ID: id(row), Prev: prev}.encode()
+		return &s
+	}
+	if len(rows) > 0 {
+		// Walking backwards we came from the following page, walking forwards
+		// from the preceding one, if any
+		if more || p.backwards() {
+			page.Next = boundary(rows[len(rows)-1], false)
+		}
+		if (more && p.backwards()) || (p.cursor != nil && !p.backwards()) {
+			page.Prev = boundary(rows[0], true)
+		}
+	}
+
+	return &PageEnvelope{Data: rows, Page: page}, nil
+}
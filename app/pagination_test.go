@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"go/djan/app/apierror"
+)
+
+func TestListPagination(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	author := client.User.Create().SetName("carol").SetPassword("hash").SaveX(ctx)
+	client.User.Create().SetName("alice").SetPassword("hash").ExecX(ctx)
+	client.User.Create().SetName("bob").SetPassword("hash").ExecX(ctx)
+	// Blogs with the same title are ordered by id
+	for i, title := range []string{"beta", "alpha", "beta", "alpha", "beta"} {
+		client.Blog.Create().SetTitle(title).SetDescription(fmt.Sprint("post ", i)).SetUser(author).ExecX(ctx)
+	}
+	for _, name := range []string{"zig", "go", "rust"} {
+		client.Tag.Create().SetName(name).ExecX(ctx)
+	}
+	token := accessToken(t, author)
+	h := newRouter()
+
+	for _, tt := range []struct {
+		path, key string
+		want      []string
+	}{
+		{"/api/user/?order_by=id", "name", []string{"carol", "alice", "bob"}},
+		{"/api/user/?order_by=-id", "name", []string{"bob", "alice", "carol"}},
+		{"/api/user/?order_by=name", "name", []string{"alice", "bob", "carol"}},
+		{"/api/blog/?order_by=title", "description", []string{"post 1", "post 3", "post 0", "post 2", "post 4"}},
+		{"/api/blog/?order_by=-title", "description", []string{"post 4", "post 2", "post 0", "post 3", "post 1"}},
+		{"/api/tag/?order_by=-name", "name", []string{"zig", "rust", "go"}},
+	} {
+		// Pages of 2 forward, then back from the last page
+		var pages [][]string
+		var prev any
+		next := ""
+		for {
+			items, page := listPage(t, h, token, tt.path, tt.key, "next", next)
+			pages = append(pages, items)
+			prev = page["prev"]
+			if page["next"] == nil {
+				break
+			}
+			next = page["next"].(string)
+		}
+		if got := slices.Concat(pages...); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.path, got, tt.want)
+			continue
+		}
+		for i := len(pages) - 2; i >= 0; i-- {
+			items, page := listPage(t, h, token, tt.path, tt.key, "prev", prev.(string))
+			if !slices.Equal(items, pages[i]) {
+				t.Errorf("%s: page %d backwards is %v, want %v", tt.path, i, items, pages[i])
+			}
+			prev = page["prev"]
+		}
+		if prev != nil {
+			t.Errorf("%s: first page has a previous one", tt.path)
+		}
+	}
+
+	_, page := listPage(t, h, token, "/api/user/?order_by=name", "name", "", "")
+	next := page["next"].(string)
+	for _, query := range []string{
+		"order_by=name&next=garbage",
+		// A cursor of the next page given as the previous one
+		"order_by=name&prev=" + next,
+		"order_by=name&next=" + next + "&prev=" + next,
+		// A cursor of another order
+		"order_by=-name&next=" + next,
+		"order_by=password",
+		"limit=0",
+	} {
+		if code, out := doJSON(t, h, "GET", "/api/user/?"+query, token, nil); code != http.StatusBadRequest || out["code"] != apierror.CodeInvalidParameter {
+			t.Errorf("%s: got %d %v", query, code, out)
+		}
+	}
+}
+
+// listPage fetches a page of 2 items, returning their key and the page
+func listPage(t *testing.T, h http.Handler, token, path, key, param, cursor string) ([]string, M) {
+	t.Helper()
+	path += "&limit=2"
+	if cursor != "" {
+		path += "&" + param + "=" + url.QueryEscape(cursor)
+	}
+	code, out := doJSON(t, h, "GET", path, token, nil)
+	if code != http.StatusOK {
+		t.Fatalf("%s: got %d %v", path, code, out)
+	}
+	var items []string
+	for _, item := range out["data"].([]any) {
+		items = append(items, item.(map[string]any)[key].(string))
+	}
+	return items, out["page"].(map[string]any)
+}
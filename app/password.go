@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"go/djan/app/apierror"
+	"go/djan/app/ent"
+	"go/djan/app/ent/passwordreset"
+	"go/djan/app/ent/user"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordMaxLen is the length beyond which bcrypt ignores passwords
+const passwordMaxLen = 72
+
+//go:embed common_passwords.txt
+var bundledCommonPasswords string
+
+// commonPasswords are refused by the password policy, lower cased. They are
+// replaced by setupPasswordPolicy as configured.
+var commonPasswords = parsePasswordList(bundledCommonPasswords)
+
+// setupPasswordPolicy loads the common passwords of the configuration
+func setupPasswordPolicy(cfg PasswordConfig) error {
+	switch {
+	case !cfg.CheckCommon:
+		commonPasswords = nil
+	case cfg.CommonListFile != "":
+		b, err := os.ReadFile(cfg.CommonListFile)
+		if err != nil {
+			return fmt.Errorf("error reading common passwords: %w", err)
+		}
+		commonPasswords = parsePasswordList(string(b))
+	}
+	return nil
+}
+
+// parsePasswordList reads a list of passwords, one per line, skipping empty
+// lines and # comments
+func parsePasswordList(list string) map[string]struct{} {
+	passwords := map[string]struct{}{}
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = struct{}{}
+	}
+	return passwords
+}
+
+// password checks a new password against the password policy
+func (v *validator) password(field, value string) {
+	n := len(v.errs)
+	v.length(field, value, config.Password.MinLength, passwordMaxLen)
+	if len(v.errs) > n {
+		return
+	}
+	if _, ok := commonPasswords[strings.ToLower(value)]; ok {
+		v.add(field, apierror.FieldTooCommon, "is too common, choose a less guessable password")
+	}
+}
+
+// checkPassword returns the first rule of the password policy broken by the
+// password, for the commands
+func checkPassword(password string) error {
+	v := &validator{}
+	v.password("password", password)
+	if len(v.errs) > 0 {
+		return fmt.Errorf("the password %s", v.errs[0].Message)
+	}
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hashed), err
+}
+
+// dummyPasswordHash is compared against the passwords of unknown users, so
+// that their logins take as long as the others
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hashed, err := bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hashed
+})
+
+// setPassword sets the password of u, once it passed the policy, and clears
+// the failed logins. The other sessions are ended: the refresh tokens issued
+// until then are revoked and their access tokens refused by authenticateUser.
+// API keys are kept, they are credentials of their own which machine clients
+// depend on, and are revoked one by one.
+func setPassword(ctx context.Context, client *ent.Client, u *ent.User, password string) (err error) {
+	hashed, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	tx, err := client.Tx(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	err = tx.User.
+		UpdateOne(u).
+		SetPassword(hashed).
+		SetPasswordChangedAt(time.Now()).
+		SetFailedLogins(0).
+		ClearLockedUntil().
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	if err := revokeUserTokens(ctx, tx.Client(), u.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkCurrentPassword confirms the password of an authenticated user before a
+// sensitive change. Wrong passwords count as failed logins, so that stolen
+// tokens don't allow guessing the password either.
+func checkCurrentPassword(w http.ResponseWriter, r *http.Request, client *ent.Client, u *ent.User, field, password string) error {
+	if d := lockedFor(u); d > 0 {
+		return tooManyRequests(w, apierror.CodeAccountLocked, "Too many failed logins, the account is locked", d)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)); err != nil {
+		if err := recordFailedLogin(r.Context(), client, u); err != nil {
+			return err
+		}
+		return apierror.Validation(apierror.FieldError{
+			Field:   field,
+			Code:    apierror.FieldInvalid,
+			Message: "is incorrect",
+		})
+	}
+	return nil
+}
+
+type PasswordChangeRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+func (req *PasswordChangeRequest) validate(v *validator) {
+	v.required("old_password", req.OldPassword != "")
+	if v.required("new_password", req.NewPassword != "") {
+		v.password("new_password", req.NewPassword)
+	}
+}
+
+// changePassword sets a new password for the authenticated user, given the
+// old one. Other sessions are ended, the caller gets a new token pair.
+func changePassword(w http.ResponseWriter, r *http.Request) {
+	client := GetClient()
+	password_json, err := decodeJSON[PasswordChangeRequest](w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	user := GetUserFromContext(r.Context())
+	if err := checkCurrentPassword(w, r, client, user, "old_password", password_json.OldPassword); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if password_json.NewPassword == password_json.OldPassword {
+		writeError(w, r, apierror.Validation(apierror.FieldError{
+			Field:   "new_password",
+			Code:    apierror.FieldInvalid,
+			Message: "must differ from the old password",
+		}))
+		return
+	}
+
+	if err := setPassword(r.Context(), client, user, password_json.NewPassword); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	tokens, err := issueTokenPair(r.Context(), client, user, "", nil)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+type PasswordForgotRequest struct {
+	Email string `json:"email"`
+}
+
+func (req *PasswordForgotRequest) validate(v *validator) {
+	if v.required("email", req.Email != "") {
+		validateEmail(v, req.Email)
+	}
+}
+
+// forgotPassword mails a password reset link to the user of the address.
+// The answer doesn't tell whether a user has the address, so that addresses
+// can't be enumerated.
+func forgotPassword(w http.ResponseWriter, r *http.Request) {
+	client := GetClient()
+	forgot_json, err := decodeJSON[PasswordForgotRequest](w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Looked up and mailed off the request path, so that the time of the
+	// answer doesn't tell either
+	ctx := context.WithoutCancel(r.Context())
+	pendingMails.Add(1)
+	go func() {
+		defer pendingMails.Done()
+		u, err := client.User.
+			Query().
+			Where(user.Email(forgot_json.Email), user.EmailVerifiedAtNotNil(), user.IsActive(true)).
+			Only(ctx)
+		if err == nil {
+			err = sendPasswordReset(ctx, client, u)
+		}
+		if err != nil && !ent.IsNotFound(err) {
+			loggerFromContext(ctx).Error("failed sending password reset", "error", err)
+		}
+	}()
+
+	writeJSON(w, http.StatusAccepted, M{"message": "If the address belongs to an account, a reset link was sent to it"})
+}
+
+const passwordResetMail = `Hello %s,
+
+A password reset was requested for your account. Choose a new password at
+the following link, which expires in %s:
+
+%s
+
+If you didn't request it, ignore this email, your password is unchanged.
+`
+
+// sendPasswordReset creates a reset token for u and mails its link. Only the
+// hash of the token is stored.
+func sendPasswordReset(ctx context.Context, client *ent.Client, u *ent.User) error {
+	if u.Email == nil {
+		return errors.New("user has no email address")
+	}
+	token, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+	err = client.PasswordReset.
+		Create().
+		SetTokenHash(hashToken(token)).
+		SetExpiresAt(time.Now().Add(config.Password.ResetTokenLifetime)).
+		SetUser(u).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	link, err := url.Parse(config.Password.ResetURL)
+	if err != nil {
+		return err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return mailer.Send(ctx, Message{
+		From:    config.Mail.From,
+		To:      *u.Email,
+		Subject: "Reset your password",
+		Body:    fmt.Sprintf(passwordResetMail, u.Name, config.Password.ResetTokenLifetime, link),
+	})
+}
+
+type PasswordResetRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (req *PasswordResetRequest) validate(v *validator) {
+	v.required("token", req.Token != "")
+	if v.required("password", req.Password != "") {
+		v.password("password", req.Password)
+	}
+}
+
+// resetPassword sets a new password with a reset token. Tokens are single
+// use, the other pending resets of the user are dropped.
+func resetPassword(w http.ResponseWriter, r *http.Request) {
+	client := GetClient()
+	reset_json, err := decodeJSON[PasswordResetRequest](w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	invalidToken := apierror.Unauthorized(apierror.CodeInvalidToken, "The reset token is invalid or has expired")
+	reset, err := client.PasswordReset.
+		Query().
+		Where(passwordreset.TokenHash(hashToken(reset_json.Token))).
+		WithUser().
+		Only(r.Context())
+	if ent.IsNotFound(err) {
+		writeError(w, r, invalidToken)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+		writeError(w, r, invalidToken)
+		return
+	}
+
+	// Concurrent requests can't both use the token
+	n, err := client.PasswordReset.
+		Update().
+		Where(passwordreset.ID(reset.ID), passwordreset.UsedAtIsNil()).
+		SetUsedAt(time.Now()).
+		Save(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if n == 0 {
+		writeError(w, r, invalidToken)
+		return
+	}
+
+	u := reset.Edges.User
+	if err := setPassword(r.Context(), client, u, reset_json.Password); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	_, err = client.PasswordReset.
+		Delete().
+		Where(passwordreset.HasUserWith(user.ID(u.ID)), passwordreset.UsedAtIsNil()).
+		Exec(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, M{"message": "Password reset, log in with the new password"})
+}
+
+// sweepPasswordResets purges reset tokens which have expired
+func sweepPasswordResets(ctx context.Context, client *ent.Client) (int, error) {
+	return client.PasswordReset.
+		Delete().
+		Where(passwordreset.ExpiresAtLT(time.Now())).
+		Exec(ctx)
+}
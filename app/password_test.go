@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go/djan/app/apierror"
+
+	"github.com/o1egl/paseto"
+)
+
+func TestSetPasswordEndsSessions(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	password, err := hashPassword("s3cret-pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := client.User.Create().SetName("alice").SetPassword(password).SaveX(ctx)
+	if _, err := issueTokenPair(ctx, client, u, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	client.APIKey.Create().SetName("job").SetPrefix("djan_abcdef").SetKeyHash(hashToken("djan_abcdef123")).SetUser(u).ExecX(ctx)
+
+	// An access token of a session opened before the change
+	before := paseto.JSONToken{
+		Jti:        "before",
+		Subject:    strconv.Itoa(u.ID),
+		IssuedAt:   time.Now().Add(-time.Minute),
+		Expiration: time.Now().Add(time.Minute),
+	}
+	before.Set(roleClaim, u.Role.String())
+	oldToken, err := encryptToken(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := setPassword(ctx, client, u, "n3w-password"); err != nil {
+		t.Fatal(err)
+	}
+	if n := client.RefreshToken.Query().CountX(ctx); n != 1 {
+		t.Fatalf("%d refresh tokens", n)
+	}
+	if !client.RefreshToken.Query().OnlyX(ctx).Revoked {
+		t.Error("refresh token not revoked")
+	}
+
+	h := authenticateUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, M{})
+	}))
+	if code, out := doJSON(t, h, "GET", "/", oldToken, nil); code != http.StatusUnauthorized || out["code"] != apierror.CodeTokenRevoked {
+		t.Errorf("token of before the change: got %d %v", code, out)
+	}
+	newToken, err := issueAccessToken(u, "session", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, out := doJSON(t, h, "GET", "/", newToken, nil); code != http.StatusOK {
+		t.Errorf("token of after the change: got %d %v", code, out)
+	}
+	// API keys outlive the password
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-API-Key", "djan_abcdef123")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("API key of before the change: got %d %s", rec.Code, rec.Body)
+	}
+}
+
+// recordingMailer keeps the mails it is given
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (m *recordingMailer) Send(_ context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestForgotPasswordMailsInBackground(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	client.User.Create().SetName("alice").SetPassword("hash").SetEmail("alice@example.com").SetEmailVerifiedAt(time.Now()).ExecX(ctx)
+	m := &recordingMailer{}
+	mailer = m
+	t.Cleanup(func() { mailer = logMailer{} })
+
+	h := http.HandlerFunc(forgotPassword)
+	var answers []M
+	for _, email := range []string{"alice@example.com", "nobody@example.com"} {
+		code, out := doJSON(t, h, "POST", "/auth/password/forgot/", "", M{"email": email})
+		if code != http.StatusAccepted {
+			t.Fatalf("%s: got %d %v", email, code, out)
+		}
+		answers = append(answers, out)
+	}
+	if answers[0]["message"] != answers[1]["message"] {
+		t.Errorf("answers differ: %v and %v", answers[0], answers[1])
+	}
+
+	pendingMails.Wait()
+	if len(m.sent) != 1 || m.sent[0].To != "alice@example.com" {
+		t.Errorf("sent %v", m.sent)
+	}
+	if n := client.PasswordReset.Query().CountX(ctx); n != 1 {
+		t.Errorf("%d password resets", n)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"go/djan/app/apierror"
+	"go/djan/app/ent"
+	"go/djan/app/ent/blog"
+	"go/djan/app/ent/user"
+	"net/http"
+)
+
+// Object level permissions, checked by the handlers once the target object
+// is known. Admins are allowed to modify everything, editors the tags.
+
+func isAdmin(actor *ent.User) bool {
+	return actor != nil && effectiveRole(actor) == user.RoleAdmin
+}
+
+func isEditor(actor *ent.User) bool {
+	return actor != nil && (isAdmin(actor) || actor.Role == user.RoleEditor)
+}
+
+// canModifyUser allows users to modify only their own profile
+func canModifyUser(actor *ent.User, userID int) bool {
+	if actor == nil {
+		return false
+	}
+	return isAdmin(actor) || actor.ID == userID
+}
+
+// canModifyBlog allows users to modify only the blogs they authored
+func canModifyBlog(ctx context.Context, client *ent.Client, actor *ent.User, blogID int) (bool, error) {
+	if actor == nil {
+		return false, nil
+	}
+	if isAdmin(actor) {
+		return true, nil
+	}
+	return client.Blog.
+		Query().
+		Where(blog.ID(blogID), blog.HasUserWith(user.ID(actor.ID))).
+		Exist(ctx)
+}
+
+// canModifyTag allows only editors to modify tags, which are shared by all blogs
+func canModifyTag(actor *ent.User) bool {
+	return isEditor(actor)
+}
+
+func forbidden(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, apierror.Forbidden("You do not have permission to perform this action"))
+}
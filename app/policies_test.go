@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"go/djan/app/apierror"
+	"go/djan/app/ent/user"
+)
+
+func TestBlogPermissions(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	newUser := func(name string, role user.Role) string {
+		u := client.User.Create().SetName(name).SetPassword("hash").SetRole(role).
+			SetEmail(name + "@example.com").SetEmailVerifiedAt(time.Now()).SaveX(ctx)
+		return accessToken(t, u)
+	}
+	alice := newUser("alice", user.RoleReader)
+	bob := newUser("bob", user.RoleReader)
+	editor := newUser("carol", user.RoleEditor)
+	admin := newUser("dave", user.RoleAdmin)
+	owner := client.User.Query().Where(user.Name("alice")).OnlyX(ctx)
+	blog := client.Blog.Create().SetTitle("First post").SetDescription("Hello, world").SetUser(owner).SaveX(ctx)
+	path := "/api/blog/" + strconv.Itoa(blog.ID)
+
+	h := newRouter()
+	for _, tt := range []struct {
+		name, token string
+	}{
+		{"another reader", bob},
+		{"an editor", editor},
+	} {
+		if code, out := doJSON(t, h, "PATCH", path, tt.token, M{"title": "Taken over"}); code != http.StatusForbidden || out["code"] != apierror.CodeForbidden {
+			t.Errorf("%s updating the blog: got %d %v", tt.name, code, out)
+		}
+		if code, out := doJSON(t, h, "DELETE", path, tt.token, nil); code != http.StatusForbidden || out["code"] != apierror.CodeForbidden {
+			t.Errorf("%s deleting the blog: got %d %v", tt.name, code, out)
+		}
+	}
+
+	// Only admins may hand a blog over, even its author may not
+	bobID := client.User.Query().Where(user.Name("bob")).OnlyIDX(ctx)
+	if code, out := doJSON(t, h, "PATCH", path, alice, M{"user_id": bobID}); code != http.StatusForbidden {
+		t.Errorf("author handing the blog over: got %d %v", code, out)
+	}
+	if code, out := doJSON(t, h, "PATCH", path, alice, M{"title": "Second post"}); code != http.StatusOK {
+		t.Errorf("author updating the blog: got %d %v", code, out)
+	}
+	if code, out := doJSON(t, h, "PATCH", path, admin, M{"user_id": bobID}); code != http.StatusOK {
+		t.Errorf("admin handing the blog over: got %d %v", code, out)
+	}
+	if got := client.Blog.GetX(ctx, blog.ID).QueryUser().OnlyIDX(ctx); got != bobID {
+		t.Errorf("blog authored by %d, want %d", got, bobID)
+	}
+	if code, out := doJSON(t, h, "DELETE", path, admin, nil); code != http.StatusOK {
+		t.Errorf("admin deleting the blog: got %d %v", code, out)
+	}
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"database/sql"
 	"go/djan/app/ent"
-	"log"
+	"log/slog"
+	"os"
 	"sync"
 
+	"entgo.io/ent/dialect"
+	entsql "entgo.io/ent/dialect/sql"
+	"entgo.io/ent/dialect/sql/schema"
 	_ "github.com/lib/pq"
-	"github.com/spf13/viper"
 )
 
 var (
+	dbInstance     *sql.DB
 	clientInstance *ent.Client
 	once           sync.Once
 )
@@ -17,15 +22,32 @@ var (
 // GetClient returns the singleton instance of the ent.Client
 func GetClient() *ent.Client {
 	once.Do(func() {
-		var err error
-		user := viper.GetString("POSTGRES_USER")
-		db_name := viper.GetString("POSTGRES_DBNAME")
-		password := viper.GetString("POSTGRES_PASSWORD")
-		db_string := "host=localhost port=5432 user=" + user + " dbname=" + db_name + " password=" + password
-		clientInstance, err = ent.Open("postgres", db_string)
+		db, err := sql.Open("postgres", config.Database.DSN())
 		if err != nil {
-			log.Fatalf("failed opening connection to postgres: %v", err)
+			slog.Error("failed opening connection to postgres", "error", err)
+			os.Exit(1)
 		}
+		db.SetMaxOpenConns(config.Database.MaxOpenConns)
+		db.SetMaxIdleConns(config.Database.MaxIdleConns)
+		db.SetConnMaxLifetime(config.Database.ConnMaxLifetime)
+		db.SetConnMaxIdleTime(config.Database.ConnMaxIdleTime)
+
+		dbInstance = db
+		clientInstance = ent.NewClient(ent.Driver(newTracedDriver(entsql.OpenDB(dialect.Postgres, db))))
+		instrumentClient(clientInstance, db)
 	})
 	return clientInstance
 }
+
+// GetDB returns the connection pool of the ent.Client
+func GetDB() *sql.DB {
+	GetClient()
+	return dbInstance
+}
+
+// migrateOptions are the options of every schema diff
+func migrateOptions() []schema.MigrateOption {
+	return []schema.MigrateOption{
+		schema.WithDiffHook(searchVectorHook),
+	}
+}
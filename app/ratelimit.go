@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"go/djan/app/apierror"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the requests made under a key, such as a client IP.
+// memoryLimiter keeps its quotas in the process, replicas behind a load
+// balancer need an implementation backed by a shared store, such as Redis.
+type RateLimiter interface {
+	// Allow takes a request from the quota of key
+	Allow(ctx context.Context, key string) (RateLimit, error)
+}
+
+// RateLimit is the state of a quota after a request
+type RateLimit struct {
+	Allowed bool
+	// Limit is the number of requests allowed in a burst
+	Limit     int
+	Remaining int
+	// Reset is the time until the quota is full again
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, once denied
+	RetryAfter time.Duration
+}
+
+// authLimiters limit the requests to the login and signup endpoints, they are
+// set up by serve. Nil limiters let every request through.
+var authLimiters struct {
+	ip   RateLimiter
+	user RateLimiter
+}
+
+// setupRateLimits creates the in-memory limiters of the auth endpoints, a
+// limit of 0 disables them
+func setupRateLimits(cfg RateLimitConfig) {
+	if cfg.AuthPerIP > 0 {
+		authLimiters.ip = newMemoryLimiter(cfg.AuthPerIP, time.Minute)
+	}
+	if cfg.AuthPerUser > 0 {
+		authLimiters.user = newMemoryLimiter(cfg.AuthPerUser, time.Minute)
+	}
+}
+
+// memoryLimiter is a token bucket limiter: every key has a bucket of burst
+// tokens, refilled at rate tokens per second, and requests take a token each
+type memoryLimiter struct {
+	rate  float64
+	burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newMemoryLimiter allows limit requests per period, in bursts of up to limit
+func newMemoryLimiter(limit int, period time.Duration) *memoryLimiter {
+	return &memoryLimiter{
+		rate:      float64(limit) / period.Seconds(),
+		burst:     limit,
+		buckets:   map[string]*bucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *memoryLimiter) Allow(_ context.Context, key string) (RateLimit, error) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+
+	limit := RateLimit{Limit: l.burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		limit.Allowed = true
+	} else {
+		limit.RetryAfter = l.duration(1 - b.tokens)
+	}
+	limit.Remaining = int(b.tokens)
+	limit.Reset = l.duration(float64(l.burst) - b.tokens)
+	return limit, nil
+}
+
+func (l *memoryLimiter) refill(b *bucket, now time.Time) float64 {
+	return min(float64(l.burst), b.tokens+now.Sub(b.last).Seconds()*l.rate)
+}
+
+// duration returns the time taken to refill the given number of tokens
+func (l *memoryLimiter) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// sweep drops the buckets which are full again, at most once a minute, so
+// that the keys of past clients don't pile up
+func (l *memoryLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimit limits the requests by the key returned for them, e.g. clientIP.
+// A nil limiter lets every request through.
+func rateLimit(limiter RateLimiter, key func(*http.Request) string) Middleware {
+	return func(next http.Handler) http.Handler {
+		if limiter == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !allowRequest(w, r, limiter, key(r)) {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// allowRequest takes a request from the quota of key and sets the RateLimit
+// headers, answering 429 once the quota is exhausted. Errors of the limiter
+// let the request through, so that an unavailable store doesn't take the
+// endpoint down with it.
+func allowRequest(w http.ResponseWriter, r *http.Request, limiter RateLimiter, key string) bool {
+	if limiter == nil {
+		return true
+	}
+	limit, err := limiter.Allow(r.Context(), key)
+	if err != nil {
+		loggerFromContext(r.Context()).Warn("rate limiter failed", "error", err)
+		return true
+	}
+
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(limit.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(limit.Remaining))
+	h.Set("RateLimit-Reset", seconds(limit.Reset))
+	if !limit.Allowed {
+		writeError(w, r, tooManyRequests(w, apierror.CodeRateLimited, "Too many requests, try again later", limit.RetryAfter))
+		return false
+	}
+	return true
+}
+
+// tooManyRequests sets the Retry-After header and returns the error of a
+// request refused for the given duration
+func tooManyRequests(w http.ResponseWriter, code, detail string, retryAfter time.Duration) error {
+	w.Header().Set("Retry-After", seconds(retryAfter))
+	return apierror.TooManyRequests(code, detail)
+}
+
+// seconds formats a duration as the whole seconds of HTTP headers, rounded up
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
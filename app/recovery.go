@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"go/djan/app/apierror"
+	"net/http"
+	"runtime/debug"
+)
+
+// recovery answers the requests whose handler panicked with a 500 problem,
+// instead of net/http dropping the connection, and logs the stack trace. It
+// must be wrapped by logging, and wrap the handlers in turn so that the
+// access log, metrics and traces see the 500.
+func recovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, route := withMatchedRoute(r.Context())
+		wrappedWriter := &WrappedWriter{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			// ErrAbortHandler is how handlers abort a response on purpose
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			label := *route
+			if label == "" {
+				label = unmatchedRoute
+			}
+			httpPanicsTotal.WithLabelValues(label).Inc()
+			loggerFromContext(ctx).Error("panic serving request",
+				"panic", fmt.Sprint(rec),
+				"stack", string(debug.Stack()),
+			)
+
+			// A response already on its way can only be cut short
+			if wrappedWriter.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+			writeProblem(w, r.WithContext(ctx), apierror.Internal(fmt.Errorf("panic: %v", rec)))
+		}()
+
+		next.ServeHTTP(wrappedWriter, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go/djan/app/apierror"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecovery(t *testing.T) {
+	mux := newRouteMux()
+	mux.HandleFunc("GET /panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	mux.HandleFunc("GET /ok", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, M{"status": "ok"})
+	})
+	srv := httptest.NewServer(requestID(logging(recovery(mux))))
+	defer srv.Close()
+
+	panics := httpPanicsTotal.WithLabelValues("GET /panic")
+	before := testutil.ToFloat64(panics)
+
+	resp, err := http.Get(srv.URL + "/panic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var problem M
+	if err := json.NewDecoder(resp.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusInternalServerError || resp.Header.Get("Content-Type") != apierror.ContentType {
+		t.Errorf("got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if problem["code"] != apierror.CodeInternal {
+		t.Errorf("got code %v", problem["code"])
+	}
+	if got := testutil.ToFloat64(panics) - before; got != 1 {
+		t.Errorf("http_panics_total went up by %v", got)
+	}
+
+	// The server keeps serving
+	resp, err = http.Get(srv.URL + "/ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got %d after the panic", resp.StatusCode)
+	}
+}
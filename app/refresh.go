@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"go/djan/app/apierror"
+	"go/djan/app/ent"
+	"go/djan/app/ent/refreshtoken"
+	"go/djan/app/ent/user"
+	"net/http"
+	"time"
+)
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+func (req *RefreshRequest) validate(v *validator) {
+	v.required("refresh_token", req.RefreshToken != "")
+	validateScope(v, "scope", req.Scope)
+}
+
+// newOpaqueToken returns a random URL safe token which is only ever stored hashed
+func newOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokenPair mints an access token along with a new refresh token of the
+// given family, an empty family starts a new session. Sessions narrowed to
+// some scopes keep them through the rotations, nil grants every scope of the
+// user.
+func issueTokenPair(ctx context.Context, client *ent.Client, user *ent.User, family string, scopes []string) (M, error) {
+	if family == "" {
+		var err error
+		if family, err = newTokenID(); err != nil {
+			return nil, err
+		}
+	}
+	if scopes != nil {
+		scopes = narrowScopes(scopesOf(user), scopes)
+	}
+
+	accessToken, err := issueAccessToken(user, family, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	err = client.RefreshToken.
+		Create().
+		SetTokenHash(hashToken(refreshToken)).
+		SetFamily(family).
+		SetScopes(scopes).
+		SetExpiresAt(time.Now().Add(config.Auth.RefreshTokenLifetime)).
+		SetUser(user).
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return M{
+		"token":         accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(config.Auth.AccessTokenLifetime.Seconds()),
+		"refresh_token": refreshToken,
+	}, nil
+}
+
+// revokeTokenFamily revokes every refresh token issued for a session
+func revokeTokenFamily(ctx context.Context, client *ent.Client, family string) error {
+	return client.RefreshToken.
+		Update().
+		Where(refreshtoken.Family(family)).
+		SetRevoked(true).
+		Exec(ctx)
+}
+
+// revokeUserTokens revokes every refresh token issued to a user
+func revokeUserTokens(ctx context.Context, client *ent.Client, userID int) error {
+	return client.RefreshToken.
+		Update().
+		Where(refreshtoken.HasUserWith(user.ID(userID))).
+		SetRevoked(true).
+		Exec(ctx)
+}
+
+// sweepRefreshTokens purges refresh tokens which have expired
+func sweepRefreshTokens(ctx context.Context, client *ent.Client) (int, error) {
+	return client.RefreshToken.
+		Delete().
+		Where(refreshtoken.ExpiresAtLT(time.Now())).
+		Exec(ctx)
+}
+
+func refreshHandler(w http.ResponseWriter, r *http.Request) {
+	client := GetClient()
+	refresh_json, err := decodeJSON[RefreshRequest](w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	stored, err := client.RefreshToken.
+		Query().
+		Where(refreshtoken.TokenHash(hashToken(refresh_json.RefreshToken))).
+		WithUser().
+		Only(r.Context())
+	if err != nil {
+		if ent.IsNotFound(err) {
+			err = apierror.Unauthorized(apierror.CodeInvalidToken, "Invalid refresh token")
+		}
+		writeError(w, r, err)
+		return
+	}
+
+	if stored.Revoked || stored.UsedAt != nil {
+		rejectReusedToken(w, r, client, stored)
+		return
+	}
+
+	if stored.ExpiresAt.Before(time.Now()) {
+		writeError(w, r, apierror.Unauthorized(apierror.CodeTokenExpired, "Refresh token expired"))
+		return
+	}
+
+	// The scope may be narrowed further, but never widened
+	scopes := narrowScopes(stored.Scopes, parseScope(refresh_json.Scope))
+	if len(narrowScopes(scopesOf(stored.Edges.User), scopes)) == 0 {
+		writeError(w, r, errNoGrantedScope)
+		return
+	}
+
+	// Mark the token as used, the guard on used_at makes sure that only one of
+	// two concurrent requests with the same token wins the rotation
+	n, err := client.RefreshToken.
+		Update().
+		Where(
+			refreshtoken.ID(stored.ID),
+			refreshtoken.UsedAtIsNil(),
+			refreshtoken.Revoked(false),
+		).
+		SetUsedAt(time.Now()).
+		Save(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if n == 0 {
+		rejectReusedToken(w, r, client, stored)
+		return
+	}
+
+	tokens, err := issueTokenPair(r.Context(), client, stored.Edges.User, stored.Family, scopes)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// rejectReusedToken handles the replay of an already rotated refresh token.
+// Either the legitimate client or an attacker holds a stolen token, so the
+// whole session is revoked and both have to log in again.
+func rejectReusedToken(w http.ResponseWriter, r *http.Request, client *ent.Client, stored *ent.RefreshToken) {
+	loggerFromContext(r.Context()).Warn("refresh token reuse detected, revoking the token family",
+		"user_id", stored.Edges.User.ID, "family", stored.Family)
+	if err := revokeTokenFamily(r.Context(), client, stored.Family); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeError(w, r, apierror.Unauthorized(apierror.CodeTokenReused, "Refresh token has already been used"))
+}
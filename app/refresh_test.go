@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"go/djan/app/apierror"
+	"go/djan/app/ent"
+	"go/djan/app/ent/refreshtoken"
+)
+
+// refresh rotates a refresh token
+func refresh(t *testing.T, token string) (int, M) {
+	t.Helper()
+	return doJSON(t, http.HandlerFunc(refreshHandler), "POST", "/auth/refresh/", "", M{"refresh_token": token})
+}
+
+// newSession logs in a new user and returns its first refresh token
+func newSession(t *testing.T, client *ent.Client) string {
+	t.Helper()
+	u := client.User.Create().SetName("alice").SetPassword("hash").SaveX(context.Background())
+	tokens, err := issueTokenPair(context.Background(), client, u, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens["refresh_token"].(string)
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	client := newTestClient(t)
+	first := newSession(t, client)
+
+	code, out := refresh(t, first)
+	if code != http.StatusOK || out["token"] == nil {
+		t.Fatalf("got %d %v", code, out)
+	}
+	second := out["refresh_token"].(string)
+
+	// The rotated token is refused, and its replay ends the session
+	if code, out := refresh(t, first); code != http.StatusUnauthorized || out["code"] != apierror.CodeTokenReused {
+		t.Errorf("rotated token: got %d %v", code, out)
+	}
+	if code, out := refresh(t, second); code != http.StatusUnauthorized || out["code"] != apierror.CodeTokenReused {
+		t.Errorf("token of the revoked family: got %d %v", code, out)
+	}
+	if n := client.RefreshToken.Query().Where(refreshtoken.Revoked(false)).CountX(context.Background()); n != 0 {
+		t.Errorf("%d refresh tokens left valid", n)
+	}
+}
+
+func TestRefreshTokenConcurrentRotations(t *testing.T) {
+	client := newTestClient(t)
+	token := newSession(t, client)
+
+	statuses := make([]int, 2)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range statuses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			statuses[i], _ = refresh(t, token)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	succeeded := 0
+	for _, status := range statuses {
+		switch status {
+		case http.StatusOK:
+			succeeded++
+		case http.StatusUnauthorized:
+		default:
+			t.Errorf("got status %d", status)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d rotations succeeded, want 1", succeeded)
+	}
+}
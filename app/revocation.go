@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"go/djan/app/ent"
+	"go/djan/app/ent/revokedtoken"
+	"log/slog"
+	"time"
+
+	"github.com/o1egl/paseto"
+)
+
+// revokeToken records the token's jti so that authenticateUser rejects it
+// for the rest of its lifetime
+func revokeToken(ctx context.Context, client *ent.Client, token *paseto.JSONToken) error {
+	err := client.RevokedToken.
+		Create().
+		SetJti(token.Jti).
+		SetExpiresAt(token.Expiration).
+		Exec(ctx)
+	// Signing out twice with the same token is not an error
+	if ent.IsConstraintError(err) {
+		return nil
+	}
+	return err
+}
+
+// claimToken revokes a single use token, reporting false when it was already
+// used. The unique jti makes it safe against concurrent uses.
+func claimToken(ctx context.Context, client *ent.Client, token *paseto.JSONToken) (bool, error) {
+	err := client.RevokedToken.
+		Create().
+		SetJti(token.Jti).
+		SetExpiresAt(token.Expiration).
+		Exec(ctx)
+	if ent.IsConstraintError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// isTokenRevoked reports whether the token with the given jti was revoked
+func isTokenRevoked(ctx context.Context, client *ent.Client, jti string) (bool, error) {
+	return client.RevokedToken.
+		Query().
+		Where(revokedtoken.Jti(jti)).
+		Exist(ctx)
+}
+
+// sweepRevokedTokens purges revocation entries of tokens which have expired anyway
+func sweepRevokedTokens(ctx context.Context, client *ent.Client) (int, error) {
+	return client.RevokedToken.
+		Delete().
+		Where(revokedtoken.ExpiresAtLT(time.Now())).
+		Exec(ctx)
+}
+
+// startRevocationSweeper periodically purges expired revocation entries,
+// refresh tokens and password resets until the context is cancelled
+func startRevocationSweeper(ctx context.Context, client *ent.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// A failed sweep is retried at the next tick, without
+				// holding back the others
+				if n, err := sweepRevokedTokens(ctx, client); err != nil {
+					slog.Error("failed sweeping revoked tokens", "error", err)
+				} else if n > 0 {
+					slog.Info("purged expired revoked tokens", "count", n)
+				}
+
+				if n, err := sweepRefreshTokens(ctx, client); err != nil {
+					slog.Error("failed sweeping refresh tokens", "error", err)
+				} else if n > 0 {
+					slog.Info("purged expired refresh tokens", "count", n)
+				}
+
+				if n, err := sweepPasswordResets(ctx, client); err != nil {
+					slog.Error("failed sweeping password resets", "error", err)
+				} else if n > 0 {
+					slog.Info("purged expired password resets", "count", n)
+				}
+			}
+		}
+	}()
+}
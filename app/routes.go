@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"go/djan/app/ent/user"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// route is an endpoint registered on the router, as listed by the routes command
+type route struct {
+	Method  string
+	Path    string
+	Handler string
+	Scopes  []string
+}
+
+// routeMux is a http.ServeMux which records the routes registered on it,
+// with the prefix it is mounted at
+type routeMux struct {
+	*http.ServeMux
+	prefix string
+	routes *[]route
+}
+
+func newRouteMux() *routeMux {
+	return &routeMux{ServeMux: http.NewServeMux(), routes: &[]route{}}
+}
+
+// group returns a routeMux for the routes under the prefix, to be mounted
+// with mount
+func (m *routeMux) group(prefix string) *routeMux {
+	return &routeMux{ServeMux: http.NewServeMux(), prefix: m.prefix + prefix, routes: m.routes}
+}
+
+// mount serves the routes of the group, behind the given middlewares
+func (m *routeMux) mount(g *routeMux, middlewares ...Middleware) {
+	prefix := strings.TrimPrefix(g.prefix, m.prefix)
+	m.ServeMux.Handle(prefix+"/", http.StripPrefix(prefix, createStack(middlewares...)(g)))
+}
+
+// HandleFunc registers the handler behind the given middlewares
+func (m *routeMux) HandleFunc(pattern string, handler http.HandlerFunc, middlewares ...Middleware) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		method, path = "*", pattern
+	}
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	rt := route{
+		Method:  method,
+		Path:    m.prefix + path,
+		Handler: name[strings.LastIndex(name, ".")+1:],
+	}
+
+	var h http.Handler = handler
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+		if scoped, ok := h.(scopedHandler); ok {
+			rt.Scopes = append(scoped.requiredScopes(), rt.Scopes...)
+		}
+	}
+	*m.routes = append(*m.routes, rt)
+
+	m.ServeMux.Handle(pattern, rt.record(h))
+}
+
+// record adds the route pattern to the logger of the request, and hands it
+// to the middlewares which called withMatchedRoute
+func (rt route) record(next http.Handler) http.Handler {
+	pattern := rt.Path
+	if rt.Method != "*" {
+		pattern = rt.Method + " " + rt.Path
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addLogAttrs(r.Context(), "route", pattern)
+		if matched, ok := r.Context().Value(matchedRouteContextKey{}).(*string); ok {
+			*matched = pattern
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type matchedRouteContextKey struct{}
+
+// withMatchedRoute returns a context in which the pattern of the route
+// matched by the muxes is recorded, empty if none matched. Middlewares
+// calling it share the same pattern.
+func withMatchedRoute(ctx context.Context) (context.Context, *string) {
+	if matched, ok := ctx.Value(matchedRouteContextKey{}).(*string); ok {
+		return ctx, matched
+	}
+	matched := new(string)
+	return context.WithValue(ctx, matchedRouteContextKey{}, matched), matched
+}
+
+// Routes returns the routes registered on the mux and its groups
+func (m *routeMux) Routes() []route {
+	return *m.routes
+}
+
+// newRouter registers the endpoints of the API, served behind the given
+// middlewares, and the operational endpoints and public keys, served without
+// them as scrapers, probes and other services send no Origin
+func newRouter(middlewares ...Middleware) *routeMux {
+	// Ordering by most specific wins, instead of top down approach
+	// panics if both routes are equally as specific
+	root := newRouteMux()
+	root.HandleFunc("GET /metrics", metricsHandler)
+	root.HandleFunc("GET /healthz", healthzHandler)
+	root.HandleFunc("GET /readyz", readyzHandler)
+	// Other services verify public tokens with these keys
+	root.HandleFunc("GET /.well-known/paseto-keys", publicKeysHandler)
+
+	// Logins are further limited by user name, in loginHandler
+	limitByIP := rateLimit(authLimiters.ip, clientIP)
+
+	router := root.group("")
+	api_router := router.group("/api")
+
+	// Every API route declares the scopes the token or API key needs. Writes
+	// require a verified email address, but for the password and address of
+	// the user, so that a mistyped address can be fixed.
+	read, write := requireScopes(ScopeUserRead), requireScopes(ScopeUserWrite)
+	user_router := api_router.group("/user")
+	user_router.HandleFunc("GET /", getUsers, read)
+	// The credentials of the user can't be changed with an API key
+	user_router.HandleFunc("POST /me/password", changePassword, write, rejectAPIKeys)
+	user_router.HandleFunc("POST /me/email/verification", resendEmailVerification, write, limitByIP)
+	user_router.HandleFunc("POST /me/mfa/totp", enrollTOTP, write, rejectAPIKeys)
+	user_router.HandleFunc("POST /me/mfa/totp/confirm", confirmTOTP, write, rejectAPIKeys)
+	user_router.HandleFunc("DELETE /me/mfa/totp", disableTOTP, write, rejectAPIKeys)
+	user_router.HandleFunc("POST /me/mfa/recovery-codes", regenerateRecoveryCodes, write, rejectAPIKeys)
+	user_router.HandleFunc("GET /me/api-keys", listAPIKeys, read)
+	user_router.HandleFunc("POST /me/api-keys", createAPIKey, write, rejectAPIKeys)
+	user_router.HandleFunc("DELETE /me/api-keys/{id}", revokeAPIKey, write, rejectAPIKeys)
+	user_router.HandleFunc("GET /{id}", getUserById, read)
+	user_router.HandleFunc("PATCH /{id}", updateUserById, write, requireVerifiedEmailBut("email"))
+	user_router.HandleFunc("DELETE /{id}", deleteUserById, write, requireVerifiedEmail, rejectAPIKeys)
+
+	friends_router := api_router.group("/friend")
+	friends_router.HandleFunc("POST /", addFriendById, write, requireVerifiedEmail)
+	friends_router.HandleFunc("DELETE /", deleteFriendById, write, requireVerifiedEmail)
+
+	read, write = requireScopes(ScopeBlogRead), requireScopes(ScopeBlogWrite)
+	blog_router := api_router.group("/blog")
+	blog_router.HandleFunc("GET /", getBlogs, read)
+	blog_router.HandleFunc("GET /{id}", getBlogById, read)
+	blog_router.HandleFunc("GET /search", searchBlogs, read)
+	blog_router.HandleFunc("POST /", createBlog, write, requireVerifiedEmail)
+	blog_router.HandleFunc("PATCH /{id}", updateBlogById, write, requireVerifiedEmail)
+	blog_router.HandleFunc("DELETE /{id}", deleteByBlogId, write, requireVerifiedEmail)
+
+	read, write = requireScopes(ScopeTagRead), requireScopes(ScopeTagWrite)
+	tags_router := api_router.group("/tag")
+	tags_router.HandleFunc("PATCH /{id}", updateTagById, write, requireVerifiedEmail, requireRoles(user.RoleAdmin, user.RoleEditor))
+	tags_router.HandleFunc("GET /", getTags, read)
+
+	admin := requireScopes(ScopeUserAdmin)
+	admin_router := api_router.group("/admin")
+	admin_router.HandleFunc("PUT /user/{id}/role", grantRole, admin)
+	admin_router.HandleFunc("DELETE /user/{id}/role", revokeRole, admin)
+
+	api_router.mount(user_router)
+	api_router.mount(blog_router)
+	api_router.mount(friends_router)
+	api_router.mount(tags_router)
+	api_router.mount(admin_router, requireRoles(user.RoleAdmin))
+
+	login_router := router.group("/auth")
+	login_router.HandleFunc("POST /signout/", signOutHandler)
+	login_router.HandleFunc("POST /login/", loginHandler, limitByIP)
+	login_router.HandleFunc("POST /login/mfa/", loginMFAHandler, limitByIP)
+	login_router.HandleFunc("GET /oidc/login/", oidcLogin, limitByIP)
+	login_router.HandleFunc("POST /oidc/callback/", oidcCallback, limitByIP)
+	login_router.HandleFunc("POST /signup/", signUpHandler, limitByIP)
+	login_router.HandleFunc("POST /refresh/", refreshHandler)
+	login_router.HandleFunc("POST /password/forgot/", forgotPassword, limitByIP)
+	login_router.HandleFunc("POST /password/reset/", resetPassword, limitByIP)
+	login_router.HandleFunc("POST /email/verify/", verifyEmail, limitByIP)
+
+	router.mount(login_router)
+	router.mount(api_router, authenticateUser)
+
+	root.mount(router, middlewares...)
+	return root
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"go/djan/app/apierror"
+	"go/djan/app/ent"
+	"go/djan/app/ent/user"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/o1egl/paseto"
+)
+
+// scopeClaim carries the space separated scopes granted to a token
+const scopeClaim = "scope"
+
+const (
+	ScopeBlogRead  = "blog:read"
+	ScopeBlogWrite = "blog:write"
+	ScopeTagRead   = "tag:read"
+	ScopeTagWrite  = "tag:write"
+	ScopeUserRead  = "user:read"
+	ScopeUserWrite = "user:write"
+	ScopeUserAdmin = "user:admin"
+)
+
+// apiScopes are the scopes tokens and API keys may carry
+var apiScopes = []string{
+	ScopeBlogRead, ScopeBlogWrite,
+	ScopeTagRead, ScopeTagWrite,
+	ScopeUserRead, ScopeUserWrite,
+	ScopeUserAdmin,
+}
+
+// scopesOf returns the scopes the user may grant, which logins grant
+func scopesOf(u *ent.User) []string {
+	return roleScopes(effectiveRole(u))
+}
+
+// roleScopes returns the scopes of a role, user:admin is reserved to admins
+func roleScopes(role user.Role) []string {
+	if role == user.RoleAdmin {
+		return apiScopes
+	}
+	return slices.DeleteFunc(slices.Clone(apiScopes), func(s string) bool { return s == ScopeUserAdmin })
+}
+
+// errNoGrantedScope answers logins and refreshes asking only for scopes the
+// user lacks
+var errNoGrantedScope = apierror.New(http.StatusForbidden, apierror.CodeInsufficientScope, "The requested scope grants nothing the user may grant")
+
+// parseScope splits a space separated scope, nil when blank
+func parseScope(scope string) []string {
+	if strings.TrimSpace(scope) == "" {
+		return nil
+	}
+	return strings.Fields(scope)
+}
+
+// validateScope checks that a space separated scope only holds known scopes
+func validateScope(v *validator, field, scope string) {
+	for _, s := range strings.Fields(scope) {
+		if !slices.Contains(apiScopes, s) {
+			v.add(field, apierror.FieldInvalidChoice, "must only hold "+strings.Join(apiScopes, ", "))
+			return
+		}
+	}
+}
+
+// narrowScopes returns the scopes in both lists, nil standing for no
+// narrowing
+func narrowScopes(scopes, within []string) []string {
+	if scopes == nil {
+		return within
+	}
+	if within == nil {
+		return scopes
+	}
+	return slices.DeleteFunc(slices.Clone(scopes), func(s string) bool { return !slices.Contains(within, s) })
+}
+
+// tokenScopes returns the scopes granted to a token. Access tokens minted
+// before scopes existed have none and grant the scopes of their role until
+// they expire.
+func tokenScopes(jsonToken *paseto.JSONToken) []string {
+	scope := jsonToken.Get(scopeClaim)
+	if scope == "" {
+		return roleScopes(user.Role(jsonToken.Get(roleClaim)))
+	}
+	return strings.Fields(scope)
+}
+
+// scopedHandler is implemented by the handlers of requireScopes, so that the
+// router lists the scopes of its routes
+type scopedHandler interface {
+	requiredScopes() []string
+}
+
+type scopeHandler struct {
+	scopes []string
+	next   http.Handler
+}
+
+func (h *scopeHandler) requiredScopes() []string {
+	return h.scopes
+}
+
+func (h *scopeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	jsonToken := GetTokenFromContext(r.Context())
+	if jsonToken == nil {
+		writeError(w, r, apierror.Unauthorized(apierror.CodeUnauthorized, "Authentication required"))
+		return
+	}
+	granted := tokenScopes(jsonToken)
+	for _, scope := range h.scopes {
+		if !slices.Contains(granted, scope) {
+			// As RFC 6750 tells OAuth clients which scope to ask for
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, strings.Join(h.scopes, " ")))
+			writeError(w, r, apierror.New(http.StatusForbidden, apierror.CodeInsufficientScope,
+				fmt.Sprintf("The token lacks the %s scope", scope)))
+			return
+		}
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+// requireScopes only lets through requests whose token or API key carries all
+// the given scopes. It must be wrapped by authenticateUser.
+func requireScopes(scopes ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return &scopeHandler{scopes: scopes, next: next}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"go/djan/app/apierror"
+)
+
+func TestLoginNarrowsScopes(t *testing.T) {
+	client := newTestClient(t)
+	password, err := hashPassword("s3cret-pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.User.Create().SetName("alice").SetPassword(password).ExecX(context.Background())
+
+	login, refresh := http.HandlerFunc(loginHandler), http.HandlerFunc(refreshHandler)
+	scopeOf := func(out M) string {
+		t.Helper()
+		jsonToken, err := parseAccessToken(out["token"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return jsonToken.Get(scopeClaim)
+	}
+
+	code, out := doJSON(t, login, "POST", "/auth/login/", "", M{"name": "alice", "password": "s3cret-pass", "scope": "tag:write blog:read user:admin"})
+	if code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	// user:admin is reserved to admins
+	if got := scopeOf(out); got != "blog:read tag:write" {
+		t.Errorf("login granted %q", got)
+	}
+
+	// Rotations keep the scopes of the login
+	code, out = doJSON(t, refresh, "POST", "/auth/refresh/", "", M{"refresh_token": out["refresh_token"]})
+	if code != http.StatusOK || scopeOf(out) != "blog:read tag:write" {
+		t.Fatalf("got %d %v", code, out)
+	}
+	code, out = doJSON(t, refresh, "POST", "/auth/refresh/", "", M{"refresh_token": out["refresh_token"], "scope": "blog:read blog:write"})
+	if code != http.StatusOK || scopeOf(out) != "blog:read" {
+		t.Fatalf("got %d %v", code, out)
+	}
+	// and can't widen them
+	narrowed := out["refresh_token"]
+	if code, out := doJSON(t, refresh, "POST", "/auth/refresh/", "", M{"refresh_token": narrowed, "scope": "tag:write"}); code != http.StatusForbidden || out["code"] != apierror.CodeInsufficientScope {
+		t.Errorf("widening refresh: got %d %v", code, out)
+	}
+	code, out = doJSON(t, refresh, "POST", "/auth/refresh/", "", M{"refresh_token": narrowed})
+	if code != http.StatusOK || scopeOf(out) != "blog:read" {
+		t.Fatalf("got %d %v", code, out)
+	}
+
+	// Logins without a scope get every scope of the user
+	code, out = doJSON(t, login, "POST", "/auth/login/", "", M{"name": "alice", "password": "s3cret-pass"})
+	if code != http.StatusOK || len(parseScope(scopeOf(out))) != len(scopesOf(client.User.Query().OnlyX(context.Background()))) {
+		t.Fatalf("got %d %v", code, out)
+	}
+	if code, out := doJSON(t, login, "POST", "/auth/login/", "", M{"name": "alice", "password": "s3cret-pass", "scope": "user:admin"}); code != http.StatusForbidden || out["code"] != apierror.CodeInsufficientScope {
+		t.Errorf("got %d %v", code, out)
+	}
+	if code, out := doJSON(t, login, "POST", "/auth/login/", "", M{"name": "alice", "password": "s3cret-pass", "scope": "blog:read everything"}); code != http.StatusUnprocessableEntity {
+		t.Errorf("got %d %v", code, out)
+	}
+}
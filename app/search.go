@@ -0,0 +1,225 @@
+package main
+
+import (
+	"go/djan/app/apierror"
+	"go/djan/app/ent/blog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"ariga.io/atlas/sql/postgres"
+	atlas "ariga.io/atlas/sql/schema"
+	"entgo.io/ent/dialect"
+	"entgo.io/ent/dialect/sql"
+	"entgo.io/ent/dialect/sql/schema"
+)
+
+const (
+	// searchConfig is the PostgreSQL text search configuration used for blogs
+	searchConfig = "english"
+	// searchVectorExpr generates the search_vector column of blogs, titles
+	// weigh more than descriptions in the ranking
+	searchVectorExpr = "setweight(to_tsvector('english', coalesce(title, '')), 'A') || " +
+		"setweight(to_tsvector('english', coalesce(description, '')), 'B')"
+	// Markers wrapped around the matched terms in snippets
+	snippetStart = "<mark>"
+	snippetStop  = "</mark>"
+)
+
+type BlogSearchResult struct {
+	ID          int       `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Episode     int       `json:"episode,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	Rank        float64   `json:"rank"`
+	Snippet     string    `json:"snippet"`
+}
+
+// searchVectorHook turns the search_vector column of blogs into a column
+// generated by PostgreSQL, which ent has no way to express in the schema
+func searchVectorHook(next schema.Differ) schema.Differ {
+	return schema.DiffFunc(func(current, desired *atlas.Schema) ([]atlas.Change, error) {
+		c, ok := searchVectorColumn(desired)
+		if !ok {
+			return next.Diff(current, desired)
+		}
+		// Only PostgreSQL has a tsvector column to generate
+		if _, ok := c.Type.Type.(*postgres.TextSearchType); !ok {
+			return next.Diff(current, desired)
+		}
+
+		expr := &atlas.GeneratedExpr{Expr: searchVectorExpr, Type: "STORED"}
+		// PostgreSQL normalizes the expression, keep the one it reports so
+		// that the column is not seen as changed on every migration
+		if existing, ok := searchVectorColumn(current); ok {
+			for _, attr := range existing.Attrs {
+				if x, ok := attr.(*atlas.GeneratedExpr); ok {
+					expr = x
+				}
+			}
+		}
+		c.AddAttrs(expr)
+		return next.Diff(current, desired)
+	})
+}
+
+func searchVectorColumn(s *atlas.Schema) (*atlas.Column, bool) {
+	t, ok := s.Table(blog.Table)
+	if !ok {
+		return nil, false
+	}
+	return t.Column(blog.FieldSearchVector)
+}
+
+// searchTerms splits a search query into lowercase words
+func searchTerms(q string) []string {
+	return strings.Fields(strings.ToLower(q))
+}
+
+// tsQuery writes the PostgreSQL text search query of a search, which accepts
+// the web search syntax (quoted phrases, "or" and "-")
+func tsQuery(b *sql.Builder, q string) {
+	b.WriteString("websearch_to_tsquery(").Arg(searchConfig).WriteString("::regconfig").Comma().Arg(q).WriteString(")")
+}
+
+// blogMatches filters blogs matching the search query. PostgreSQL matches the
+// query against the search_vector, other dialects fall back to a case
+// insensitive LIKE of every word on the title or description.
+func blogMatches(q string) func(*sql.Selector) {
+	return func(s *sql.Selector) {
+		if s.Dialect() == dialect.Postgres {
+			s.Where(sql.P(func(b *sql.Builder) {
+				b.Ident(s.C(blog.FieldSearchVector)).WriteString(" @@ ")
+				tsQuery(b, q)
+			}))
+			return
+		}
+		for _, term := range searchTerms(q) {
+			s.Where(sql.Or(
+				sql.ContainsFold(s.C(blog.FieldTitle), term),
+				sql.ContainsFold(s.C(blog.FieldDescription), term),
+			))
+		}
+	}
+}
+
+// selectSearchResult selects the columns of BlogSearchResult. The rank and
+// snippet are computed by PostgreSQL, and in Go for other dialects.
+func selectSearchResult(q string) func(*sql.Selector) {
+	return func(s *sql.Selector) {
+		s.Select(
+			s.C(blog.FieldID),
+			s.C(blog.FieldTitle),
+			s.C(blog.FieldDescription),
+			s.C(blog.FieldCreatedAt),
+		)
+		s.AppendSelectExprAs(sql.ExprFunc(func(b *sql.Builder) {
+			b.WriteString("COALESCE(").Ident(s.C(blog.FieldEpisode)).WriteString(", 0)")
+		}), "episode")
+		if s.Dialect() != dialect.Postgres {
+			s.OrderBy(sql.Desc(s.C(blog.FieldCreatedAt)))
+			return
+		}
+
+		s.AppendSelectExprAs(sql.ExprFunc(func(b *sql.Builder) {
+			b.WriteString("ts_rank(").Ident(s.C(blog.FieldSearchVector)).Comma()
+			tsQuery(b, q)
+			b.WriteString(")")
+		}), "rank")
+		s.AppendSelectExprAs(sql.ExprFunc(func(b *sql.Builder) {
+			b.WriteString("ts_headline(").Arg(searchConfig).WriteString("::regconfig").Comma().
+				Ident(s.C(blog.FieldTitle)).WriteString(" || ' ' || ").Ident(s.C(blog.FieldDescription)).Comma()
+			tsQuery(b, q)
+			b.Comma().Arg("StartSel=" + snippetStart + ", StopSel=" + snippetStop + ", MaxFragments=2")
+			b.WriteString(")")
+		}), "snippet")
+		s.OrderExpr(sql.Expr("rank DESC"))
+	}
+}
+
+// rankFallback ranks and highlights the results of dialects without full-text
+// search by counting the occurrences of the query words
+func rankFallback(results []BlogSearchResult, q string) {
+	terms := searchTerms(q)
+	for i := range results {
+		r := &results[i]
+		title, description := strings.ToLower(r.Title), strings.ToLower(r.Description)
+		for _, term := range terms {
+			// Titles weigh more than descriptions, like the search_vector weights
+			r.Rank += float64(2*strings.Count(title, term) + strings.Count(description, term))
+		}
+		r.Snippet = highlight(r.Title+" "+r.Description, terms)
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Rank > results[j].Rank })
+}
+
+// highlight wraps the case insensitive occurrences of the terms with the snippet markers
+func highlight(text string, terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	re, err := regexp.Compile("(?i)(" + strings.Join(quoted, "|") + ")")
+	if err != nil || len(terms) == 0 {
+		return text
+	}
+	return re.ReplaceAllString(text, snippetStart+"$1"+snippetStop)
+}
+
+func searchBlogs(w http.ResponseWriter, r *http.Request) {
+	client := GetClient()
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeError(w, r, apierror.InvalidParameter("q", "is required"))
+		return
+	}
+	limit := defaultPageLimit
+	l, err := queryInt(r, "limit")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if l != nil {
+		if *l < 1 {
+			writeError(w, r, apierror.InvalidParameter("limit", "must be a positive integer"))
+			return
+		}
+		limit = min(*l, maxPageLimit)
+	}
+
+	// The tag, user_id etc. filters of getBlogs apply to searches too
+	filters, err := blogFilters(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	var results []BlogSearchResult
+	fullText := false
+	err = client.Blog.Query().
+		Where(filters...).
+		Where(blogMatches(q)).
+		Limit(limit).
+		Modify(func(s *sql.Selector) {
+			fullText = s.Dialect() == dialect.Postgres
+			selectSearchResult(q)(s)
+		}).
+		Scan(r.Context(), &results)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if !fullText {
+		rankFallback(results, q)
+	}
+	if results == nil {
+		results = []BlogSearchResult{}
+	}
+
+	writeJSON(w, http.StatusOK, M{"data": results})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSearchBlogsFallback(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	now := time.Now()
+	client.Blog.Create().SetTitle("Cooking pasta").SetDescription("A recipe with garlic").SetCreatedAt(now.Add(-3 * time.Hour)).ExecX(ctx)
+	client.Blog.Create().SetTitle("Garlic bread").SetDescription("Garlic, butter and more garlic").SetCreatedAt(now.Add(-2 * time.Hour)).ExecX(ctx)
+	client.Blog.Create().SetTitle("Gardening").SetDescription("Growing tomatoes").SetCreatedAt(now.Add(-time.Hour)).ExecX(ctx)
+
+	h := http.HandlerFunc(searchBlogs)
+	code, out := doJSON(t, h, "GET", "/api/blog/search?q=GARLIC", "", nil)
+	if code != http.StatusOK {
+		t.Fatal(code, out)
+	}
+	data := out["data"].([]any)
+	if len(data) != 2 {
+		t.Fatalf("got %d results, want 2", len(data))
+	}
+	// The title counts twice, "Garlic bread" ranks 2*1 + 2
+	first, second := data[0].(map[string]any), data[1].(map[string]any)
+	if first["title"] != "Garlic bread" || first["rank"] != 4.0 || second["rank"] != 1.0 {
+		t.Fatal(data)
+	}
+	if first["snippet"] != "<mark>Garlic</mark> bread <mark>Garlic</mark>, butter and more <mark>garlic</mark>" {
+		t.Fatal(first["snippet"])
+	}
+
+	// Every word must match
+	if _, out := doJSON(t, h, "GET", "/api/blog/search?q=garlic+recipe", "", nil); len(out["data"].([]any)) != 1 {
+		t.Fatal(out)
+	}
+	if _, out := doJSON(t, h, "GET", "/api/blog/search?q=nothing", "", nil); len(out["data"].([]any)) != 0 {
+		t.Fatal(out)
+	}
+	if code, _ := doJSON(t, h, "GET", "/api/blog/search?q=+", "", nil); code != http.StatusBadRequest {
+		t.Fatal(code)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	for _, tc := range []struct {
+		text  string
+		terms []string
+		want  string
+	}{
+		{"Go and go", []string{"go"}, "<mark>Go</mark> and <mark>go</mark>"},
+		{"a.b ab", []string{"a.b"}, "<mark>a.b</mark> ab"},
+		{"unchanged", nil, "unchanged"},
+	} {
+		if got := highlight(tc.text, tc.terms); got != tc.want {
+			t.Errorf("highlight(%q, %q) = %q, want %q", tc.text, tc.terms, got, tc.want)
+		}
+	}
+}
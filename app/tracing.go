@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"entgo.io/ent/dialect"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the application. It is a no-op until
+// setupTracing installs a tracer provider.
+var tracer = otel.Tracer("go/djan/app")
+
+// spanExporters are the exporters which can be selected with TRACING_EXPORTER,
+// besides none
+var spanExporters = map[string]func() (sdktrace.SpanExporter, error){
+	"stdout": func() (sdktrace.SpanExporter, error) {
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	},
+}
+
+func tracingExporters() []string {
+	names := []string{"none"}
+	for name := range spanExporters {
+		names = append(names, name)
+	}
+	slices.Sort(names[1:])
+	return names
+}
+
+// newSpanExporter returns the exporter named by the configuration, nil for
+// none
+func newSpanExporter(name string) (sdktrace.SpanExporter, error) {
+	if name == "none" {
+		return nil, nil
+	}
+	newExporter, ok := spanExporters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tracing exporter %q", name)
+	}
+	return newExporter()
+}
+
+// setupTracing installs a tracer provider sending the sampled spans to the
+// exporter, and the W3C trace context propagator. Without exporter spans are
+// not recorded, but the trace context of requests is still propagated. The
+// returned function flushes the pending spans.
+func setupTracing(cfg TracingConfig, exporter sdktrace.SpanExporter) func(context.Context) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	if exporter == nil {
+		return func(context.Context) error { return nil }
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown
+}
+
+// tracing starts a span for every request, as a child of the span of the
+// caller when the request carries a traceparent header. The trace ID is added
+// to the request logger, so it must be wrapped by logging.
+func tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.ClientAddress(clientIP(r)),
+				attribute.String("request_id", GetRequestIDFromContext(ctx)),
+			),
+		)
+		defer span.End()
+		if sc := span.SpanContext(); sc.IsValid() {
+			addLogAttrs(ctx, "trace_id", sc.TraceID().String())
+		}
+
+		ctx, route := withMatchedRoute(ctx)
+		wrappedWriter := &WrappedWriter{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+		next.ServeHTTP(wrappedWriter, r.WithContext(ctx))
+
+		if *route != "" {
+			span.SetName(*route)
+			span.SetAttributes(semconv.HTTPRoute(*route))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(wrappedWriter.statusCode))
+		if wrappedWriter.statusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(wrappedWriter.statusCode))
+		}
+	})
+}
+
+// tracedDriver is an ent driver creating a span for every SQL statement
+type tracedDriver struct {
+	dialect.Driver
+}
+
+func newTracedDriver(drv dialect.Driver) dialect.Driver {
+	return &tracedDriver{drv}
+}
+
+func (d *tracedDriver) Exec(ctx context.Context, query string, args, v any) error {
+	ctx, span := startStatementSpan(ctx, d.Dialect(), query)
+	err := d.Driver.Exec(ctx, query, args, v)
+	endStatementSpan(span, err)
+	return err
+}
+
+func (d *tracedDriver) Query(ctx context.Context, query string, args, v any) error {
+	ctx, span := startStatementSpan(ctx, d.Dialect(), query)
+	err := d.Driver.Query(ctx, query, args, v)
+	endStatementSpan(span, err)
+	return err
+}
+
+func (d *tracedDriver) Tx(ctx context.Context) (dialect.Tx, error) {
+	tx, err := d.Driver.Tx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedTx{Tx: tx, dialect: d.Dialect()}, nil
+}
+
+// BeginTx is called by ent.Client.BeginTx
+func (d *tracedDriver) BeginTx(ctx context.Context, opts *sql.TxOptions) (dialect.Tx, error) {
+	drv, ok := d.Driver.(interface {
+		BeginTx(context.Context, *sql.TxOptions) (dialect.Tx, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("driver %T does not support transaction options", d.Driver)
+	}
+	tx, err := drv.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedTx{Tx: tx, dialect: d.Dialect()}, nil
+}
+
+// tracedTx creates a span for every SQL statement of a transaction
+type tracedTx struct {
+	dialect.Tx
+	dialect string
+}
+
+func (tx *tracedTx) Exec(ctx context.Context, query string, args, v any) error {
+	ctx, span := startStatementSpan(ctx, tx.dialect, query)
+	err := tx.Tx.Exec(ctx, query, args, v)
+	endStatementSpan(span, err)
+	return err
+}
+
+func (tx *tracedTx) Query(ctx context.Context, query string, args, v any) error {
+	ctx, span := startStatementSpan(ctx, tx.dialect, query)
+	err := tx.Tx.Query(ctx, query, args, v)
+	endStatementSpan(span, err)
+	return err
+}
+
+// dbSystems maps the ent dialects to the db.system of the semantic conventions
+var dbSystems = map[string]attribute.KeyValue{
+	dialect.Postgres: semconv.DBSystemPostgreSQL,
+	dialect.SQLite:   semconv.DBSystemSqlite,
+	dialect.MySQL:    semconv.DBSystemMySQL,
+}
+
+// startStatementSpan starts a span named after the operation of the
+// statement. The statement is recorded without its arguments, which may hold
+// passwords and tokens.
+func startStatementSpan(ctx context.Context, dialectName, query string) (context.Context, trace.Span) {
+	operation, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+	operation = strings.ToUpper(operation)
+	attrs := []attribute.KeyValue{
+		semconv.DBOperationName(operation),
+		semconv.DBQueryText(query),
+	}
+	if system, ok := dbSystems[dialectName]; ok {
+		attrs = append(attrs, system)
+	}
+	return tracer.Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+func endStatementSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
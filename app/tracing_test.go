@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"entgo.io/ent/dialect"
+	entsql "entgo.io/ent/dialect/sql"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go/djan/app/ent"
+)
+
+// recordSpans makes tracer record the spans ended until the test ends
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := tracer
+	tracer = provider.Tracer("test")
+	setupTracing(TracingConfig{}, nil)
+	t.Cleanup(func() { tracer = previous })
+	return recorder
+}
+
+func TestTracing(t *testing.T) {
+	newTestClient(t)
+
+	// The statements of the client are traced like GetClient does
+	db, err := sql.Open(dialect.SQLite, "file:tracing?mode=memory&cache=shared&_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := ent.NewClient(ent.Driver(newTracedDriver(entsql.OpenDB(dialect.SQLite, db))))
+	defer client.Close()
+	if err := client.Schema.Create(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	clientInstance = client
+	recorder := recordSpans(t)
+
+	req := httptest.NewRequest("POST", "/auth/login/", strings.NewReader(`{"name": "alice", "password": "s3cret-pass"}`))
+	req.Header.Set("Origin", "http://localhost")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	newRouter(requestID, logging, tracing, CORSMiddleware).ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatal(rec.Code, rec.Body)
+	}
+
+	var server sdktrace.ReadOnlySpan
+	var statements []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		switch span.SpanKind() {
+		case trace.SpanKindServer:
+			server = span
+		case trace.SpanKindClient:
+			statements = append(statements, span)
+		}
+	}
+	if server == nil {
+		t.Fatal("no server span")
+	}
+	if server.Name() != "POST /auth/login/" || !hasAttr(server, semconv.HTTPRoute("POST /auth/login/")) {
+		t.Errorf("server span %q, attributes %v", server.Name(), server.Attributes())
+	}
+	if !hasAttr(server, semconv.HTTPResponseStatusCode(http.StatusUnauthorized)) {
+		t.Errorf("server span attributes %v", server.Attributes())
+	}
+	if got := server.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace ID %s, want the one of traceparent", got)
+	}
+	if got := server.Parent().SpanID().String(); got != "00f067aa0ba902b7" || !server.Parent().IsRemote() {
+		t.Errorf("parent span %s, want the one of traceparent", got)
+	}
+
+	if len(statements) == 0 {
+		t.Fatal("no statement span")
+	}
+	statement := statements[0]
+	if statement.Name() != "SELECT" || statement.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("statement span %q with parent %s", statement.Name(), statement.Parent().SpanID())
+	}
+	if !hasAttr(statement, semconv.DBSystemSqlite) || !hasAttr(statement, semconv.DBOperationName("SELECT")) {
+		t.Errorf("statement span attributes %v", statement.Attributes())
+	}
+	for _, attr := range statement.Attributes() {
+		if attr.Key != semconv.DBQueryTextKey {
+			continue
+		}
+		if query := attr.Value.AsString(); !strings.Contains(query, "?") || strings.Contains(query, "alice") {
+			t.Errorf("query text %q, want the statement without its arguments", query)
+		}
+	}
+}
+
+func hasAttr(span sdktrace.ReadOnlySpan, want attribute.KeyValue) bool {
+	for _, attr := range span.Attributes() {
+		if attr.Key == want.Key && attr.Value.Emit() == want.Value.Emit() {
+			return true
+		}
+	}
+	return false
+}
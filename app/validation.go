@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"go/djan/app/apierror"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// validatable requests check their fields once decoded by decodeJSON
+type validatable interface {
+	validate(v *validator)
+}
+
+// validator collects the field errors of a request, so that they are all
+// reported at once
+type validator struct {
+	errs []apierror.FieldError
+}
+
+func (v *validator) add(field, code, message string) {
+	v.errs = append(v.errs, apierror.FieldError{Field: field, Code: code, Message: message})
+}
+
+// err returns the validation error of the collected field errors, if any
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return apierror.Validation(v.errs...)
+}
+
+// required reports whether the field is present, adding an error if not
+func (v *validator) required(field string, present bool) bool {
+	if !present {
+		v.add(field, apierror.FieldRequired, "is required")
+	}
+	return present
+}
+
+// length checks the length of the value, in bytes like ent. A max of 0
+// leaves the length unbounded.
+func (v *validator) length(field, value string, min, max int) {
+	switch {
+	case len(value) < min && min == 1:
+		v.add(field, apierror.FieldRequired, "must not be empty")
+	case len(value) < min:
+		v.add(field, apierror.FieldTooShort, fmt.Sprintf("must be at least %d characters long", min))
+	case max > 0 && len(value) > max:
+		v.add(field, apierror.FieldTooLong, fmt.Sprintf("must be at most %d characters long", max))
+	}
+}
+
+func (v *validator) match(field, value string, re *regexp.Regexp) {
+	if !re.MatchString(value) {
+		v.add(field, apierror.FieldInvalidFormat, "contains characters which are not allowed")
+	}
+}
+
+func (v *validator) positive(field string, value int) {
+	if value <= 0 {
+		v.add(field, apierror.FieldOutOfRange, "must be positive")
+	}
+}
+
+// oneOf reports the error of an ent enum validator
+func (v *validator) oneOf(field string, err error, values ...string) {
+	if err != nil {
+		v.add(field, apierror.FieldInvalidChoice, "must be one of "+strings.Join(values, ", "))
+	}
+}
+
+// decodeJSON reads the JSON body of the request with readJSON and validates
+// it when the request type is validatable
+func decodeJSON[T any](w http.ResponseWriter, r *http.Request) (T, error) {
+	var dst T
+	if err := readJSON(w, r, &dst); err != nil {
+		return dst, err
+	}
+	if req, ok := any(&dst).(validatable); ok {
+		v := &validator{}
+		req.validate(v)
+		return dst, v.err()
+	}
+	return dst, nil
+}
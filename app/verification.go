@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/djan/app/apierror"
+	"go/djan/app/ent"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/o1egl/paseto"
+)
+
+const (
+	// verifyEmailPurpose is the purpose of the tokens verifying an address
+	verifyEmailPurpose = "verify_email"
+	// emailClaim carries the address a verification token was issued for, so
+	// that changing the address voids the links sent for the previous one
+	emailClaim = "email"
+)
+
+var errEmailNotVerified = apierror.New(http.StatusForbidden, apierror.CodeEmailNotVerified, "Verify your email address first")
+
+// isVerified reports whether u verified its email address. Users without an
+// address predating email verification were marked verified by its migration.
+func isVerified(u *ent.User) bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// requireVerifiedEmail refuses the requests of users who didn't verify their
+// email address. It must be wrapped by authenticateUser.
+func requireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := GetUserFromContext(r.Context())
+		if user == nil {
+			writeError(w, r, apierror.Unauthorized(apierror.CodeUnauthorized, "Authentication required"))
+			return
+		}
+		if !isVerified(user) {
+			writeError(w, r, errEmailNotVerified)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireVerifiedEmailBut is requireVerifiedEmail letting unverified users
+// through when the JSON body of the request sets nothing but the given
+// fields, so that a mistyped address can be fixed
+func requireVerifiedEmailBut(fields ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		verified := requireVerifiedEmail(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := GetUserFromContext(r.Context())
+			if user == nil || isVerified(user) {
+				verified.ServeHTTP(w, r)
+				return
+			}
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+			if err != nil {
+				writeError(w, r, decodeError(err))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			var set map[string]json.RawMessage
+			if err := json.Unmarshal(body, &set); err != nil {
+				verified.ServeHTTP(w, r)
+				return
+			}
+			for name := range set {
+				if !slices.Contains(fields, name) {
+					verified.ServeHTTP(w, r)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// issueVerificationToken mints the token verifying the current address of u
+func issueVerificationToken(u *ent.User) (string, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	jsonToken := paseto.JSONToken{
+		Jti:        jti,
+		Subject:    strconv.Itoa(u.ID),
+		IssuedAt:   now,
+		Expiration: now.Add(config.Auth.EmailVerificationLifetime),
+	}
+	jsonToken.Set(purposeClaim, verifyEmailPurpose)
+	jsonToken.Set(emailClaim, *u.Email)
+	return encryptToken(jsonToken)
+}
+
+const emailVerificationMail = `Hello %s,
+
+Confirm that this address belongs to your account at the following link,
+which expires in %s:
+
+%s
+
+If you didn't sign up, ignore this email.
+`
+
+// sendEmailVerification mails a verification link to the address of u
+func sendEmailVerification(ctx context.Context, u *ent.User) error {
+	if u.Email == nil {
+		return errors.New("user has no email address")
+	}
+	token, err := issueVerificationToken(u)
+	if err != nil {
+		return err
+	}
+
+	link, err := url.Parse(config.Auth.EmailVerificationURL)
+	if err != nil {
+		return err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return mailer.Send(ctx, Message{
+		From:    config.Mail.From,
+		To:      *u.Email,
+		Subject: "Verify your email address",
+		Body:    fmt.Sprintf(emailVerificationMail, u.Name, config.Auth.EmailVerificationLifetime, link),
+	})
+}
+
+type EmailVerificationRequest struct {
+	Token string `json:"token"`
+}
+
+func (req *EmailVerificationRequest) validate(v *validator) {
+	v.required("token", req.Token != "")
+}
+
+// verifyEmail marks the address of a verification token as verified, as long
+// as it is still the address of the user
+func verifyEmail(w http.ResponseWriter, r *http.Request) {
+	client := GetClient()
+	verify_json, err := decodeJSON[EmailVerificationRequest](w, r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	invalidToken := apierror.Unauthorized(apierror.CodeInvalidToken, "The verification token is invalid or has expired")
+	jsonToken, err := decryptToken(verify_json.Token)
+	if err != nil || jsonToken.Get(purposeClaim) != verifyEmailPurpose || jsonToken.Expiration.Before(time.Now()) {
+		writeError(w, r, invalidToken)
+		return
+	}
+	user_id, err := strconv.Atoi(jsonToken.Subject)
+	if err != nil {
+		writeError(w, r, invalidToken)
+		return
+	}
+
+	user, err := client.User.Get(r.Context(), user_id)
+	if ent.IsNotFound(err) {
+		writeError(w, r, invalidToken)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if user.Email == nil || !strings.EqualFold(*user.Email, jsonToken.Get(emailClaim)) {
+		writeError(w, r, invalidToken)
+		return
+	}
+
+	// Following the link twice is not an error
+	if !isVerified(user) {
+		if err := client.User.UpdateOne(user).SetEmailVerifiedAt(time.Now()).Exec(r.Context()); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, M{"message": "Email address verified"})
+}
+
+// resendEmailVerification mails a new verification link to the address of
+// the authenticated user
+func resendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	user := GetUserFromContext(r.Context())
+	if user.Email == nil {
+		writeError(w, r, apierror.BadRequest("Set an email address first"))
+		return
+	}
+	if isVerified(user) {
+		writeError(w, r, apierror.Conflict("The email address is already verified"))
+		return
+	}
+	if err := sendEmailVerification(r.Context(), user); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, M{"message": "A verification link was sent to your email address"})
+}
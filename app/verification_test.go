@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go/djan/app/apierror"
+)
+
+func TestUpdateUnverifiedUser(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	u := client.User.Create().SetName("alice").SetPassword("hash").SetEmail("alice@exmaple.com").SaveX(ctx)
+	token, err := issueAccessToken(u, "session", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := newRouter(CORSMiddleware)
+	path := "/api/user/" + strconv.Itoa(u.ID)
+	for _, body := range []M{
+		{"name": "alicia"},
+		{"name": "alicia", "email": "alice@example.com"},
+		{"email": "alice@example.com", "Age": 30},
+	} {
+		if code, out := doJSON(t, h, "PATCH", path, token, body); code != http.StatusForbidden || out["code"] != apierror.CodeEmailNotVerified {
+			t.Errorf("%v: got %d %v", body, code, out)
+		}
+	}
+
+	// Fixing a mistyped address needs no verified one
+	if code, out := doJSON(t, h, "PATCH", path, token, M{"email": "alice@example.com"}); code != http.StatusOK {
+		t.Fatalf("got %d %v", code, out)
+	}
+	if u := client.User.GetX(ctx, u.ID); *u.Email != "alice@example.com" || u.Name != "alice" {
+		t.Errorf("got %s <%s>", u.Name, *u.Email)
+	}
+
+	// The body is read to check its fields, up to the size of any other
+	if code, out := doJSON(t, h, "PATCH", path, token, M{"email": strings.Repeat("a", maxBodyBytes)}); code != http.StatusRequestEntityTooLarge || out["code"] != apierror.CodeMalformedJSON {
+		t.Errorf("oversized body: got %d %v", code, out)
+	}
+}